@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInactive = errors.New("api key is revoked or expired")
+)
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, wallet_ids, COALESCE(rotated_from, 0), created_at, expires_at, revoked_at`
+
+type APIKeyAccess struct{}
+
+// 保存新的 API key
+func (ka *APIKeyAccess) CreateAPIKey(ctx context.Context, db *sql.DB, key *APIKey) error {
+	if key.WalletIDs == nil {
+		key.WalletIDs = []int64{}
+	}
+	return db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, wallet_ids, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), pq.Array(key.WalletIDs), key.ExpiresAt).
+		Scan(&key.ID, &key.CreatedAt)
+}
+
+// 根据哈希查找 API key
+func (ka *APIKeyAccess) GetAPIKeyByHash(ctx context.Context, db *sql.DB, hash string) (*APIKey, error) {
+	key, err := scanAPIKey(db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1", hash))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// 获取全部 API key
+func (ka *APIKeyAccess) GetAPIKeys(ctx context.Context, db *sql.DB) ([]APIKey, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// 吊销 API key，重复吊销保留第一次的时间
+func (ka *APIKeyAccess) RevokeAPIKey(ctx context.Context, db *sql.DB, keyID int64) error {
+	res, err := db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1", keyID, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// 轮换 API key：新 key 继承旧 key 的名称、scope 和钱包限制，旧 key 在 oldExpiresAt 之前仍然有效
+func (ka *APIKeyAccess) RotateAPIKey(ctx context.Context, db *sql.DB, keyID int64, newKey *APIKey, oldExpiresAt time.Time) error {
+	// 开始事务
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	// 锁定旧 key，避免同时轮换
+	old, err := scanAPIKey(tx.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1 FOR UPDATE", keyID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrAPIKeyNotFound
+		}
+		return err
+	}
+	if !old.Active(time.Now()) {
+		return ErrAPIKeyInactive
+	}
+
+	newKey.Name, newKey.Scopes, newKey.WalletIDs, newKey.RotatedFrom = old.Name, old.Scopes, old.WalletIDs, old.ID
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO api_keys (name, prefix, key_hash, scopes, wallet_ids, rotated_from, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, newKey.Name, newKey.Prefix, newKey.Hash, pq.Array(newKey.Scopes), pq.Array(newKey.WalletIDs), newKey.RotatedFrom, newKey.ExpiresAt).
+		Scan(&newKey.ID, &newKey.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	// 旧 key 原本更早过期时保留原来的时间
+	if _, err := tx.ExecContext(ctx, "UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $2), $2) WHERE id = $1", keyID, oldExpiresAt); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.Hash, pq.Array(&key.Scopes), pq.Array(&key.WalletIDs),
+		&key.RotatedFrom, &key.CreatedAt, &key.ExpiresAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+var apiKeyRowColumns = []string{"id", "name", "prefix", "key_hash", "scopes", "wallet_ids", "rotated_from", "created_at", "expires_at", "revoked_at"}
+
+func TestCreateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO api_keys \\(name, prefix, key_hash, scopes, wallet_ids, expires_at\\)").
+		WithArgs("billing", "wk_abcdefgh", "hash", sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+
+	ka := &APIKeyAccess{}
+	key := APIKey{Name: "billing", Prefix: "wk_abcdefgh", Hash: "hash", Scopes: []string{PermReadBalance}}
+	if err := ka.CreateAPIKey(context.Background(), db, &key); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if key.ID != 3 || key.WalletIDs == nil {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAPIKeyByHash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, prefix, key_hash, .* FROM api_keys WHERE key_hash = \\$1").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).
+			AddRow(3, "billing", "wk_abcdefgh", "hash", "{read_balance,transfer}", "{1,2}", 0, time.Now(), nil, nil))
+	mock.ExpectQuery("SELECT id, name, prefix, key_hash, .* FROM api_keys WHERE key_hash = \\$1").
+		WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows(apiKeyRowColumns))
+
+	ka := &APIKeyAccess{}
+	key, err := ka.GetAPIKeyByHash(context.Background(), db, "hash")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(key.Scopes) != 2 || key.Scopes[1] != PermTransfer || len(key.WalletIDs) != 2 || key.WalletIDs[1] != 2 {
+		t.Errorf("unexpected key %+v", key)
+	}
+	if _, err := ka.GetAPIKeyByHash(context.Background(), db, "missing"); err != ErrAPIKeyNotFound {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRevokeAPIKey_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE api_keys SET revoked_at = COALESCE\\(revoked_at, \\$2\\) WHERE id = \\$1").
+		WithArgs(int64(9), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ka := &APIKeyAccess{}
+	if err := ka.RevokeAPIKey(context.Background(), db, 9); err != ErrAPIKeyNotFound {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	oldExpiresAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, .* FROM api_keys WHERE id = \\$1 FOR UPDATE").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).
+			AddRow(3, "billing", "wk_abcdefgh", "hash", "{read_balance}", "{1}", 0, time.Now(), nil, nil))
+	mock.ExpectQuery("INSERT INTO api_keys \\(name, prefix, key_hash, scopes, wallet_ids, rotated_from, expires_at\\)").
+		WithArgs("billing", "wk_newnewne", "newhash", pq.Array([]string{PermReadBalance}), pq.Array([]int64{1}), int64(3), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+	mock.ExpectExec("UPDATE api_keys SET expires_at = LEAST\\(COALESCE\\(expires_at, \\$2\\), \\$2\\) WHERE id = \\$1").
+		WithArgs(int64(3), oldExpiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ka := &APIKeyAccess{}
+	key := APIKey{Prefix: "wk_newnewne", Hash: "newhash"}
+	if err := ka.RotateAPIKey(context.Background(), db, 3, &key, oldExpiresAt); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if key.ID != 4 || key.RotatedFrom != 3 || key.Name != "billing" {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRotateAPIKey_Revoked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, .* FROM api_keys WHERE id = \\$1 FOR UPDATE").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).
+			AddRow(3, "billing", "wk_abcdefgh", "hash", "{read_balance}", "{}", 0, time.Now(), nil, time.Now()))
+	mock.ExpectRollback()
+
+	ka := &APIKeyAccess{}
+	if err := ka.RotateAPIKey(context.Background(), db, 3, &APIKey{}, time.Now()); err != ErrAPIKeyInactive {
+		t.Errorf("expected ErrAPIKeyInactive, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
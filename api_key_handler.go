@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 轮换时旧 key 默认继续有效的时间
+const defaultRotationOverlap = 24 * time.Hour
+
+// 签发 API key，明文只在响应中返回一次
+func (a *App) createAPIKeyHandler(c *gin.Context) {
+	var request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		WalletIDs []int64    `json:"wallet_ids"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" || len(request.Name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key name"})
+		return
+	}
+	if len(request.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scopes are required"})
+		return
+	}
+	for _, scope := range request.Scopes {
+		if !apiKeyScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid scope: " + scope})
+			return
+		}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	plaintext, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key := APIKey{
+		Name:      request.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    request.Scopes,
+		WalletIDs: request.WalletIDs,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if err := a.Ak.CreateAPIKey(c.Request.Context(), a.DB, &key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "key": plaintext})
+}
+
+// 查询全部 API key，不包含明文和哈希
+func (a *App) getAPIKeysHandler(c *gin.Context) {
+	keys, err := a.Ak.GetAPIKeys(c.Request.Context(), a.DB)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// 吊销 API key，立即生效
+func (a *App) revokeAPIKeyHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.Ak.RevokeAPIKey(c.Request.Context(), a.DB, req.Id); err != nil {
+		if err == ErrAPIKeyNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+}
+
+// 轮换 API key：签发新 key，旧 key 在重叠期内继续有效
+func (a *App) rotateAPIKeyHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var request struct {
+		Overlap string `json:"overlap"`
+	}
+	// 请求体可以为空，此时使用默认的重叠期；不为空时必须是合法的 JSON
+	if c.Request.Body != nil && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	overlap := defaultRotationOverlap
+	if request.Overlap != "" {
+		d, err := time.ParseDuration(request.Overlap)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid overlap"})
+			return
+		}
+		overlap = d
+	}
+
+	plaintext, prefix, hash, err := generateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	key := APIKey{Prefix: prefix, Hash: hash}
+	oldExpiresAt := time.Now().Add(overlap)
+	if err := a.Ak.RotateAPIKey(c.Request.Context(), a.DB, req.Id, &key, oldExpiresAt); err != nil {
+		switch err {
+		case ErrAPIKeyNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case ErrAPIKeyInactive:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "key": plaintext, "old_key_expires_at": oldExpiresAt})
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 在内存中保存 API key，便于检查签发、吊销和轮换
+type MockAPIKeyRepo struct {
+	keys []*APIKey
+}
+
+// 添加一个明文已知的 key
+func (m *MockAPIKeyRepo) add(plaintext string, key APIKey) {
+	key.ID = int64(len(m.keys) + 1)
+	key.Hash = hashAPIKey(plaintext)
+	if key.WalletIDs == nil {
+		key.WalletIDs = []int64{}
+	}
+	m.keys = append(m.keys, &key)
+}
+
+func (m *MockAPIKeyRepo) CreateAPIKey(ctx context.Context, db *sql.DB, key *APIKey) error {
+	// 与数据库一致，未限制钱包时保存为空数组
+	if key.WalletIDs == nil {
+		key.WalletIDs = []int64{}
+	}
+	key.ID = int64(len(m.keys) + 1)
+	key.CreatedAt = time.Now()
+	copied := *key
+	m.keys = append(m.keys, &copied)
+	return nil
+}
+
+func (m *MockAPIKeyRepo) GetAPIKeyByHash(ctx context.Context, db *sql.DB, hash string) (*APIKey, error) {
+	for _, key := range m.keys {
+		if key.Hash == hash {
+			copied := *key
+			return &copied, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+func (m *MockAPIKeyRepo) GetAPIKeys(ctx context.Context, db *sql.DB) ([]APIKey, error) {
+	var keys []APIKey
+	for _, key := range m.keys {
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+func (m *MockAPIKeyRepo) RevokeAPIKey(ctx context.Context, db *sql.DB, keyID int64) error {
+	if keyID < 1 || keyID > int64(len(m.keys)) {
+		return ErrAPIKeyNotFound
+	}
+	now := time.Now()
+	m.keys[keyID-1].RevokedAt = &now
+	return nil
+}
+
+func (m *MockAPIKeyRepo) RotateAPIKey(ctx context.Context, db *sql.DB, keyID int64, newKey *APIKey, oldExpiresAt time.Time) error {
+	if keyID < 1 || keyID > int64(len(m.keys)) {
+		return ErrAPIKeyNotFound
+	}
+	old := m.keys[keyID-1]
+	if !old.Active(time.Now()) {
+		return ErrAPIKeyInactive
+	}
+	newKey.Name, newKey.Scopes, newKey.WalletIDs, newKey.RotatedFrom = old.Name, old.Scopes, old.WalletIDs, old.ID
+	old.ExpiresAt = &oldExpiresAt
+	return m.CreateAPIKey(context.Background(), db, newKey)
+}
+
+func newMockAPIKeyRepo() *MockAPIKeyRepo {
+	past := time.Now().Add(-time.Hour)
+	m := &MockAPIKeyRepo{}
+	m.add("wk_reader", APIKey{Name: "reader", Scopes: []string{PermReadBalance, PermReadTransactions}})
+	m.add("wk_wallet2", APIKey{Name: "wallet2", Scopes: []string{PermReadBalance}, WalletIDs: []int64{2}})
+	m.add("wk_revoked", APIKey{Name: "revoked", Scopes: []string{PermReadBalance}, RevokedAt: &past})
+	m.add("wk_expired", APIKey{Name: "expired", Scopes: []string{PermReadBalance}, ExpiresAt: &past})
+	return m
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Us: &MockUserRepo{}, Ak: newMockAPIKeyRepo()}
+	router.Use(a.authMiddleware)
+	router.GET("/api/balance/:id", a.getBalanceHandler)
+	router.PUT("/api/balance/:id", a.depositWithdrawHandler)
+	router.GET("/api/users/:id", a.getUserHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		key            string
+		method         string
+		path           string
+		requestBody    map[string]interface{}
+		expectedStatus int
+	}{
+		{
+			name:           "Scoped Key Reads Balance",
+			key:            "wk_reader",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Key Without Scope",
+			key:            "wk_reader",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			requestBody:    map[string]interface{}{"op_type": "withdraw", "amount": 10.0},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Key Restricted To Another Wallet",
+			key:            "wk_wallet2",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Key Cannot Read Users",
+			key:            "wk_reader",
+			method:         "GET",
+			path:           "/api/users/user1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Revoked Key",
+			key:            "wk_revoked",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Expired Key",
+			key:            "wk_expired",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unknown Key",
+			key:            "wk_unknown",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-Key", tt.key)
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name           string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Valid Key",
+			requestBody:    map[string]interface{}{"name": "billing", "scopes": []string{PermReadBalance, PermTransfer}, "wallet_ids": []int64{1}},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Missing Name",
+			requestBody:    map[string]interface{}{"scopes": []string{PermReadBalance}},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid api key name",
+		},
+		{
+			name:           "Missing Scopes",
+			requestBody:    map[string]interface{}{"name": "billing"},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "scopes are required",
+		},
+		{
+			name:           "Scope Not Allowed For Keys",
+			requestBody:    map[string]interface{}{"name": "billing", "scopes": []string{PermManageMembers}},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid scope: manage_members",
+		},
+		{
+			name:           "Expired On Creation",
+			requestBody:    map[string]interface{}{"name": "billing", "scopes": []string{PermReadBalance}, "expires_at": "2020-01-01T00:00:00Z"},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "expires_at must be in the future",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new Gin router
+			router := gin.Default()
+
+			// Initialize the app and set up the route
+			ak := &MockAPIKeyRepo{}
+			a := App{Ak: ak}
+			router.POST("/api/admin/api-keys", a.createAPIKeyHandler)
+
+			// Create a new HTTP request with the test route and request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+			req, _ := http.NewRequest("POST", "/api/admin/api-keys", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			var responseBody struct {
+				APIKey map[string]interface{} `json:"api_key"`
+				Key    string                 `json:"key"`
+				Error  string                 `json:"error"`
+			}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedError, responseBody.Error)
+			if tt.expectedStatus == http.StatusCreated {
+				// 只保存哈希，响应中的明文可以用来认证
+				assert.Len(t, ak.keys, 1)
+				assert.Equal(t, hashAPIKey(responseBody.Key), ak.keys[0].Hash)
+				assert.Equal(t, responseBody.Key[:11], responseBody.APIKey["prefix"])
+				assert.NotContains(t, responseBody.APIKey, "hash")
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKeyHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	ak := newMockAPIKeyRepo()
+	a := App{Ak: ak}
+	router.DELETE("/api/admin/api-keys/:id", a.revokeAPIKeyHandler)
+
+	req, _ := http.NewRequest("DELETE", "/api/admin/api-keys/1", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotNil(t, ak.keys[0].RevokedAt)
+
+	req, _ = http.NewRequest("DELETE", "/api/admin/api-keys/99", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRotateAPIKeyHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	ak := newMockAPIKeyRepo()
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Ak: ak}
+	router.Use(a.authMiddleware)
+	router.POST("/api/admin/api-keys/:id/rotate", a.rotateAPIKeyHandler)
+	router.GET("/api/balance/:id", a.getBalanceHandler)
+
+	readBalance := func(key string) int {
+		req, _ := http.NewRequest("GET", "/api/balance/1", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// 轮换后新旧 key 在重叠期内都可以使用
+	jsonBody, _ := json.Marshal(map[string]interface{}{"overlap": "1h"})
+	req, _ := http.NewRequest("POST", "/api/admin/api-keys/1/rotate", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var responseBody struct {
+		APIKey APIKey `json:"api_key"`
+		Key    string `json:"key"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+	assert.Equal(t, int64(1), responseBody.APIKey.RotatedFrom)
+	assert.Equal(t, []string{PermReadBalance, PermReadTransactions}, responseBody.APIKey.Scopes)
+	assert.Equal(t, http.StatusOK, readBalance("wk_reader"))
+	assert.Equal(t, http.StatusOK, readBalance(responseBody.Key))
+
+	// 重叠期结束后旧 key 失效
+	past := time.Now().Add(-time.Minute)
+	ak.keys[0].ExpiresAt = &past
+	assert.Equal(t, http.StatusUnauthorized, readBalance("wk_reader"))
+	assert.Equal(t, http.StatusOK, readBalance(responseBody.Key))
+
+	// 已吊销的 key 不能轮换
+	req, _ = http.NewRequest("POST", "/api/admin/api-keys/3/rotate", nil)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	// 重叠期格式错误或请求体不是合法的 JSON
+	for _, body := range []string{`{"overlap":"soon"}`, `{"overlap":5}`, `{"overlap":`} {
+		req, _ = http.NewRequest("POST", "/api/admin/api-keys/2/rotate", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// 转账审批状态
+const (
+	TransferPending  = "pending"
+	TransferApproved = "approved"
+	TransferRejected = "rejected"
+	TransferExpired  = "expired"
+	TransferExecuted = "executed"
+	TransferFailed   = "failed"
+)
+
+var (
+	ErrTransferRequestNotFound   = errors.New("transfer request not found")
+	ErrTransferRequestNotPending = errors.New("transfer request is not pending")
+)
+
+const transferRequestColumns = `id, from_wallet_id, COALESCE(from_pocket_id, 0), to_wallet_id, amount, status,
+	requested_by, COALESCE(decided_by, ''), created_at, decided_at, expires_at`
+
+type ApprovalAccess struct{}
+
+// 创建待审批的转账，并记录发起步骤
+func (aa *ApprovalAccess) CreateTransferRequest(ctx context.Context, db *sql.DB, req *TransferRequest) error {
+	// 开始事务
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	var pocket interface{}
+	if req.FromPocketID != 0 {
+		pocket = req.FromPocketID
+	}
+	req.Status = TransferPending
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO transfer_requests (from_wallet_id, from_pocket_id, to_wallet_id, amount, status, requested_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, req.FromWalletID, pocket, req.ToWalletID, req.Amount, req.Status, req.RequestedBy, req.ExpiresAt).
+		Scan(&req.ID, &req.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := insertTransferRequestEvent(ctx, tx, req.ID, "requested", req.RequestedBy, ""); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 根据 ID 获取转账审批
+func (aa *ApprovalAccess) GetTransferRequest(ctx context.Context, db *sql.DB, requestID int64) (*TransferRequest, error) {
+	req, err := scanTransferRequest(db.QueryRowContext(ctx, "SELECT "+transferRequestColumns+" FROM transfer_requests WHERE id = $1", requestID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferRequestNotFound
+	}
+	return req, err
+}
+
+// 获取转账审批的全部步骤
+func (aa *ApprovalAccess) GetTransferRequestEvents(ctx context.Context, db *sql.DB, requestID int64) ([]TransferRequestEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT action, actor, COALESCE(note, ''), created_at
+		FROM transfer_request_events
+		WHERE request_id = $1
+		ORDER BY id
+	`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []TransferRequestEvent
+	for rows.Next() {
+		var event TransferRequestEvent
+		if err := rows.Scan(&event.Action, &event.Actor, &event.Note, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// 获取审批人可以审批的转账：审批人是转出钱包的 owner，且不是发起人
+func (aa *ApprovalAccess) GetPendingTransferRequests(ctx context.Context, db *sql.DB, approverID string, now time.Time) ([]TransferRequest, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+transferRequestColumns+`
+		FROM transfer_requests
+		WHERE status = $1 AND expires_at > $2 AND requested_by <> $3
+		AND from_wallet_id IN (
+			SELECT id FROM wallet WHERE user_id = $3
+			UNION
+			SELECT wallet_id FROM wallet_members WHERE user_id = $3 AND role = 'owner'
+		)
+		ORDER BY created_at
+	`, TransferPending, now, approverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []TransferRequest
+	for rows.Next() {
+		req, err := scanTransferRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// 仅当转账审批处于 from 状态时将其改为 to 状态，并记录该步骤
+func (aa *ApprovalAccess) UpdateTransferRequestStatus(ctx context.Context, db *sql.DB, requestID int64, from, to, actor, note string) error {
+	// 开始事务
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	if err := updateTransferRequestStatus(ctx, tx, requestID, from, to, actor, note); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 锁定转账审批并读取，并发的审批、拒绝和过期处理等待 uow 的事务结束
+func (aa *ApprovalAccess) LockTransferRequest(ctx context.Context, uow *UnitOfWork, requestID int64) (*TransferRequest, error) {
+	req, err := scanTransferRequest(uow.tx.QueryRowContext(ctx, "SELECT "+transferRequestColumns+" FROM transfer_requests WHERE id = $1 FOR UPDATE", requestID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTransferRequestNotFound
+	}
+	return req, contextError(ctx, err)
+}
+
+// 在 uow 的事务中更新转账审批的状态
+func (aa *ApprovalAccess) UpdateTransferRequestStatusTx(ctx context.Context, uow *UnitOfWork, requestID int64, from, to, actor, note string) error {
+	return contextError(ctx, updateTransferRequestStatus(ctx, uow.tx, requestID, from, to, actor, note))
+}
+
+// 在事务中更新转账审批的状态并记录该步骤
+func updateTransferRequestStatus(ctx context.Context, tx *sql.Tx, requestID int64, from, to, actor, note string) error {
+	// 审批人和审批时间只在离开 pending 状态时记录
+	res, err := tx.ExecContext(ctx, `
+		UPDATE transfer_requests
+		SET status = $3, decided_by = COALESCE(decided_by, $4), decided_at = COALESCE(decided_at, $5)
+		WHERE id = $1 AND status = $2
+	`, requestID, from, to, actor, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTransferRequestNotPending
+	}
+
+	return insertTransferRequestEvent(ctx, tx, requestID, to, actor, note)
+}
+
+// 将所有已过期的待审批转账标记为 expired
+func (aa *ApprovalAccess) ExpireTransferRequests(ctx context.Context, db *sql.DB, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+		WITH expired AS (
+			UPDATE transfer_requests SET status = $1, decided_at = $3
+			WHERE status = $2 AND expires_at <= $3
+			RETURNING id
+		)
+		INSERT INTO transfer_request_events (request_id, action, actor)
+		SELECT id, $1, 'system' FROM expired
+	`, TransferExpired, TransferPending, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func insertTransferRequestEvent(ctx context.Context, tx *sql.Tx, requestID int64, action, actor, note string) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO transfer_request_events (request_id, action, actor, note) VALUES ($1, $2, $3, NULLIF($4, ''))",
+		requestID, action, actor, note)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTransferRequest(row rowScanner) (*TransferRequest, error) {
+	var req TransferRequest
+	err := row.Scan(&req.ID, &req.FromWalletID, &req.FromPocketID, &req.ToWalletID, &req.Amount, &req.Status,
+		&req.RequestedBy, &req.DecidedBy, &req.CreatedAt, &req.DecidedAt, &req.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
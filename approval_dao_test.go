@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateTransferRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expiresAt := time.Now().Add(time.Hour)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO transfer_requests \\(from_wallet_id, from_pocket_id, to_wallet_id, amount, status, requested_by, expires_at\\)").
+		WithArgs(int64(1), nil, int64(2), 500.0, "pending", "user1", expiresAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Now()))
+	mock.ExpectExec("INSERT INTO transfer_request_events \\(request_id, action, actor, note\\)").
+		WithArgs(int64(7), "requested", "user1", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	aa := &ApprovalAccess{}
+	req := TransferRequest{FromWalletID: 1, ToWalletID: 2, Amount: 500.0, RequestedBy: "user1", ExpiresAt: expiresAt}
+	if err := aa.CreateTransferRequest(context.Background(), db, &req); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if req.ID != 7 || req.Status != TransferPending {
+		t.Errorf("unexpected request %+v", req)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetTransferRequest_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, from_wallet_id, .* FROM transfer_requests WHERE id = \\$1").
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	aa := &ApprovalAccess{}
+	if _, err := aa.GetTransferRequest(context.Background(), db, 7); err != ErrTransferRequestNotFound {
+		t.Errorf("expected ErrTransferRequestNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateTransferRequestStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE transfer_requests SET status = \\$3, .* WHERE id = \\$1 AND status = \\$2").
+		WithArgs(int64(7), "pending", "approved", "user2", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO transfer_request_events").
+		WithArgs(int64(7), "approved", "user2", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	aa := &ApprovalAccess{}
+	if err := aa.UpdateTransferRequestStatus(context.Background(), db, 7, TransferPending, TransferApproved, "user2", ""); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateTransferRequestStatus_NotPending(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE transfer_requests").
+		WithArgs(int64(7), "pending", "approved", "user2", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	aa := &ApprovalAccess{}
+	if err := aa.UpdateTransferRequestStatus(context.Background(), db, 7, TransferPending, TransferApproved, "user2", ""); err != ErrTransferRequestNotPending {
+		t.Errorf("expected ErrTransferRequestNotPending, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExpireTransferRequests(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectExec("WITH expired AS \\( UPDATE transfer_requests SET status = \\$1").
+		WithArgs("expired", "pending", now).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	aa := &ApprovalAccess{}
+	n, err := aa.ExpireTransferRequests(context.Background(), db, now)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 expired requests, got %d", n)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查询调用者可以审批的转账
+func (a *App) getPendingTransfersHandler(c *gin.Context) {
+	caller, ok := callerFrom(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	requests, err := a.Ap.GetPendingTransferRequests(c.Request.Context(), a.DB, caller.UserID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if requests == nil {
+		requests = []TransferRequest{}
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// 查询转账审批及其全部步骤，发起人和转出钱包的成员可见
+func (a *App) getTransferRequestHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tr, err := a.Ap.GetTransferRequest(c.Request.Context(), a.DB, req.Id)
+	if err != nil {
+		if err == ErrTransferRequestNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	caller, ok := callerFrom(c)
+	if !ok || caller.UserID != tr.RequestedBy {
+		wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), tr.FromWalletID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "from wallet not found"})
+			return
+		}
+		if !a.authorizeWallet(c, wallet, PermReadTransactions) {
+			return
+		}
+	}
+
+	events, err := a.Ap.GetTransferRequestEvents(c.Request.Context(), a.DB, tr.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if events == nil {
+		events = []TransferRequestEvent{}
+	}
+	c.JSON(http.StatusOK, gin.H{"request": tr, "events": events})
+}
+
+// 审批通过并执行转账，审批状态、转账和最终状态在同一个事务中提交
+// 事务失败时审批仍为 pending，可以重新审批
+func (a *App) approveTransferHandler(c *gin.Context) {
+	tr, caller, ok := a.loadTransferRequestForDecision(c)
+	if !ok {
+		return
+	}
+
+	var execErr error
+	err := a.Rp.WithTx(c.Request.Context(), func(ctx context.Context, uow *UnitOfWork) error {
+		// 锁定审批后重新检查状态，并发的审批、拒绝和过期处理等待本事务结束
+		locked, err := a.Ap.LockTransferRequest(ctx, uow, tr.ID)
+		if err != nil {
+			return err
+		}
+		if locked.Status != TransferPending {
+			return ErrTransferRequestNotPending
+		}
+		if err := a.Ap.UpdateTransferRequestStatusTx(ctx, uow, locked.ID, TransferPending, TransferApproved, caller.UserID, ""); err != nil {
+			return err
+		}
+
+		// 转账失败时只撤销转账本身，审批记为 failed；ctx 结束时整个事务回滚
+		execErr = a.executeTransferRequest(ctx, uow, locked)
+		if execErr != nil && ctx.Err() != nil {
+			return execErr
+		}
+		status, note := TransferExecuted, ""
+		if execErr != nil {
+			status, note = TransferFailed, execErr.Error()
+		}
+		return a.Ap.UpdateTransferRequestStatusTx(ctx, uow, locked.ID, TransferApproved, status, caller.UserID, note)
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	recordWalletOperation("transfer", tr.Amount, execErr)
+
+	switch execErr {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"message": "transfer successful"})
+	case ErrNotEnough:
+		c.JSON(http.StatusOK, gin.H{"error": "not enough"})
+	case ErrPocketNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": execErr.Error()})
+	default:
+		loggerFrom(c.Request.Context()).Error("approved transfer failed", "transfer_request_id", tr.ID, "error", execErr)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed"})
+	}
+}
+
+// 拒绝转账
+func (a *App) rejectTransferHandler(c *gin.Context) {
+	var request struct {
+		Note string `json:"note"`
+	}
+	// 拒绝理由可以为空
+	_ = c.ShouldBindJSON(&request)
+
+	tr, caller, ok := a.loadTransferRequestForDecision(c)
+	if !ok {
+		return
+	}
+
+	if err := a.Ap.UpdateTransferRequestStatus(c.Request.Context(), a.DB, tr.ID, TransferPending, TransferRejected, caller.UserID, request.Note); err != nil {
+		if err == ErrTransferRequestNotPending {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "transfer rejected"})
+}
+
+// 加载待审批的转账并检查调用者可以审批，失败时已写入响应
+func (a *App) loadTransferRequestForDecision(c *gin.Context) (*TransferRequest, *Principal, bool) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	tr, err := a.Ap.GetTransferRequest(c.Request.Context(), a.DB, req.Id)
+	if err != nil {
+		if err == ErrTransferRequestNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, nil, false
+	}
+
+	// 审批人必须是转出钱包的 owner，且不能是发起人
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), tr.FromWalletID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "from wallet not found"})
+		return nil, nil, false
+	}
+	if !a.authorizeWallet(c, wallet, PermApproveTransfers) {
+		return nil, nil, false
+	}
+	caller, _ := callerFrom(c)
+	if caller.UserID == tr.RequestedBy {
+		c.JSON(http.StatusForbidden, gin.H{"error": "requester cannot decide their own transfer"})
+		return nil, nil, false
+	}
+
+	if tr.Status != TransferPending {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrTransferRequestNotPending.Error()})
+		return nil, nil, false
+	}
+	if !time.Now().Before(tr.ExpiresAt) {
+		err := a.Ap.UpdateTransferRequestStatus(c.Request.Context(), a.DB, tr.ID, TransferPending, TransferExpired, "system", "")
+		if err != nil && err != ErrTransferRequestNotPending {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "transfer request has expired"})
+		return nil, nil, false
+	}
+	return tr, caller, true
+}
+
+// 在审批的事务中执行已审批的转账并重新检查余额，转账在保存点中执行，失败时只撤销转账本身
+func (a *App) executeTransferRequest(ctx context.Context, uow *UnitOfWork, tr *TransferRequest) error {
+	setLogAttrs(ctx, slog.Int64("wallet_id", tr.FromWalletID), slog.Int64("to_wallet_id", tr.ToWalletID), slog.String("op_type", "transfer"))
+	return uow.Wallet.WithTx(ctx, func(ctx context.Context, uow *UnitOfWork) error {
+		if tr.FromPocketID != 0 {
+			return a.Pk.TransferFromPocketTx(ctx, uow, tr.FromWalletID, tr.FromPocketID, tr.ToWalletID, tr.Amount)
+		}
+		return transferFromMain(ctx, uow.Wallet, tr.FromWalletID, tr.ToWalletID, tr.Amount)
+	})
+}
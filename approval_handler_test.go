@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 在内存中保存转账审批，便于检查状态变化
+type MockApprovalRepo struct {
+	requests map[int64]*TransferRequest
+	events   map[int64][]TransferRequestEvent
+}
+
+func newMockApprovalRepo() *MockApprovalRepo {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	return &MockApprovalRepo{
+		requests: map[int64]*TransferRequest{
+			1: {ID: 1, FromWalletID: 1, ToWalletID: 2, Amount: 50.0, Status: TransferPending, RequestedBy: "spender1", ExpiresAt: future},
+			2: {ID: 2, FromWalletID: 1, ToWalletID: 2, Amount: 50.0, Status: TransferPending, RequestedBy: "spender1", ExpiresAt: past},
+			3: {ID: 3, FromWalletID: 1, ToWalletID: 2, Amount: 50.0, Status: TransferRejected, RequestedBy: "spender1", ExpiresAt: future},
+			4: {ID: 4, FromWalletID: 1, ToWalletID: 2, Amount: 500.0, Status: TransferPending, RequestedBy: "spender1", ExpiresAt: future},
+			5: {ID: 5, FromWalletID: 1, ToWalletID: 2, Amount: 50.0, Status: TransferPending, RequestedBy: "user1", ExpiresAt: future},
+		},
+		events: map[int64][]TransferRequestEvent{},
+	}
+}
+
+func (m *MockApprovalRepo) CreateTransferRequest(ctx context.Context, db *sql.DB, req *TransferRequest) error {
+	req.ID = int64(len(m.requests) + 1)
+	req.Status = TransferPending
+	m.requests[req.ID] = req
+	return nil
+}
+
+func (m *MockApprovalRepo) GetTransferRequest(ctx context.Context, db *sql.DB, requestID int64) (*TransferRequest, error) {
+	req, ok := m.requests[requestID]
+	if !ok {
+		return nil, ErrTransferRequestNotFound
+	}
+	copied := *req
+	return &copied, nil
+}
+
+func (m *MockApprovalRepo) GetTransferRequestEvents(ctx context.Context, db *sql.DB, requestID int64) ([]TransferRequestEvent, error) {
+	return m.events[requestID], nil
+}
+
+func (m *MockApprovalRepo) GetPendingTransferRequests(ctx context.Context, db *sql.DB, approverID string, now time.Time) ([]TransferRequest, error) {
+	var requests []TransferRequest
+	for id := int64(1); id <= int64(len(m.requests)); id++ {
+		req := m.requests[id]
+		if req.Status == TransferPending && req.ExpiresAt.After(now) && req.RequestedBy != approverID {
+			requests = append(requests, *req)
+		}
+	}
+	return requests, nil
+}
+
+func (m *MockApprovalRepo) UpdateTransferRequestStatus(ctx context.Context, db *sql.DB, requestID int64, from, to, actor, note string) error {
+	req, ok := m.requests[requestID]
+	if !ok || req.Status != from {
+		return ErrTransferRequestNotPending
+	}
+	req.Status = to
+	m.events[requestID] = append(m.events[requestID], TransferRequestEvent{Action: to, Actor: actor, Note: note})
+	return nil
+}
+
+func (m *MockApprovalRepo) LockTransferRequest(ctx context.Context, uow *UnitOfWork, requestID int64) (*TransferRequest, error) {
+	return m.GetTransferRequest(ctx, nil, requestID)
+}
+
+func (m *MockApprovalRepo) UpdateTransferRequestStatusTx(ctx context.Context, uow *UnitOfWork, requestID int64, from, to, actor, note string) error {
+	return m.UpdateTransferRequestStatus(ctx, nil, requestID, from, to, actor, note)
+}
+
+func (m *MockApprovalRepo) ExpireTransferRequests(ctx context.Context, db *sql.DB, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestTransferHandler_RequiresApproval(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+	router.Use(asCaller("user1"))
+
+	// Initialize the app and set up the route
+	ap := newMockApprovalRepo()
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Ap: ap, ApprovalThreshold: 40.0, ApprovalTTL: time.Hour}
+	router.POST("/api/transfer", a.transferHandler)
+
+	jsonBody, _ := json.Marshal(map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 50.0})
+	req, _ := http.NewRequest("POST", "/api/transfer", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	var responseBody map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+	assert.Equal(t, map[string]interface{}{"message": "transfer pending approval", "request_id": 6.0}, responseBody)
+	assert.Equal(t, "user1", ap.requests[6].RequestedBy)
+	assert.Equal(t, TransferPending, ap.requests[6].Status)
+
+	// 转入钱包不存在时不创建审批
+	jsonBody, _ = json.Marshal(map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 999, "amount": 50.0})
+	req, _ = http.NewRequest("POST", "/api/transfer", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":"to wallet not found"}`, rec.Body.String())
+	assert.NotContains(t, ap.requests, int64(7))
+}
+
+func TestApprovalHandlers(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name           string
+		caller         string
+		method         string
+		path           string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+		expectedState  string
+	}{
+		{
+			name:           "Approve And Execute",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/1/approve",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "transfer successful"},
+			expectedState:  TransferExecuted,
+		},
+		{
+			name:           "Approve Expired",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/2/approve",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]interface{}{"error": "transfer request has expired"},
+			expectedState:  TransferExpired,
+		},
+		{
+			name:           "Approve Already Rejected",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/3/approve",
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]interface{}{"error": "transfer request is not pending"},
+			expectedState:  TransferRejected,
+		},
+		{
+			name:           "Approve Not Enough",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/4/approve",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"error": "not enough"},
+			expectedState:  TransferFailed,
+		},
+		{
+			name:           "Approve Own Request",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/5/approve",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]interface{}{"error": "requester cannot decide their own transfer"},
+			expectedState:  TransferPending,
+		},
+		{
+			name:           "Spender Cannot Approve",
+			caller:         "spender1",
+			method:         "POST",
+			path:           "/api/transfers/5/approve",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]interface{}{"error": "forbidden"},
+			expectedState:  TransferPending,
+		},
+		{
+			name:           "Reject",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/1/reject",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "transfer rejected"},
+			expectedState:  TransferRejected,
+		},
+		{
+			name:           "Unknown Request",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfers/99/approve",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "transfer request not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new Gin router
+			router := gin.Default()
+
+			// Initialize the app and set up the route
+			ap := newMockApprovalRepo()
+			a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Ap: ap, Tokens: testTokens}
+			router.Use(a.authMiddleware)
+			router.POST("/api/transfers/:id/approve", a.approveTransferHandler)
+			router.POST("/api/transfers/:id/reject", a.rejectTransferHandler)
+
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", bearer(tt.caller))
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+
+			// Assert that the request ended in the expected state
+			if tt.expectedState != "" {
+				var id int64
+				_, _ = fmt.Sscanf(strings.TrimPrefix(tt.path, "/api/transfers/"), "%d", &id)
+				assert.Equal(t, tt.expectedState, ap.requests[id].Status)
+			}
+		})
+	}
+}
+
+func TestGetPendingTransfersHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+	router.Use(asCaller("user1"))
+
+	// Initialize the app and set up the route
+	a := App{Ap: newMockApprovalRepo()}
+	router.GET("/api/transfers/pending", a.getPendingTransfersHandler)
+
+	req, _ := http.NewRequest("GET", "/api/transfers/pending", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var responseBody struct {
+		Requests []TransferRequest `json:"requests"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+	// 过期、已拒绝和自己发起的转账都不在列表中
+	var ids []int64
+	for _, r := range responseBody.Requests {
+		ids = append(ids, r.ID)
+	}
+	assert.Equal(t, []int64{1, 4}, ids)
+}
+
+// 审批、转账和最终状态在同一个事务中提交，任何一步失败时审批仍为 pending
+func TestApproveTransferHandler_SingleTransaction(t *testing.T) {
+	tests := []struct {
+		name           string
+		balance        float64
+		finalStatusErr error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Executed",
+			balance:        100.0,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"message":"transfer successful"}`,
+		},
+		{
+			name:           "Final status fails",
+			balance:        10.0,
+			finalStatusErr: errors.New("connection reset"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   `{"error":"connection reset"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			walletRows := func(balance float64) *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(1, balance, "owner1")
+			}
+			requestRows := func() *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "from_wallet_id", "from_pocket_id", "to_wallet_id", "amount", "status", "requested_by", "decided_by", "created_at", "decided_at", "expires_at"}).
+					AddRow(1, 1, 0, 2, 50.0, TransferPending, "spender1", "", time.Now(), nil, time.Now().Add(time.Hour))
+			}
+			mock.ExpectQuery("SELECT .+ FROM transfer_requests WHERE id = \\$1$").WithArgs(1).WillReturnRows(requestRows())
+			mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1").WithArgs(1).WillReturnRows(walletRows(100.0))
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT .+ FROM transfer_requests WHERE id = \\$1 FOR UPDATE").WithArgs(1).WillReturnRows(requestRows())
+			mock.ExpectExec("UPDATE transfer_requests").WithArgs(1, TransferPending, TransferApproved, "user1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("INSERT INTO transfer_request_events").WillReturnResult(sqlmock.NewResult(1, 1))
+			mock.ExpectExec("SAVEPOINT wallet_tx").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(1).WillReturnRows(walletRows(tt.balance))
+			final := TransferFailed
+			if tt.balance >= 50 {
+				final = TransferExecuted
+				mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE wallet SET balance = balance - \\$1 WHERE id = \\$2").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectExec("INSERT INTO outbox_events").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("RELEASE SAVEPOINT wallet_tx").WillReturnResult(sqlmock.NewResult(0, 0))
+			} else {
+				mock.ExpectExec("ROLLBACK TO SAVEPOINT wallet_tx").WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			if tt.finalStatusErr != nil {
+				mock.ExpectExec("UPDATE transfer_requests").WithArgs(1, TransferApproved, final, "user1", sqlmock.AnyArg()).WillReturnError(tt.finalStatusErr)
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec("UPDATE transfer_requests").WithArgs(1, TransferApproved, final, "user1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("INSERT INTO transfer_request_events").WillReturnResult(sqlmock.NewResult(2, 1))
+				mock.ExpectCommit()
+			}
+
+			// Create a new Gin router
+			router := gin.Default()
+			router.Use(asCaller("user1"))
+
+			// Initialize the app and set up the route
+			a := App{DB: db, Rp: &WalletAccess{DB: db}, Mb: &MockMemberRepo{}, Ap: &ApprovalAccess{}}
+			router.POST("/api/transfers/:id/approve", a.approveTransferHandler)
+
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("POST", "/api/transfers/1/approve", nil)
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.JSONEq(t, tt.expectedBody, rec.Body.String())
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const callerKey = "caller"
+
+// 钱包操作权限
+const (
+	PermReadBalance      = "read_balance"
+	PermReadTransactions = "read_transactions"
+	PermDeposit          = "deposit"
+	PermWithdraw         = "withdraw"
+	PermTransfer         = "transfer"
+	PermManagePockets    = "manage_pockets"
+	PermManageMembers    = "manage_members"
+	PermApproveTransfers = "approve_transfers"
+)
+
+// 各钱包角色拥有的权限
+var rolePermissions = map[string]map[string]bool{
+	"owner": {
+		PermReadBalance:      true,
+		PermReadTransactions: true,
+		PermDeposit:          true,
+		PermWithdraw:         true,
+		PermTransfer:         true,
+		PermManagePockets:    true,
+		PermManageMembers:    true,
+		PermApproveTransfers: true,
+	},
+	"spender": {
+		PermReadBalance:      true,
+		PermReadTransactions: true,
+		PermDeposit:          true,
+		PermWithdraw:         true,
+		PermTransfer:         true,
+	},
+	"viewer": {
+		PermReadBalance:      true,
+		PermReadTransactions: true,
+	},
+}
+
+// 后台操作权限
+const (
+	PermViewReports    = "view_reports"
+	PermRunSnapshots   = "run_snapshots"
+	PermViewAPIKeys    = "view_api_keys"
+	PermManageAPIKeys  = "manage_api_keys"
+	PermViewWebhooks   = "view_webhooks"
+	PermManageWebhooks = "manage_webhooks"
+)
+
+// 各全局角色拥有的后台权限，customer 只能访问自己有权限的钱包
+var adminRolePermissions = map[string]map[string]bool{
+	"customer": {},
+	"support": {
+		PermViewAPIKeys:  true,
+		PermViewWebhooks: true,
+	},
+	"finance": {
+		PermViewReports:  true,
+		PermRunSnapshots: true,
+	},
+	"admin": {
+		PermViewReports:    true,
+		PermRunSnapshots:   true,
+		PermViewAPIKeys:    true,
+		PermManageAPIKeys:  true,
+		PermViewWebhooks:   true,
+		PermManageWebhooks: true,
+	},
+}
+
+// API key 可以申请的 scope
+var apiKeyScopes = map[string]bool{
+	PermReadBalance:      true,
+	PermReadTransactions: true,
+	PermDeposit:          true,
+	PermWithdraw:         true,
+	PermTransfer:         true,
+}
+
+// 当前请求的调用者，用户通过 JWT 认证，服务通过 API key 或请求签名认证
+type Principal struct {
+	UserID string
+	Roles  []string
+
+	// 服务调用时的 API key ID 或签名客户端 ID、scope 和可操作的钱包，钱包为空表示不限
+	APIKeyID  int64
+	ClientID  string
+	Scopes    []string
+	WalletIDs []int64
+
+	// 通过 mTLS 连接时客户端证书的 subject，否则为空
+	CertSubject string
+}
+
+// 调用者是服务而不是用户
+func (p *Principal) isService() bool {
+	return p.APIKeyID != 0 || p.ClientID != ""
+}
+
+func (p *Principal) hasAdminPermission(perm string) bool {
+	for _, r := range p.Roles {
+		if adminRolePermissions[r][perm] {
+			return true
+		}
+	}
+	return false
+}
+
+// 拥有任一后台权限的调用者为内部员工
+func (p *Principal) isStaff() bool {
+	for _, r := range p.Roles {
+		if len(adminRolePermissions[r]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) hasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) canAccessWallet(walletID int64) bool {
+	if len(p.WalletIDs) == 0 {
+		return true
+	}
+	for _, id := range p.WalletIDs {
+		if id == walletID {
+			return true
+		}
+	}
+	return false
+}
+
+// 通过 Authorization: Bearer <token> 或 X-API-Key 认证调用者
+// 没有凭证的请求交给各接口处理，凭证无效时直接返回 401
+// 客户端证书只用于授权，不能代替凭证
+func (a *App) authMiddleware(c *gin.Context) {
+	subject := certSubject(c.Request.TLS)
+	if subject != "" {
+		setLogAttrs(c.Request.Context(), slog.String("client_cert", subject))
+	}
+	caller, err := a.authenticate(c.Request.Context(), c.GetHeader("X-API-Key"), c.GetHeader("Authorization"))
+	if err != nil {
+		c.AbortWithStatusJSON(errorStatus[errorCodeOf(err)].HTTP, gin.H{"error": err.Error()})
+		return
+	}
+	if caller != nil {
+		caller.CertSubject = subject
+		c.Set(callerKey, caller)
+	}
+	c.Next()
+}
+
+var (
+	errInvalidAPIKey = newAPIError(CodeUnauthenticated, "invalid api key")
+	errInvalidToken  = newAPIError(CodeUnauthenticated, ErrInvalidToken.Error())
+)
+
+// 按 API key 或 Authorization 头认证调用者，HTTP 和 gRPC 共用
+// 两者都为空时返回 nil，API key 优先
+func (a *App) authenticate(ctx context.Context, apiKey, authorization string) (*Principal, error) {
+	if apiKey != "" {
+		return a.authenticateAPIKey(ctx, apiKey)
+	}
+	if authorization == "" {
+		return nil, nil
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || a.Tokens == nil {
+		return nil, errInvalidToken
+	}
+	caller, err := a.Tokens.Verify(token)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	return caller, nil
+}
+
+// 按哈希查找 API key，吊销或过期的 key 视为无效
+func (a *App) authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	apiKey, err := a.Ak.GetAPIKeyByHash(ctx, a.DB, hashAPIKey(key))
+	if err != nil {
+		if err == ErrAPIKeyNotFound {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+	if !apiKey.Active(time.Now()) {
+		return nil, errInvalidAPIKey
+	}
+	return &Principal{APIKeyID: apiKey.ID, Scopes: apiKey.Scopes, WalletIDs: apiKey.WalletIDs}, nil
+}
+
+// 生成新的 API key，返回明文、用于识别的前缀和哈希
+func generateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = "wk_" + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:11], hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// 后台接口只允许内部员工访问，customer 和 API key 一律拒绝
+func (a *App) requireStaff(c *gin.Context) {
+	caller, ok := callerFrom(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if !caller.isStaff() {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return
+	}
+	c.Next()
+}
+
+// 按角色权限矩阵检查调用者是否拥有指定的后台权限
+func (a *App) requirePermission(perm string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, ok := callerFrom(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		if !caller.hasAdminPermission(perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func callerFrom(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(callerKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Principal)
+	return p, ok
+}
+
+// 检查调用者在钱包上是否拥有指定权限，失败时已写入响应
+func (a *App) authorizeWallet(c *gin.Context, wallet *Wallet, perm string) bool {
+	caller, _ := callerFrom(c)
+	if err := a.checkWalletPermission(c.Request.Context(), caller, wallet, perm); err != nil {
+		writeError(c, err)
+		return false
+	}
+	return true
+}
+
+// 检查调用者在钱包上是否拥有指定权限，caller 为 nil 表示未认证
+// 调用者与钱包无关时返回 ErrWalletNotFound，与钱包不存在相同，不暴露钱包是否存在
+func (a *App) checkWalletPermission(ctx context.Context, caller *Principal, wallet *Wallet, perm string) error {
+	if caller == nil {
+		return ErrUnauthenticated
+	}
+
+	// 服务按钱包和 scope 限制授权
+	if caller.isService() {
+		if !caller.canAccessWallet(wallet.ID) {
+			return ErrWalletNotFound
+		}
+		if !caller.hasScope(perm) {
+			return ErrForbidden
+		}
+		return nil
+	}
+
+	// 钱包的创建者始终是 owner，其余成员按 wallet_members 中的角色授权
+	role := "owner"
+	if wallet.UserID != caller.UserID {
+		var err error
+		role, err = a.Mb.GetMemberRole(ctx, a.DB, wallet.ID, caller.UserID)
+		if err == ErrMemberNotFound {
+			return ErrWalletNotFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !rolePermissions[role][perm] {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// 用户相关的接口只允许用户本人访问，服务不能访问，失败时已写入响应
+func (a *App) authorizeUser(c *gin.Context, userID string) bool {
+	caller, ok := callerFrom(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return false
+	}
+	if caller.isService() || caller.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("wallet-test-secret")
+
+// 测试使用的 HMAC 密钥
+var testTokens, _ = NewTokenVerifier([]byte(`{"keys":[{"kty":"oct","kid":"test","k":"`+
+	base64.RawURLEncoding.EncodeToString(testSecret)+`"}]}`), "", "")
+
+// 为指定用户签发测试 token
+func bearer(userID string, roles ...string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":   userID,
+		"roles": roles,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test"
+	signed, _ := token.SignedString(testSecret)
+	return "Bearer " + signed
+}
+
+// 测试中直接指定调用者
+func asCaller(userID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(callerKey, &Principal{UserID: userID})
+		c.Next()
+	}
+}
+
+// 未认证的请求在查询钱包之前拒绝
+func TestWalletAccess_NoCaller(t *testing.T) {
+	a := App{Rp: &MockWalletRepo{WalletErr: errors.New("unexpected wallet lookup")}, Mb: &MockMemberRepo{}}
+
+	_, err := a.loadWalletFor(context.Background(), nil, 1, PermReadBalance)
+	assert.Equal(t, ErrUnauthenticated, err)
+	_, err = a.transfer(context.Background(), nil, 1, 0, 2, 10.0)
+	assert.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestWalletRoleEnforcement(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.PUT("/api/balance/:id", a.depositWithdrawHandler)
+	router.GET("/api/balance/:id", a.getBalanceHandler)
+	router.POST("/api/transfer", a.transferHandler)
+	router.GET("/api/transaction/:id", a.getTransactions)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		caller         string
+		method         string
+		path           string
+		requestBody    map[string]interface{}
+		expectedStatus int
+	}{
+		{
+			name:           "No Caller",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Stranger Reads Balance",
+			caller:         "stranger",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Stranger Transfers",
+			caller:         "stranger",
+			method:         "POST",
+			path:           "/api/transfer",
+			requestBody:    map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10.0},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Transfer From Missing Wallet",
+			caller:         "user1",
+			method:         "POST",
+			path:           "/api/transfer",
+			requestBody:    map[string]interface{}{"from_wallet_id": 999, "to_wallet_id": 2, "amount": 10.0},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Viewer Reads Balance",
+			caller:         "viewer1",
+			method:         "GET",
+			path:           "/api/balance/1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Viewer Reads Transactions",
+			caller:         "viewer1",
+			method:         "GET",
+			path:           "/api/transaction/1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Viewer Withdraws",
+			caller:         "viewer1",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			requestBody:    map[string]interface{}{"op_type": "withdraw", "amount": 10.0},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Viewer Transfers",
+			caller:         "viewer1",
+			method:         "POST",
+			path:           "/api/transfer",
+			requestBody:    map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10.0},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Spender Withdraws",
+			caller:         "spender1",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			requestBody:    map[string]interface{}{"op_type": "withdraw", "amount": 10.0},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Spender Transfers",
+			caller:         "spender1",
+			method:         "POST",
+			path:           "/api/transfer",
+			requestBody:    map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10.0},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			var body *bytes.Buffer
+			if tt.requestBody != nil {
+				jsonBody, _ := json.Marshal(tt.requestBody)
+				body = bytes.NewBuffer(jsonBody)
+			} else {
+				body = bytes.NewBuffer(nil)
+			}
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest(tt.method, tt.path, body)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.caller != "" {
+				req.Header.Set("Authorization", bearer(tt.caller))
+			}
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.GET("/api/balance/:id", a.getBalanceHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		authorization  string
+		expectedStatus int
+	}{
+		{
+			name:           "Valid Token",
+			authorization:  bearer("user1"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing Bearer Prefix",
+			authorization:  bearer("user1")[len("Bearer "):],
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Tampered Token",
+			authorization:  bearer("user1") + "x",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Not The Owner",
+			authorization:  bearer("user2"),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("GET", "/api/balance/1", nil)
+			req.Header.Set("Authorization", tt.authorization)
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	// Initialize the app and set up the routes
+	a := App{Rp: &MockWalletRepo{}, Ss: &MockSnapshotRepo{}, Ak: newMockAPIKeyRepo(), Wh: newMockWebhookRepo(), Tokens: testTokens}
+	router := a.setupRouter()
+
+	// 各角色可以访问的后台接口
+	allowed := map[string]map[string]bool{
+		"customer": {},
+		"support": {
+			"GET /api/admin/api-keys":                true,
+			"GET /api/admin/webhooks":                true,
+			"GET /api/admin/webhooks/:id/deliveries": true,
+		},
+		"finance": {
+			"GET /api/admin/trial-balance": true,
+			"POST /api/admin/snapshots":    true,
+		},
+		"admin": {
+			"GET /api/admin/trial-balance":                 true,
+			"POST /api/admin/snapshots":                    true,
+			"POST /api/admin/api-keys":                     true,
+			"GET /api/admin/api-keys":                      true,
+			"DELETE /api/admin/api-keys/:id":               true,
+			"POST /api/admin/api-keys/:id/rotate":          true,
+			"POST /api/admin/webhooks":                     true,
+			"GET /api/admin/webhooks":                      true,
+			"DELETE /api/admin/webhooks/:id":               true,
+			"GET /api/admin/webhooks/:id/deliveries":       true,
+			"POST /api/admin/webhook-deliveries/:id/retry": true,
+		},
+	}
+
+	var adminRoutes []gin.RouteInfo
+	for _, route := range router.Routes() {
+		if strings.HasPrefix(route.Path, "/api/admin/") {
+			adminRoutes = append(adminRoutes, route)
+		}
+	}
+	assert.Len(t, adminRoutes, len(allowed["admin"]))
+
+	// 符合 OpenAPI 文档的请求，避免在权限检查前被请求校验拒绝
+	queries := map[string]string{
+		"GET /api/admin/trial-balance": "?date=2024-06-01",
+	}
+	bodies := map[string]string{
+		"POST /api/admin/snapshots": `{"date": "2024-06-01"}`,
+		"POST /api/admin/api-keys":  `{"name": "reporting", "scopes": ["read_balance"]}`,
+		"POST /api/admin/webhooks":  `{"url": "https://example.com/hook", "event_types": ["deposit"]}`,
+	}
+
+	send := func(route gin.RouteInfo, header, value string) int {
+		key := route.Method + " " + route.Path
+		body, ok := bodies[key]
+		if !ok {
+			body = "{}"
+		}
+		path := strings.ReplaceAll(route.Path, ":id", "1") + queries[key]
+		req, _ := http.NewRequest(route.Method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, route := range adminRoutes {
+		key := route.Method + " " + route.Path
+		t.Run(key, func(t *testing.T) {
+			assert.Equal(t, http.StatusUnauthorized, send(route, "", ""), "no caller")
+			assert.Equal(t, http.StatusForbidden, send(route, "Authorization", bearer("user1")), "user without roles")
+			assert.Equal(t, http.StatusForbidden, send(route, "X-API-Key", "wk_wallet2"), "api key")
+
+			for role, routes := range allowed {
+				code := send(route, "Authorization", bearer("staff1", role))
+				if routes[key] {
+					assert.NotContains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, code, role)
+				} else {
+					assert.Equal(t, http.StatusForbidden, code, role)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// 交易记录提交时数据库触发器发出通知的频道
+const walletEventsChannel = "wallet_events"
+
+// 每个订阅者缓存的事件数，写满时断开该订阅者，客户端重连后重新获取余额
+const streamBufferSize = 16
+
+// 推送给余额订阅者的事件，Type 为 balance 或 transaction
+type StreamEvent struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// 数据库触发器发出的通知内容
+type walletNotification struct {
+	WalletID    int64       `json:"wallet_id"`
+	Transaction Transaction `json:"transaction"`
+}
+
+// 把 LISTEN 收到的交易通知分发给订阅了该钱包的连接
+// 每个实例各自 LISTEN，所以任一实例提交的交易都会推送到所有实例的订阅者
+type BalanceHub struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan StreamEvent]struct{}
+
+	// 查询钱包的当前余额
+	load func(walletID int64) (*WalletBalance, error)
+}
+
+func NewBalanceHub(load func(walletID int64) (*WalletBalance, error)) *BalanceHub {
+	return &BalanceHub{subs: make(map[int64]map[chan StreamEvent]struct{}), load: load}
+}
+
+// 订阅钱包的事件，返回的 cancel 取消订阅，连接过慢被断开或停机时 channel 会被关闭
+func (h *BalanceHub) Subscribe(walletID int64) (<-chan StreamEvent, func()) {
+	ch := make(chan StreamEvent, streamBufferSize)
+	h.mu.Lock()
+	if h.subs[walletID] == nil {
+		h.subs[walletID] = make(map[chan StreamEvent]struct{})
+	}
+	h.subs[walletID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[walletID][ch]; ok {
+			h.remove(walletID, ch)
+		}
+	}
+}
+
+// 调用时需持有 h.mu
+func (h *BalanceHub) remove(walletID int64, ch chan StreamEvent) {
+	delete(h.subs[walletID], ch)
+	if len(h.subs[walletID]) == 0 {
+		delete(h.subs, walletID)
+	}
+	close(ch)
+}
+
+// 处理数据库通知直到 ctx 取消或 notifications 关闭
+// 连接重建后 pq 发送 nil，期间的通知可能丢失，此时向所有订阅者推送最新余额
+func (h *BalanceHub) Run(ctx context.Context, notifications <-chan *pq.Notification) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if n == nil {
+				for _, walletID := range h.walletIDs() {
+					h.publishBalance(walletID)
+				}
+				continue
+			}
+			h.dispatch(n.Extra)
+		}
+	}
+}
+
+func (h *BalanceHub) dispatch(payload string) {
+	var n walletNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		log.Printf("invalid wallet notification %q: %v", payload, err)
+		return
+	}
+	if !h.hasSubscribers(n.WalletID) {
+		return
+	}
+	h.publish(n.WalletID, StreamEvent{Type: "transaction", Data: n.Transaction})
+	h.publishBalance(n.WalletID)
+}
+
+// 查询一次余额并推送给该钱包的全部订阅者
+func (h *BalanceHub) publishBalance(walletID int64) {
+	balance, err := h.load(walletID)
+	if err != nil {
+		log.Printf("failed to load balance of wallet %d: %v", walletID, err)
+		return
+	}
+	h.publish(walletID, StreamEvent{Type: "balance", Data: balance})
+}
+
+func (h *BalanceHub) publish(walletID int64, event StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[walletID] {
+		select {
+		case ch <- event:
+		default:
+			h.remove(walletID, ch)
+		}
+	}
+}
+
+// 断开全部订阅者，停机时让推送连接结束
+func (h *BalanceHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for walletID, subs := range h.subs {
+		for ch := range subs {
+			h.remove(walletID, ch)
+		}
+	}
+}
+
+func (h *BalanceHub) hasSubscribers(walletID int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs[walletID]) > 0
+}
+
+func (h *BalanceHub) walletIDs() []int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := make([]int64, 0, len(h.subs))
+	for id := range h.subs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// 不检查权限地查询钱包余额，供 BalanceHub 使用
+func (a *App) loadBalance(walletID int64) (*WalletBalance, error) {
+	ctx := context.Background()
+	wallet, err := a.Rp.GetWalletInfoById(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+	return a.balanceOf(ctx, wallet)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// 连接空闲时发送心跳的间隔，避免被代理断开
+const streamHeartbeat = 15 * time.Second
+
+// WebSocket 只接受同源的浏览器连接
+var balanceUpgrader = websocket.Upgrader{}
+
+// 检查权限并订阅钱包，返回订阅时的余额；失败时已写入错误响应
+func (a *App) openBalanceStream(c *gin.Context) (*WalletBalance, <-chan StreamEvent, func(), bool) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, nil, nil, false
+	}
+	caller, _ := callerFrom(c)
+	wallet, err := a.loadWalletFor(c.Request.Context(), caller, req.Id, PermReadBalance)
+	if err != nil {
+		writeError(c, err)
+		return nil, nil, nil, false
+	}
+
+	// 先订阅再查询余额，查询期间提交的交易不会漏掉
+	events, cancel := a.Balances.Subscribe(wallet.ID)
+	balance, err := a.balanceOf(c.Request.Context(), wallet)
+	if err != nil {
+		cancel()
+		writeError(c, err)
+		return nil, nil, nil, false
+	}
+	return balance, events, cancel, true
+}
+
+// 以 Server-Sent Events 推送钱包的余额和新交易，连接建立时先推送当前余额
+func (a *App) balanceStreamHandler(c *gin.Context) {
+	balance, events, cancel, ok := a.openBalanceStream(c)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("balance", balance)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.Type, event.Data)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		}
+	})
+}
+
+// 以 WebSocket 推送钱包的余额和新交易，每条消息为一个 StreamEvent
+func (a *App) balanceWebSocketHandler(c *gin.Context) {
+	balance, events, cancel, ok := a.openBalanceStream(c)
+	if !ok {
+		return
+	}
+	defer cancel()
+
+	conn, err := balanceUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 已写入错误响应
+		return
+	}
+	defer conn.Close()
+
+	// 读取并丢弃客户端消息，以便处理 close 和 pong
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := conn.WriteJSON(StreamEvent{Type: "balance", Data: balance}); err != nil {
+		return
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-events:
+			if !ok {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "reconnect"), time.Now().Add(time.Second))
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
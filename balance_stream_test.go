@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 数据库触发器发出的通知
+func transactionNotification(walletID, transactionID int64, amount float64) *pq.Notification {
+	payload, _ := json.Marshal(walletNotification{
+		WalletID:    walletID,
+		Transaction: Transaction{ID: transactionID, WalletID: walletID, OpType: "deposit", Amount: amount, CreatedAt: time.Now()},
+	})
+	return &pq.Notification{Channel: walletEventsChannel, Extra: string(payload)}
+}
+
+func receive(t *testing.T, events <-chan StreamEvent) StreamEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return StreamEvent{}
+	}
+}
+
+func TestBalanceHub(t *testing.T) {
+	loads := 0
+	hub := NewBalanceHub(func(walletID int64) (*WalletBalance, error) {
+		loads++
+		return &WalletBalance{Balance: 100, MainBalance: 100, Pockets: []Pocket{}}, nil
+	})
+	notifications := make(chan *pq.Notification)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go hub.Run(ctx, notifications)
+
+	first, cancelFirst := hub.Subscribe(1)
+	second, cancelSecond := hub.Subscribe(1)
+	defer cancelSecond()
+
+	// 交易通知推送给该钱包的全部订阅者，余额只查询一次
+	notifications <- transactionNotification(1, 7, 10)
+	for _, events := range []<-chan StreamEvent{first, second} {
+		event := receive(t, events)
+		assert.Equal(t, "transaction", event.Type)
+		assert.Equal(t, int64(7), event.Data.(Transaction).ID)
+		assert.Equal(t, "balance", receive(t, events).Type)
+	}
+	assert.Equal(t, 1, loads)
+
+	// 没有订阅者的钱包不查询余额
+	notifications <- transactionNotification(2, 8, 10)
+	// 连接重建后推送最新余额
+	cancelFirst()
+	notifications <- nil
+	event := receive(t, second)
+	assert.Equal(t, "balance", event.Type)
+	assert.Equal(t, 2, loads)
+	_, open := <-first
+	assert.False(t, open)
+}
+
+// 跟不上的订阅者被断开，其他订阅者不受影响
+func TestBalanceHub_SlowSubscriber(t *testing.T) {
+	hub := NewBalanceHub(func(walletID int64) (*WalletBalance, error) {
+		return &WalletBalance{}, nil
+	})
+	slow, cancel := hub.Subscribe(1)
+	defer cancel()
+
+	for i := 0; i <= streamBufferSize; i++ {
+		hub.dispatch(transactionNotification(1, int64(i), 1).Extra)
+	}
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, streamBufferSize, received)
+	assert.False(t, hub.hasSubscribers(1))
+}
+
+func newStreamTestServer(t *testing.T) (*httptest.Server, chan *pq.Notification) {
+	a := &App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Tokens: testTokens}
+	a.Balances = NewBalanceHub(a.loadBalance)
+	notifications := make(chan *pq.Notification)
+	ctx, stop := context.WithCancel(context.Background())
+	go a.Balances.Run(ctx, notifications)
+
+	server := httptest.NewServer(a.setupRouter())
+	t.Cleanup(func() {
+		server.Close()
+		stop()
+	})
+	return server, notifications
+}
+
+func TestBalanceStreamHandler(t *testing.T) {
+	server, notifications := newStreamTestServer(t)
+
+	// Create a new HTTP request with the test route
+	req, _ := http.NewRequest("GET", server.URL+"/api/balance/1/stream", nil)
+	req.Header.Set("Authorization", bearer("viewer1"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Assert that the response status code is as expected
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// 依次读取 SSE 事件
+	lines := bufio.NewScanner(resp.Body)
+	next := func() (string, string) {
+		var name, data string
+		for lines.Scan() {
+			line := lines.Text()
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				name = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				data = line[len("data:"):]
+			case line == "" && name != "":
+				return name, data
+			}
+		}
+		return name, data
+	}
+
+	name, data := next()
+	assert.Equal(t, "balance", name)
+	assert.JSONEq(t, `{"balance":125,"main_balance":100,"pockets":[{"id":1,"wallet_id":1,"name":"rent","balance":25}]}`, data)
+
+	notifications <- transactionNotification(1, 7, 10)
+	name, data = next()
+	assert.Equal(t, "transaction", name)
+	assert.Contains(t, data, `"id":7`)
+	name, _ = next()
+	assert.Equal(t, "balance", name)
+}
+
+func TestBalanceWebSocketHandler(t *testing.T) {
+	server, notifications := newStreamTestServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/balance/1/ws"
+
+	// 无权访问时在升级前返回错误，与钱包不存在相同
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {bearer("user2")}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {bearer("user1")}})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	var event struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "balance", event.Type)
+	assert.Contains(t, string(event.Data), `"balance":125`)
+
+	notifications <- transactionNotification(1, 7, 10)
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "transaction", event.Type)
+	assert.Contains(t, string(event.Data), `"op_type":"deposit"`)
+	require.NoError(t, conn.ReadJSON(&event))
+	assert.Equal(t, "balance", event.Type)
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// 服务的全部配置，优先级从低到高：默认值、配置文件、环境变量、命令行参数
+// 每个字段的 config 是配置文件中的键和命令行参数名（如 db.host），env 是对应的环境变量
+type Config struct {
+	HTTP      HTTPConfig      `config:"http"`
+	GRPC      GRPCConfig      `config:"grpc"`
+	TLS       TLSConfig       `config:"tls"`
+	DB        DBConfig        `config:"db"`
+	Auth      AuthConfig      `config:"auth"`
+	RateLimit RateLimitConfig `config:"rate_limit"`
+	Approval  ApprovalConfig  `config:"approval"`
+	Outbox    OutboxConfig    `config:"outbox"`
+	Log       LogConfig       `config:"log"`
+	Tracing   TracingConfig   `config:"tracing"`
+	Features  FeatureConfig   `config:"features"`
+}
+
+type HTTPConfig struct {
+	Addr              string        `config:"addr" env:"HTTP_ADDR" usage:"address the HTTP server listens on"`
+	ReadHeaderTimeout time.Duration `config:"read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" usage:"how long to wait for request headers"`
+	IdleTimeout       time.Duration `config:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" usage:"how long an idle keep-alive connection stays open"`
+	ReadyTimeout      time.Duration `config:"ready_timeout" env:"READY_TIMEOUT" usage:"how long /readyz waits for the database ping"`
+	ShutdownTimeout   time.Duration `config:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long shutdown waits for requests and workers"`
+	DrainDelay        time.Duration `config:"drain_delay" env:"DRAIN_DELAY" usage:"how long requests are still served after /readyz starts failing"`
+	RouteTimeouts     string        `config:"route_timeouts" env:"ROUTE_TIMEOUTS" usage:"JSON that replaces the timeouts of the routes it names"`
+}
+
+type GRPCConfig struct {
+	Addr string `config:"addr" env:"GRPC_ADDR" usage:"address the gRPC server listens on"`
+}
+
+// HTTP 和 gRPC 共用的证书，都为空时不启用 TLS
+type TLSConfig struct {
+	CertFile       string        `config:"cert_file" env:"TLS_CERT_FILE" usage:"PEM certificate served over HTTP and gRPC"`
+	KeyFile        string        `config:"key_file" env:"TLS_KEY_FILE" usage:"PEM private key of the certificate"`
+	ClientAuth     string        `config:"client_auth" env:"TLS_CLIENT_AUTH" usage:"none, optional or require client certificates"`
+	ClientCAFile   string        `config:"client_ca_file" env:"TLS_CLIENT_CA_FILE" usage:"PEM CA bundle client certificates are verified against"`
+	ReloadInterval time.Duration `config:"reload_interval" env:"TLS_RELOAD_INTERVAL" usage:"how often the certificate files are checked for changes"`
+}
+
+type DBConfig struct {
+	Host            string        `config:"host" env:"DB_HOST" usage:"database host"`
+	Port            int           `config:"port" env:"DB_PORT" usage:"database port"`
+	User            string        `config:"user" env:"DB_USERNAME" usage:"database user"`
+	Password        string        `config:"password" env:"DB_PASSWORD" secret:"true" usage:"database password"`
+	Name            string        `config:"name" env:"DB_NAME" usage:"database name"`
+	SSLMode         string        `config:"sslmode" env:"DB_SSLMODE" usage:"disable, allow, prefer, require, verify-ca or verify-full"`
+	SSLRootCert     string        `config:"sslrootcert" env:"DB_SSLROOTCERT" usage:"CA certificate used to verify the database server"`
+	ConnectTimeout  time.Duration `config:"connect_timeout" env:"DB_CONNECT_TIMEOUT" usage:"how long to wait for a new connection, 0 waits forever"`
+	MaxOpenConns    int           `config:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"maximum open connections, 0 means unlimited"`
+	MaxIdleConns    int           `config:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"maximum idle connections kept in the pool"`
+	ConnMaxLifetime time.Duration `config:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"how long a connection is reused, 0 means forever"`
+}
+
+type AuthConfig struct {
+	JWKSFile         string        `config:"jwks_file" env:"JWT_JWKS_FILE" usage:"JSON Web Key Set used to verify bearer tokens"`
+	Issuer           string        `config:"issuer" env:"JWT_ISSUER" usage:"expected iss claim"`
+	Audience         string        `config:"audience" env:"JWT_AUDIENCE" usage:"expected aud claim"`
+	HMACClientsFile  string        `config:"hmac_clients_file" env:"HMAC_CLIENTS_FILE" usage:"signing clients file, signed requests are rejected when empty"`
+	HMACMaxSkew      time.Duration `config:"hmac_max_skew" env:"HMAC_MAX_SKEW" usage:"allowed clock difference for signed requests"`
+	HMACNonceBackend string        `config:"hmac_nonce_backend" env:"HMAC_NONCE_BACKEND" usage:"memory or postgres"`
+}
+
+type RateLimitConfig struct {
+	Backend string `config:"backend" env:"RATE_LIMIT_BACKEND" usage:"memory or postgres"`
+	Routes  string `config:"routes" env:"RATE_LIMITS" usage:"JSON that replaces the limits of the routes it names"`
+}
+
+type ApprovalConfig struct {
+	Threshold float64       `config:"threshold" env:"TRANSFER_APPROVAL_THRESHOLD" usage:"transfers above this amount need approval, 0 disables approvals"`
+	TTL       time.Duration `config:"ttl" env:"TRANSFER_APPROVAL_TTL" usage:"how long a transfer waits for approval"`
+}
+
+type OutboxConfig struct {
+	Publisher string `config:"publisher" env:"OUTBOX_PUBLISHER" usage:"none, stdout or file"`
+	File      string `config:"file" env:"OUTBOX_FILE" usage:"file events are appended to when the publisher is file"`
+}
+
+type LogConfig struct {
+	Level string `config:"level" env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+}
+
+type TracingConfig struct {
+	Exporter    string `config:"exporter" env:"OTEL_TRACES_EXPORTER" usage:"none, stdout, file or otlp"`
+	File        string `config:"file" env:"OTEL_TRACES_FILE" usage:"file spans are appended to when the exporter is file"`
+	ServiceName string `config:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name on the exported spans"`
+}
+
+// 可以单独关闭的功能
+type FeatureConfig struct {
+	GRPC      bool `config:"grpc" env:"FEATURE_GRPC" usage:"serve the gRPC API"`
+	Webhooks  bool `config:"webhooks" env:"FEATURE_WEBHOOKS" usage:"deliver events to registered webhooks"`
+	Snapshots bool `config:"snapshots" env:"FEATURE_SNAPSHOTS" usage:"take the daily balance snapshot"`
+}
+
+// 未配置时使用的值
+func defaultConfig() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ReadyTimeout:      defaultReadyTimeout,
+			ShutdownTimeout:   defaultShutdownTimeout,
+			DrainDelay:        defaultDrainDelay,
+		},
+		GRPC: GRPCConfig{Addr: ":9090"},
+		TLS:  TLSConfig{ClientAuth: "none", ReloadInterval: 10 * time.Second},
+		DB: DBConfig{
+			Port:           5432,
+			SSLMode:        "disable",
+			ConnectTimeout: 5 * time.Second,
+			MaxOpenConns:   500,
+			MaxIdleConns:   500,
+		},
+		Auth:      AuthConfig{HMACMaxSkew: 5 * time.Minute, HMACNonceBackend: "memory"},
+		RateLimit: RateLimitConfig{Backend: "memory"},
+		Approval:  ApprovalConfig{TTL: 24 * time.Hour},
+		Outbox:    OutboxConfig{Publisher: "none"},
+		Log:       LogConfig{Level: "info"},
+		Tracing:   TracingConfig{Exporter: "none", ServiceName: "wallet"},
+		Features:  FeatureConfig{GRPC: true, Webhooks: true, Snapshots: true},
+	}
+}
+
+// 按优先级合并各来源的配置并校验
+// 配置文件由 -config 参数或 CONFIG_FILE 环境变量指定，按扩展名读取 YAML 或 TOML
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := defaultConfig()
+	fields := cfg.fields()
+
+	fs := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML or TOML configuration file")
+	flags := map[string]string{}
+	for _, f := range fields {
+		fs.Var(&flagValue{name: f.key, flags: flags, isBool: f.value.Kind() == reflect.Bool}, f.key, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	path := *configFile
+	if path == "" {
+		path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	// 兼容旧的 PORT 环境变量，HTTP_ADDR 和命令行参数优先
+	if port, ok := lookupEnv("PORT"); ok && port != "" {
+		cfg.HTTP.Addr = ":" + port
+	}
+	// 空的环境变量视为未设置
+	for _, f := range fields {
+		if v, ok := lookupEnv(f.env); ok && v != "" {
+			if err := setConfigValue(f.value, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+	for _, f := range fields {
+		if v, ok := flags[f.key]; ok {
+			if err := setConfigValue(f.value, v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", f.key, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 检查配置，返回全部问题
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+	validAddr := func(addr string) bool {
+		_, _, err := net.SplitHostPort(addr)
+		return err == nil
+	}
+
+	check(validAddr(c.HTTP.Addr), "http.addr", "invalid address %q", c.HTTP.Addr)
+	check(c.HTTP.ReadHeaderTimeout >= 0, "http.read_header_timeout", "must not be negative")
+	check(c.HTTP.IdleTimeout >= 0, "http.idle_timeout", "must not be negative")
+	check(c.HTTP.ReadyTimeout > 0, "http.ready_timeout", "must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout", "must be positive")
+	check(c.HTTP.DrainDelay >= 0 && c.HTTP.DrainDelay < c.HTTP.ShutdownTimeout, "http.drain_delay", "must not be negative and must be less than http.shutdown_timeout")
+	if c.HTTP.RouteTimeouts != "" {
+		_, err := parseRouteTimeouts(c.HTTP.RouteTimeouts)
+		check(err == nil, "http.route_timeouts", "%v", err)
+	}
+	if c.Features.GRPC {
+		check(validAddr(c.GRPC.Addr), "grpc.addr", "invalid address %q", c.GRPC.Addr)
+	}
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls", "cert_file and key_file must be set together")
+	oneOf("tls.client_auth", c.TLS.ClientAuth, "none", "optional", "require")
+	if c.TLS.ClientAuth != "none" {
+		check(c.TLS.CertFile != "", "tls.client_auth", "requires tls.cert_file")
+		check(c.TLS.ClientCAFile != "", "tls.client_ca_file", "is required when tls.client_auth is %s", c.TLS.ClientAuth)
+	}
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval", "must be positive")
+
+	check(c.DB.Host != "", "db.host", "is required")
+	check(c.DB.Port > 0 && c.DB.Port <= 65535, "db.port", "must be between 1 and 65535")
+	check(c.DB.User != "", "db.user", "is required")
+	check(c.DB.Name != "", "db.name", "is required")
+	oneOf("db.sslmode", c.DB.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	check(c.DB.ConnectTimeout >= 0, "db.connect_timeout", "must not be negative")
+	check(c.DB.MaxOpenConns >= 0, "db.max_open_conns", "must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns", "must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "db.max_idle_conns", "must not exceed db.max_open_conns")
+	check(c.DB.ConnMaxLifetime >= 0, "db.conn_max_lifetime", "must not be negative")
+
+	check(c.Auth.JWKSFile != "", "auth.jwks_file", "is required")
+	check(c.Auth.HMACMaxSkew > 0, "auth.hmac_max_skew", "must be positive")
+
+	oneOf("auth.hmac_nonce_backend", c.Auth.HMACNonceBackend, "memory", "postgres")
+	oneOf("rate_limit.backend", c.RateLimit.Backend, "memory", "postgres")
+	if c.RateLimit.Routes != "" {
+		_, err := parseRateLimits(c.RateLimit.Routes)
+		check(err == nil, "rate_limit.routes", "%v", err)
+	}
+	check(c.Approval.Threshold >= 0, "approval.threshold", "must not be negative")
+	check(c.Approval.TTL > 0, "approval.ttl", "must be positive")
+
+	oneOf("outbox.publisher", c.Outbox.Publisher, "none", "stdout", "file")
+	check(c.Outbox.Publisher != "file" || c.Outbox.File != "", "outbox.file", "is required when outbox.publisher is file")
+	_, err := parseLogLevel(c.Log.Level)
+	check(err == nil, "log.level", "%v", err)
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "stdout", "file", "otlp")
+	check(c.Tracing.Exporter != "file" || c.Tracing.File != "", "tracing.file", "is required when tracing.exporter is file")
+
+	return errors.Join(errs...)
+}
+
+// PostgreSQL 连接字符串，值中的空格和引号按 libpq 的规则转义
+func (c DBConfig) DSN() string {
+	params := []string{
+		"host=" + quoteDSNValue(c.Host),
+		"port=" + strconv.Itoa(c.Port),
+		"user=" + quoteDSNValue(c.User),
+		"password=" + quoteDSNValue(c.Password),
+		"dbname=" + quoteDSNValue(c.Name),
+		"sslmode=" + quoteDSNValue(c.SSLMode),
+	}
+	if c.SSLRootCert != "" {
+		params = append(params, "sslrootcert="+quoteDSNValue(c.SSLRootCert))
+	}
+	// connect_timeout 以秒为单位
+	if c.ConnectTimeout > 0 {
+		params = append(params, "connect_timeout="+strconv.Itoa(int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+	return strings.Join(params, " ")
+}
+
+func quoteDSNValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// 以 YAML 输出生效的配置，密码等敏感字段只显示是否已设置
+func (c *Config) Print(w io.Writer) error {
+	section := ""
+	for _, f := range c.fields() {
+		name, key, _ := strings.Cut(f.key, ".")
+		if name != section {
+			if _, err := fmt.Fprintf(w, "%s:\n", name); err != nil {
+				return err
+			}
+			section = name
+		}
+		value := formatConfigValue(f.value)
+		if f.secret && value != "" {
+			value = "[redacted]"
+		}
+		if f.value.Kind() == reflect.String || f.value.Type() == durationType {
+			value = strconv.Quote(value)
+		}
+		if _, err := fmt.Fprintf(w, "  %s: %s\n", key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// config 子命令，目前只有 print
+func runConfigCommand(args []string, w io.Writer, lookupEnv func(string) (string, bool)) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: wallet config print [flags]")
+	}
+	cfg, err := LoadConfig(args[1:], lookupEnv)
+	if err != nil {
+		return err
+	}
+	return cfg.Print(w)
+}
+
+// 配置文件的顶层是各个分组，分组内是具体的值
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	switch ext := filepath.Ext(path); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("%s: unsupported config file type %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	fields := map[string]configField{}
+	for _, f := range c.fields() {
+		fields[f.key] = f
+	}
+	for name, section := range raw {
+		values, ok := section.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: %s must be a table of settings", path, name)
+		}
+		for key, v := range values {
+			f, ok := fields[name+"."+key]
+			if !ok {
+				return fmt.Errorf("%s: unknown setting %s.%s", path, name, key)
+			}
+			if err := setConfigValue(f.value, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, f.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+type configField struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// 按声明顺序列出全部字段
+func (c *Config) fields() []configField {
+	var fields []configField
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		prefix := root.Type().Field(i).Tag.Get("config")
+		for j := 0; j < section.NumField(); j++ {
+			tag := section.Type().Field(j).Tag
+			fields = append(fields, configField{
+				key:    prefix + "." + tag.Get("config"),
+				env:    tag.Get("env"),
+				usage:  tag.Get("usage"),
+				secret: tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// 三种来源的值都以字符串解析，时长使用 30s、5m 这样的格式
+func setConfigValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func formatConfigValue(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// 命令行参数先记录下来，在配置文件和环境变量之后再应用
+type flagValue struct {
+	name   string
+	flags  map[string]string
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	if f.flags == nil {
+		return ""
+	}
+	return f.flags[f.name]
+}
+
+func (f *flagValue) Set(s string) error {
+	f.flags[f.name] = s
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool { return f.isBool }
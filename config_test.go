@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 从 map 读取环境变量
+func envFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// 必填项之外使用默认值
+var requiredEnv = map[string]string{"DB_HOST": "localhost", "DB_USERNAME": "postgres", "DB_NAME": "wallet", "JWT_JWKS_FILE": "jwks.json"}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "wallet.yaml", `
+http:
+  addr: ":8000"
+  shutdown_timeout: 10s
+db:
+  host: db.internal
+  port: 6432
+  user: wallet
+  name: wallet
+  max_open_conns: 50
+  max_idle_conns: 10
+auth:
+  jwks_file: /etc/wallet/jwks.json
+approval:
+  threshold: 1000
+features:
+  grpc: false
+`)
+	env := map[string]string{"DB_PORT": "5433", "DB_MAX_IDLE_CONNS": "20", "DB_PASSWORD": "", "LOG_LEVEL": "debug"}
+
+	cfg, err := LoadConfig([]string{"-config", path, "-db.port", "5434", "-features.grpc"}, envFrom(env))
+	require.NoError(t, err)
+
+	// 配置文件覆盖默认值
+	assert.Equal(t, ":8000", cfg.HTTP.Addr)
+	assert.Equal(t, 10*time.Second, cfg.HTTP.ShutdownTimeout)
+	assert.Equal(t, "db.internal", cfg.DB.Host)
+	assert.Equal(t, 50, cfg.DB.MaxOpenConns)
+	assert.Equal(t, 1000.0, cfg.Approval.Threshold)
+	// 环境变量覆盖配置文件，空值视为未设置
+	assert.Equal(t, 20, cfg.DB.MaxIdleConns)
+	assert.Equal(t, "debug", cfg.Log.Level)
+	// 命令行参数优先级最高
+	assert.Equal(t, 5434, cfg.DB.Port)
+	assert.True(t, cfg.Features.GRPC)
+	// 没有配置的使用默认值
+	assert.Equal(t, ":9090", cfg.GRPC.Addr)
+	assert.Equal(t, "disable", cfg.DB.SSLMode)
+	assert.Equal(t, 24*time.Hour, cfg.Approval.TTL)
+}
+
+func TestLoadConfig_Port(t *testing.T) {
+	env := map[string]string{"PORT": "8000"}
+	for k, v := range requiredEnv {
+		env[k] = v
+	}
+
+	// 只设置 PORT 时监听该端口
+	cfg, err := LoadConfig(nil, envFrom(env))
+	require.NoError(t, err)
+	assert.Equal(t, ":8000", cfg.HTTP.Addr)
+
+	// HTTP_ADDR 和命令行参数优先
+	env["HTTP_ADDR"] = "127.0.0.1:8001"
+	cfg, err = LoadConfig(nil, envFrom(env))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8001", cfg.HTTP.Addr)
+	cfg, err = LoadConfig([]string{"-http.addr", ":8002"}, envFrom(env))
+	require.NoError(t, err)
+	assert.Equal(t, ":8002", cfg.HTTP.Addr)
+}
+
+func TestLoadConfig_TOML(t *testing.T) {
+	path := writeConfigFile(t, "wallet.toml", `
+[db]
+host = "db.internal"
+user = "wallet"
+name = "wallet"
+sslmode = "verify-full"
+conn_max_lifetime = "30m"
+
+[auth]
+jwks_file = "/etc/wallet/jwks.json"
+`)
+	cfg, err := LoadConfig(nil, envFrom(map[string]string{"CONFIG_FILE": path}))
+	require.NoError(t, err)
+	assert.Equal(t, "verify-full", cfg.DB.SSLMode)
+	assert.Equal(t, 30*time.Minute, cfg.DB.ConnMaxLifetime)
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		file          string
+		expectedError string
+	}{
+		{
+			name:          "Missing required settings",
+			env:           map[string]string{},
+			expectedError: "db.host: is required\ndb.user: is required\ndb.name: is required\nauth.jwks_file: is required",
+		},
+		{
+			name:          "Invalid env value",
+			env:           map[string]string{"DB_PORT": "abc"},
+			expectedError: `DB_PORT: invalid integer "abc"`,
+		},
+		{
+			name:          "Invalid flag value",
+			args:          []string{"-http.ready_timeout", "soon"},
+			expectedError: `-http.ready_timeout: time: invalid duration "soon"`,
+		},
+		{
+			name:          "Unknown setting in file",
+			file:          "db:\n  hostname: localhost\n",
+			expectedError: "unknown setting db.hostname",
+		},
+		{
+			name:          "Drain delay longer than shutdown timeout",
+			args:          []string{"-http.drain_delay", "30s"},
+			expectedError: "http.drain_delay: must not be negative and must be less than http.shutdown_timeout",
+		},
+		{
+			name:          "Invalid values",
+			args:          []string{"-db.sslmode", "on", "-db.max_idle_conns", "600", "-tls.cert_file", "cert.pem", "-outbox.publisher", "file"},
+			expectedError: "tls: cert_file and key_file must be set together\ndb.sslmode: must be one of disable, allow, prefer, require, verify-ca, verify-full, got \"on\"\ndb.max_idle_conns: must not exceed db.max_open_conns\noutbox.file: is required when outbox.publisher is file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			if env == nil {
+				env = requiredEnv
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfigFile(t, "wallet.yml", tt.file)}, args...)
+			}
+			_, err := LoadConfig(args, envFrom(env))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
+
+func TestDBConfigDSN(t *testing.T) {
+	cfg := defaultConfig().DB
+	cfg.Host, cfg.User, cfg.Password, cfg.Name = "localhost", "postgres", `it's a secret`, "wallet"
+	assert.Equal(t, `host=localhost port=5432 user=postgres password='it\'s a secret' dbname=wallet sslmode=disable connect_timeout=5`, cfg.DSN())
+
+	cfg.Password, cfg.SSLMode, cfg.SSLRootCert, cfg.ConnectTimeout = "", "verify-full", "/etc/ssl/ca.pem", 0
+	assert.Equal(t, `host=localhost port=5432 user=postgres password='' dbname=wallet sslmode=verify-full sslrootcert=/etc/ssl/ca.pem`, cfg.DSN())
+}
+
+func TestConfigPrint(t *testing.T) {
+	env := map[string]string{"DB_PASSWORD": "mysecretpassword123"}
+	for k, v := range requiredEnv {
+		env[k] = v
+	}
+	var out bytes.Buffer
+	require.NoError(t, runConfigCommand([]string{"print", "-db.max_open_conns", "600"}, &out, envFrom(env)))
+
+	assert.NotContains(t, out.String(), "mysecretpassword123")
+	assert.Contains(t, out.String(), "db:\n  host: \"localhost\"\n  port: 5432\n")
+	assert.Contains(t, out.String(), "  password: \"[redacted]\"\n")
+	assert.Contains(t, out.String(), "  max_open_conns: 600\n")
+	assert.Contains(t, out.String(), "  shutdown_timeout: \"30s\"\n")
+
+	// 输出的配置可以作为配置文件读回
+	path := writeConfigFile(t, "printed.yaml", out.String())
+	cfg, err := LoadConfig([]string{"-config", path}, envFrom(map[string]string{}))
+	require.NoError(t, err)
+	assert.Equal(t, 600, cfg.DB.MaxOpenConns)
+
+	assert.Error(t, runConfigCommand(nil, &out, envFrom(env)))
+}
@@ -1,75 +1,172 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"math"
 	"time"
-
-	"log"
 )
 
-type WalletAccess struct{}
+var ErrWalletNotFound = errors.New("wallet not found")
 
-func (wa *WalletAccess) UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error {
-	// 开始事务
-	tx, err := db.Begin()
-	if err != nil {
-		return err
+// 各方法的 ctx 取消或超时后语句被中断，事务回滚，返回 ctx 的错误
+type WalletAccess struct {
+	DB *sql.DB
+	// 为空时使用默认 logger
+	Log *slog.Logger
+
+	// WithTx 中的仓库绑定事务，各方法在该事务中执行，不再单独开启和提交事务
+	tx *sql.Tx
+}
+
+// *sql.DB 和 *sql.Tx 共有的查询方法
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// 在事务中时使用事务，否则使用连接池
+func (wa *WalletAccess) conn() queryer {
+	if wa.tx != nil {
+		return wa.tx
 	}
-	defer func() {
-		if err := tx.Rollback(); err != nil {
-			log.Printf("failed to rollback transaction: %v", err)
+	return wa.DB
+}
+
+// 请求中使用请求的 logger
+func (wa *WalletAccess) logger(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	if wa.Log == nil {
+		return slog.Default()
+	}
+	return wa.Log
+}
+
+// 语句因 ctx 取消或超时失败时，驱动返回的是数据库的错误，统一换成 ctx 的错误
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// 在一个事务中执行 fn，fn 返回 nil 时提交，否则回滚并返回 fn 的错误，fn 中使用收到的 ctx
+// fn 中通过 tx 执行的操作都在该事务中；已在事务中时使用保存点，fn 失败只撤销 fn 中的操作
+func (wa *WalletAccess) WithTx(ctx context.Context, fn func(ctx context.Context, uow *UnitOfWork) error) error {
+	if wa.tx != nil {
+		return wa.savepoint(ctx, fn)
+	}
+	return wa.inTx(ctx, func(tx *WalletAccess) error { return fn(ctx, &UnitOfWork{Wallet: tx, tx: tx.tx}) })
+}
+
+func (wa *WalletAccess) savepoint(ctx context.Context, fn func(ctx context.Context, uow *UnitOfWork) error) error {
+	if _, err := wa.tx.ExecContext(ctx, "SAVEPOINT wallet_tx"); err != nil {
+		return contextError(ctx, err)
+	}
+	if err := fn(ctx, &UnitOfWork{Wallet: wa, tx: wa.tx}); err != nil {
+		// 撤销失败时外层事务已不可用，返回撤销的错误使外层回滚
+		if _, rbErr := wa.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT wallet_tx"); rbErr != nil {
+			wa.logger(ctx).Error("failed to rollback to savepoint", "error", rbErr)
+			return contextError(ctx, rbErr)
 		}
-	}()
+		return err
+	}
+	_, err := wa.tx.ExecContext(ctx, "RELEASE SAVEPOINT wallet_tx")
+	return contextError(ctx, err)
+}
 
-	// 更新钱包余额
-	_, err = tx.Exec("UPDATE wallet SET balance = balance + $1 WHERE id = $2", amount, walletID)
+// logAttrs 附加在回滚失败的日志上
+func (wa *WalletAccess) inTx(ctx context.Context, fn func(tx *WalletAccess) error, logAttrs ...any) (err error) {
+	if wa.tx != nil {
+		return fn(wa)
+	}
+	defer func() { err = contextError(ctx, err) }()
+	// 开始事务，ctx 取消时 database/sql 自动回滚
+	tx, err := wa.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			wa.logger(ctx).Error("failed to rollback transaction", append(logAttrs, "error", err)...)
+		}
+	}()
 
-	// 插入交易记录
-	_, err = tx.Exec("INSERT INTO transactions (wallet_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4)", walletID, opType, amount, time.Now())
-	if err != nil {
+	if err := fn(&WalletAccess{DB: wa.DB, Log: wa.Log, tx: tx}); err != nil {
 		return err
 	}
 
 	// 提交事务
-	return tx.Commit()
+	return traceStep(ctx, "commit", tx.Commit)
 }
 
-// 锁定发起账户和接收账户
-func lockwalletForTransfer(tx *sql.Tx, fromWalletID int64, toWalletID int64) error {
-	_, err := tx.Exec("SELECT 1 FROM wallet WHERE id = $1 FOR UPDATE", fromWalletID)
-	if err != nil {
+func (wa *WalletAccess) UpdateBalance(ctx context.Context, walletID int64, opType string, amount float64) error {
+	return wa.inTx(ctx, func(tx *WalletAccess) error {
+		// 更新钱包余额
+		_, err := tracedExec(ctx, tx.tx, "update wallet", "UPDATE wallet SET balance = balance + $1 WHERE id = $2", amount, walletID)
+		if err != nil {
+			return err
+		}
+
+		// 插入交易记录
+		_, err = tracedExec(ctx, tx.tx, "insert transactions", "INSERT INTO transactions (wallet_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4)", walletID, opType, amount, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		// 在同一事务中写入事件
+		return traceStep(ctx, "insert outbox_events", func() error {
+			return insertOutboxEvent(ctx, tx.tx, WalletEvent{Type: opType, WalletID: walletID, Amount: math.Abs(amount)})
+		})
+	}, "wallet_id", walletID, "op_type", opType)
+}
+
+// 执行 lock 并按 op 记录等待钱包行锁的时间，所有钱包行锁都在这里计时
+func observeLockWait(op string, lock func() error) error {
+	defer func(start time.Time) { walletLockWait.WithLabelValues(op).Observe(time.Since(start).Seconds()) }(time.Now())
+	return lock()
+}
+
+// 锁定发起账户和接收账户，等待锁时 ctx 取消会中断语句
+func lockwalletForTransfer(ctx context.Context, tx *sql.Tx, fromWalletID int64, toWalletID int64) error {
+	return observeLockWait("transfer", func() error {
+		_, err := tx.ExecContext(ctx, "SELECT 1 FROM wallet WHERE id = $1 FOR UPDATE", fromWalletID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "SELECT 1 FROM wallet WHERE id = $1 FOR UPDATE", toWalletID)
 		return err
-	}
-	_, err = tx.Exec("SELECT 1 FROM wallet WHERE id = $1 FOR UPDATE", toWalletID)
-	return err
+	})
 }
 
 // 执行转账操作
-func performTransfer(tx *sql.Tx, fromWalletID int64, toWalletID int64, amount float64) error {
+func performTransfer(ctx context.Context, tx *sql.Tx, fromWalletID int64, toWalletID int64, amount float64) error {
 	// 扣除发起账户的余额
-	_, err := tx.Exec("UPDATE wallet SET balance = balance - $1 WHERE id = $2", amount, fromWalletID)
+	_, err := tracedExec(ctx, tx, "update wallet", "UPDATE wallet SET balance = balance - $1 WHERE id = $2", amount, fromWalletID)
 	if err != nil {
 		return fmt.Errorf("failed to deduct from sender's balance: %v", err)
 	}
 
 	// 增加接收账户的余额
-	_, err = tx.Exec("UPDATE wallet SET balance = balance + $1 WHERE id = $2", amount, toWalletID)
+	_, err = tracedExec(ctx, tx, "update wallet", "UPDATE wallet SET balance = balance + $1 WHERE id = $2", amount, toWalletID)
 	if err != nil {
 		return fmt.Errorf("failed to add to receiver's balance: %v", err)
 	}
 
 	// 插入发起账户的交易记录
-	_, err = tx.Exec("INSERT INTO transactions (wallet_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4)", fromWalletID, "transfer", -amount, time.Now())
+	_, err = tracedExec(ctx, tx, "insert transactions", "INSERT INTO transactions (wallet_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4)", fromWalletID, "transfer", -amount, time.Now().UTC())
 	if err != nil {
 		return fmt.Errorf("failed to insert sender's transaction: %v", err)
 	}
 
 	// 插入接收账户的交易记录
-	_, err = tx.Exec("INSERT INTO transactions (wallet_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4)", toWalletID, "transfer", amount, time.Now())
+	_, err = tracedExec(ctx, tx, "insert transactions", "INSERT INTO transactions (wallet_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4)", toWalletID, "transfer", amount, time.Now().UTC())
 	if err != nil {
 		return fmt.Errorf("failed to insert receiver's transaction: %v", err)
 	}
@@ -77,74 +174,123 @@ func performTransfer(tx *sql.Tx, fromWalletID int64, toWalletID int64, amount fl
 	return nil
 }
 
-func (wa *WalletAccess) ExecTransfer(db *sql.DB, fromId, toId int64, amount float64) error {
-	// 开始事务
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer func() {
-		if err := tx.Rollback(); err != nil {
-			log.Printf("failed to rollback transaction: %v", err)
+func (wa *WalletAccess) ExecTransfer(ctx context.Context, fromId, toId int64, amount float64) error {
+	return wa.inTx(ctx, func(tx *WalletAccess) error {
+		// 锁定发起钱包和接收钱包
+		err := traceStep(ctx, "lock wallets", func() error { return lockwalletForTransfer(ctx, tx.tx, fromId, toId) })
+		if err != nil {
+			return err
 		}
-	}()
 
-	// 锁定发起钱包和接收钱包
-	err = lockwalletForTransfer(tx, fromId, toId)
-	if err != nil {
-		return err
-	}
-
-	// 执行转账操作
-	err = performTransfer(tx, fromId, toId, amount)
-	if err != nil {
-		return err
-	}
+		// 执行转账操作
+		err = performTransfer(ctx, tx.tx, fromId, toId, amount)
+		if err != nil {
+			return err
+		}
 
-	// 提交事务
-	return tx.Commit()
+		// 在同一事务中写入事件
+		return traceStep(ctx, "insert outbox_events", func() error {
+			return insertOutboxEvent(ctx, tx.tx, WalletEvent{Type: "transfer", WalletID: fromId, ToWalletID: toId, Amount: amount})
+		})
+	}, "wallet_id", fromId, "to_wallet_id", toId, "op_type", "transfer")
 }
 
 // 根据钱包id获取钱包信息
-func (wa *WalletAccess) GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error) {
+func (wa *WalletAccess) GetWalletInfoById(ctx context.Context, walletID int64) (*Wallet, error) {
+	return wa.getWallet(ctx, "SELECT id, balance, user_id FROM wallet WHERE id = $1", walletID)
+}
+
+// 锁定钱包行并读取钱包信息，锁在事务结束时释放，需在 WithTx 中调用
+func (wa *WalletAccess) LockWallet(ctx context.Context, walletID int64) (*Wallet, error) {
+	if wa.tx == nil {
+		return nil, errors.New("LockWallet must be called within WithTx")
+	}
+	var wallet *Wallet
+	err := observeLockWait("lock_wallet", func() (err error) {
+		wallet, err = wa.getWallet(ctx, "SELECT id, balance, user_id FROM wallet WHERE id = $1 FOR UPDATE", walletID)
+		return err
+	})
+	return wallet, err
+}
+
+func (wa *WalletAccess) getWallet(ctx context.Context, query string, walletID int64) (*Wallet, error) {
 	var wallet Wallet
-	err := db.QueryRow("SELECT id, balance, user_id FROM wallet WHERE id = $1", walletID).
+	err := wa.conn().QueryRowContext(ctx, query, walletID).
 		Scan(&wallet.ID, &wallet.Balance, &wallet.UserID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("wallet not found")
+			return nil, ErrWalletNotFound
 		}
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 	return &wallet, nil
 }
 
 // 根据钱包 ID 获取交易记录
-func (wa *WalletAccess) GetTransactionsByWalletID(db *sql.DB, walletID int64, limit int, offset int) ([]Transaction, error) {
-	rows, err := db.Query(`
-		SELECT id, wallet_id, op_type, amount, created_at
+func (wa *WalletAccess) GetTransactionsByWalletID(ctx context.Context, walletID int64, limit int, offset int) ([]Transaction, error) {
+	rows, err := wa.conn().QueryContext(ctx, `
+		SELECT id, wallet_id, pocket_id, op_type, amount, created_at
 		FROM transactions
 		WHERE wallet_id = $1
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3
 	`, walletID, limit, offset)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 	defer rows.Close()
 
 	var transactions []Transaction
 	for rows.Next() {
 		var tx Transaction
-		if err := rows.Scan(&tx.ID, &tx.WalletID, &tx.OpType, &tx.Amount, &tx.CreatedAt); err != nil {
+		if err := rows.Scan(&tx.ID, &tx.WalletID, &tx.PocketID, &tx.OpType, &tx.Amount, &tx.CreatedAt); err != nil {
 			return nil, err
 		}
 		transactions = append(transactions, tx)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 
 	return transactions, nil
 }
+
+// 根据交易记录计算钱包在指定时间点的余额
+func (wa *WalletAccess) GetBalanceAt(ctx context.Context, walletID int64, at time.Time) (float64, error) {
+	// created_at 和快照日期是不带时区的 UTC 时间，比较前先换算到 UTC，否则时区偏移会被丢弃
+	at = at.UTC()
+
+	// 从指定时间之前最近的日终快照开始累加，没有快照时从头累加
+	var base float64
+	var cutoff time.Time
+	err := wa.conn().QueryRowContext(ctx, `
+		SELECT balance, snapshot_date + 1
+		FROM wallet_snapshots
+		WHERE wallet_id = $1 AND snapshot_date + 1 <= $2
+		ORDER BY snapshot_date DESC
+		LIMIT 1
+	`, walletID, at).Scan(&base, &cutoff)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, contextError(ctx, err)
+	}
+
+	var sum float64
+	if err == sql.ErrNoRows {
+		err = wa.conn().QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(amount), 0)
+			FROM transactions
+			WHERE wallet_id = $1 AND created_at <= $2
+		`, walletID, at).Scan(&sum)
+	} else {
+		err = wa.conn().QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(amount), 0)
+			FROM transactions
+			WHERE wallet_id = $1 AND created_at >= $2 AND created_at <= $3
+		`, walletID, cutoff, at).Scan(&sum)
+	}
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return base + sum, nil
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"testing"
 	"time"
@@ -20,21 +22,25 @@ func TestGetTransactionsByWalletID(t *testing.T) {
 	limit := 10
 	offset := 0
 
-	rows := sqlmock.NewRows([]string{"id", "wallet_id", "op_type", "amount", "created_at"}).
-		AddRow(1, walletID, "deposit", 100.0, time.Now()).
-		AddRow(2, walletID, "withdraw", 50.0, time.Now())
+	rows := sqlmock.NewRows([]string{"id", "wallet_id", "pocket_id", "op_type", "amount", "created_at"}).
+		AddRow(1, walletID, nil, "deposit", 100.0, time.Now()).
+		AddRow(2, walletID, nil, "withdraw", 50.0, time.Now()).
+		AddRow(3, walletID, 1, "pocket_move", 20.0, time.Now())
 
-	mock.ExpectQuery("SELECT id, wallet_id, op_type, amount, created_at FROM transactions WHERE wallet_id = \\$1 ORDER BY created_at DESC LIMIT \\$2 OFFSET \\$3").
+	mock.ExpectQuery("SELECT id, wallet_id, pocket_id, op_type, amount, created_at FROM transactions WHERE wallet_id = \\$1 ORDER BY created_at DESC LIMIT \\$2 OFFSET \\$3").
 		WithArgs(walletID, limit, offset).
 		WillReturnRows(rows)
-	wa := &WalletAccess{}
-	transactions, err := wa.GetTransactionsByWalletID(db, walletID, limit, offset)
+	wa := &WalletAccess{DB: db}
+	transactions, err := wa.GetTransactionsByWalletID(context.Background(), walletID, limit, offset)
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
 
-	if len(transactions) != 2 {
-		t.Errorf("expected 2 transactions, got %d", len(transactions))
+	if len(transactions) != 3 {
+		t.Errorf("expected 3 transactions, got %d", len(transactions))
+	}
+	if transactions[0].PocketID != nil || transactions[2].PocketID == nil || *transactions[2].PocketID != 1 {
+		t.Errorf("unexpected pocket ids: %+v", transactions)
 	}
 
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -61,8 +67,8 @@ func TestGetWalletInfoById(t *testing.T) {
 	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1").
 		WithArgs(walletID).
 		WillReturnRows(rows)
-	wa := &WalletAccess{}
-	wallet, err := wa.GetWalletInfoById(db, walletID)
+	wa := &WalletAccess{DB: db}
+	wallet, err := wa.GetWalletInfoById(context.Background(), walletID)
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
@@ -88,8 +94,8 @@ func TestGetWalletInfoById_NotFound(t *testing.T) {
 	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1").
 		WithArgs(walletID).
 		WillReturnError(sql.ErrNoRows)
-	wa := &WalletAccess{}
-	_, err = wa.GetWalletInfoById(db, walletID)
+	wa := &WalletAccess{DB: db}
+	_, err = wa.GetWalletInfoById(context.Background(), walletID)
 	if err == nil || err.Error() != "wallet not found" {
 		t.Errorf("expected 'wallet not found' error, got %v", err)
 	}
@@ -119,9 +125,13 @@ func TestUpdateBalance(t *testing.T) {
 		WithArgs(walletID, opType, amount, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
+	mock.ExpectExec("INSERT INTO outbox_events \\(event_id, event_type, wallet_id, to_wallet_id, payload, created_at\\)").
+		WithArgs(sqlmock.AnyArg(), opType, walletID, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	mock.ExpectCommit()
-	wa := &WalletAccess{}
-	err = wa.UpdateBalance(db, walletID, opType, amount)
+	wa := &WalletAccess{DB: db}
+	err = wa.UpdateBalance(context.Background(), walletID, opType, amount)
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
@@ -131,6 +141,47 @@ func TestUpdateBalance(t *testing.T) {
 	}
 }
 
+// 匹配 UTC 时间的参数
+type utcTime struct{}
+
+func (utcTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && t.Location() == time.UTC
+}
+
+// 进程时区不是 UTC 时，交易时间仍按 UTC 写入
+func TestUpdateBalance_StoresUTC(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC+8", 8*60*60)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").
+		WithArgs(100.0, walletID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions \\(wallet_id, op_type, amount, created_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").
+		WithArgs(walletID, "deposit", 100.0, utcTime{}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO outbox_events").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	wa := &WalletAccess{DB: db}
+	if err := wa.UpdateBalance(context.Background(), walletID, "deposit", 100.0); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestUpdateBalance_TransactionFail(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -149,8 +200,8 @@ func TestUpdateBalance_TransactionFail(t *testing.T) {
 		WillReturnError(fmt.Errorf("update failed"))
 
 	mock.ExpectRollback()
-	wa := &WalletAccess{}
-	err = wa.UpdateBalance(db, walletID, opType, amount)
+	wa := &WalletAccess{DB: db}
+	err = wa.UpdateBalance(context.Background(), walletID, opType, amount)
 	if err == nil || err.Error() != "update failed" {
 		t.Errorf("expected 'update failed' error, got %v", err)
 	}
@@ -182,8 +233,8 @@ func TestUpdateBalance_InsertTransactionFail(t *testing.T) {
 		WillReturnError(fmt.Errorf("insert transaction failed"))
 
 	mock.ExpectRollback()
-	wa := &WalletAccess{}
-	err = wa.UpdateBalance(db, walletID, opType, amount)
+	wa := &WalletAccess{DB: db}
+	err = wa.UpdateBalance(context.Background(), walletID, opType, amount)
 	if err == nil || err.Error() != "insert transaction failed" {
 		t.Errorf("expected 'insert transaction failed' error, got %v", err)
 	}
@@ -228,7 +279,7 @@ func TestPerformTransfer(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = performTransfer(tx, fromWalletID, toWalletID, amount)
+	err = performTransfer(context.Background(), tx, fromWalletID, toWalletID, amount)
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
@@ -266,7 +317,7 @@ func TestPerformTransfer_DeductFail(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = performTransfer(tx, fromWalletID, toWalletID, amount)
+	err = performTransfer(context.Background(), tx, fromWalletID, toWalletID, amount)
 	if err == nil || err.Error() != "failed to deduct from sender's balance: deduct failed" {
 		t.Errorf("expected 'failed to deduct from sender's balance: deduct failed' error, got %v", err)
 	}
@@ -308,7 +359,7 @@ func TestPerformTransfer_AddFail(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = performTransfer(tx, fromWalletID, toWalletID, amount)
+	err = performTransfer(context.Background(), tx, fromWalletID, toWalletID, amount)
 	if err == nil || err.Error() != "failed to add to receiver's balance: add failed" {
 		t.Errorf("expected 'failed to add to receiver's balance: add failed' error, got %v", err)
 	}
@@ -354,7 +405,7 @@ func TestPerformTransfer_InsertSenderTransactionFail(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = performTransfer(tx, fromWalletID, toWalletID, amount)
+	err = performTransfer(context.Background(), tx, fromWalletID, toWalletID, amount)
 	if err == nil || err.Error() != "failed to insert sender's transaction: insert sender transaction failed" {
 		t.Errorf("expected 'failed to insert sender's transaction: insert sender transaction failed' error, got %v", err)
 	}
@@ -404,7 +455,7 @@ func TestPerformTransfer_InsertReceiverTransactionFail(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = performTransfer(tx, fromWalletID, toWalletID, amount)
+	err = performTransfer(context.Background(), tx, fromWalletID, toWalletID, amount)
 	if err == nil || err.Error() != "failed to insert receiver's transaction: insert receiver transaction failed" {
 		t.Errorf("expected 'failed to insert receiver's transaction: insert receiver transaction failed' error, got %v", err)
 	}
@@ -441,7 +492,7 @@ func TestLockwalletForTransfer_LockFromWalletFail(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = lockwalletForTransfer(tx, fromWalletID, toWalletID)
+	err = lockwalletForTransfer(context.Background(), tx, fromWalletID, toWalletID)
 	if err == nil || err.Error() != "lock from wallet failed" {
 		t.Errorf("expected 'lock from wallet failed' error, got %v", err)
 	}
@@ -482,7 +533,7 @@ func TestLockwalletForTransfer_LockToWalletFail(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 
-	err = lockwalletForTransfer(tx, fromWalletID, toWalletID)
+	err = lockwalletForTransfer(context.Background(), tx, fromWalletID, toWalletID)
 	if err == nil || err.Error() != "lock to wallet failed" {
 		t.Errorf("expected 'lock to wallet failed' error, got %v", err)
 	}
@@ -532,10 +583,14 @@ func TestExecTransfer(t *testing.T) {
 		WithArgs(toWalletID, "transfer", amount, sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
+	mock.ExpectExec("INSERT INTO outbox_events \\(event_id, event_type, wallet_id, to_wallet_id, payload, created_at\\)").
+		WithArgs(sqlmock.AnyArg(), "transfer", fromWalletID, toWalletID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	mock.ExpectCommit()
 
-	wa := &WalletAccess{}
-	err = wa.ExecTransfer(db, fromWalletID, toWalletID, amount)
+	wa := &WalletAccess{DB: db}
+	err = wa.ExecTransfer(context.Background(), fromWalletID, toWalletID, amount)
 	if err != nil {
 		t.Errorf("unexpected error: %s", err)
 	}
@@ -564,8 +619,8 @@ func TestExecTransfer_LockFail(t *testing.T) {
 
 	mock.ExpectRollback()
 
-	wa := &WalletAccess{}
-	err = wa.ExecTransfer(db, fromWalletID, toWalletID, amount)
+	wa := &WalletAccess{DB: db}
+	err = wa.ExecTransfer(context.Background(), fromWalletID, toWalletID, amount)
 	if err == nil || err.Error() != "lock from wallet failed" {
 		t.Errorf("expected 'lock from wallet failed' error, got %v", err)
 	}
@@ -575,6 +630,49 @@ func TestExecTransfer_LockFail(t *testing.T) {
 	}
 }
 
+// 等待行锁时超时，语句被中断，事务回滚
+func TestExecTransfer_LockTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	fromWalletID := int64(1)
+	toWalletID := int64(2)
+	amount := 100.0
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(fromWalletID).
+		WillDelayFor(time.Second).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	wa := &WalletAccess{DB: db}
+	start := time.Now()
+	err = wa.ExecTransfer(ctx, fromWalletID, toWalletID, amount)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the lock to be abandoned at the deadline, took %s", elapsed)
+	}
+
+	// database/sql 在后台回滚 ctx 已结束的事务
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestExecTransfer_TransferFail(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -602,8 +700,8 @@ func TestExecTransfer_TransferFail(t *testing.T) {
 
 	mock.ExpectRollback()
 
-	wa := &WalletAccess{}
-	err = wa.ExecTransfer(db, fromWalletID, toWalletID, amount)
+	wa := &WalletAccess{DB: db}
+	err = wa.ExecTransfer(context.Background(), fromWalletID, toWalletID, amount)
 	if err == nil || err.Error() != "failed to deduct from sender's balance: deduct failed" {
 		t.Errorf("expected 'failed to deduct from sender's balance: deduct failed' error, got %v", err)
 	}
@@ -612,3 +710,196 @@ func TestExecTransfer_TransferFail(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestGetBalanceAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+	at := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT balance, snapshot_date \\+ 1 FROM wallet_snapshots WHERE wallet_id = \\$1 AND snapshot_date \\+ 1 <= \\$2").
+		WithArgs(walletID, at).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transactions WHERE wallet_id = \\$1 AND created_at <= \\$2").
+		WithArgs(walletID, at).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(30.0))
+	wa := &WalletAccess{DB: db}
+	balance, err := wa.GetBalanceAt(context.Background(), walletID, at)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if balance != 30.0 {
+		t.Errorf("expected balance 30, got %v", balance)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 带时区偏移的时间换算到 UTC 后查询，与进程的时区无关
+func TestGetBalanceAt_Offset(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("UTC-5", -5*60*60)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+	at := time.Date(2024, 6, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*60*60))
+	utc := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT balance, snapshot_date \\+ 1 FROM wallet_snapshots WHERE wallet_id = \\$1 AND snapshot_date \\+ 1 <= \\$2").
+		WithArgs(walletID, utc).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transactions WHERE wallet_id = \\$1 AND created_at <= \\$2").
+		WithArgs(walletID, utc).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(30.0))
+	wa := &WalletAccess{DB: db}
+	balance, err := wa.GetBalanceAt(context.Background(), walletID, at)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if balance != 30.0 {
+		t.Errorf("expected balance 30, got %v", balance)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetBalanceAt_FromSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT balance, snapshot_date \\+ 1 FROM wallet_snapshots WHERE wallet_id = \\$1 AND snapshot_date \\+ 1 <= \\$2").
+		WithArgs(walletID, at).
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "cutoff"}).AddRow(100.0, cutoff))
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\), 0\\) FROM transactions WHERE wallet_id = \\$1 AND created_at >= \\$2 AND created_at <= \\$3").
+		WithArgs(walletID, cutoff, at).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(-20.0))
+	wa := &WalletAccess{DB: db}
+	balance, err := wa.GetBalanceAt(context.Background(), walletID, at)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if balance != 80.0 {
+		t.Errorf("expected balance 80, got %v", balance)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 锁定钱包、检查余额和扣款在同一个事务中提交
+func TestWithTx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(walletID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(walletID, 100.0, "user1"))
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").
+		WithArgs(-30.0, walletID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions").
+		WithArgs(walletID, "withdraw", -30.0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO outbox_events").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	wa := &WalletAccess{DB: db}
+	err = wa.WithTx(context.Background(), func(ctx context.Context, uow *UnitOfWork) error {
+		wallet, err := uow.Wallet.LockWallet(ctx, walletID)
+		if err != nil {
+			return err
+		}
+		if wallet.Balance < 30 {
+			return ErrNotEnough
+		}
+		return uow.Wallet.UpdateBalance(ctx, walletID, "withdraw", -30)
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// fn 返回错误时整个事务回滚
+func TestWithTx_Rollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(walletID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(walletID, 10.0, "user1"))
+	mock.ExpectRollback()
+
+	wa := &WalletAccess{DB: db}
+	err = wa.WithTx(context.Background(), func(ctx context.Context, uow *UnitOfWork) error {
+		wallet, err := uow.Wallet.LockWallet(ctx, walletID)
+		if err != nil {
+			return err
+		}
+		if wallet.Balance < 30 {
+			return ErrNotEnough
+		}
+		return uow.Wallet.ExecTransfer(ctx, walletID, 2, 30)
+	})
+	if err != ErrNotEnough {
+		t.Errorf("expected ErrNotEnough, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLockWallet_OutsideTx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	wa := &WalletAccess{DB: db}
+	if _, err := wa.LockWallet(context.Background(), 1); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
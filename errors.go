@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 错误分类，HTTP 和 gRPC 接口按同一张表映射状态码
+type ErrorCode int
+
+const (
+	CodeInternal ErrorCode = iota
+	CodeInvalidArgument
+	CodeUnauthenticated
+	CodePermissionDenied
+	CodeNotFound
+	CodeConflict
+	CodeInsufficientFunds
+	CodeRateLimited
+	CodeDeadlineExceeded
+	CodeCanceled
+)
+
+// 各错误分类对应的 HTTP 和 gRPC 状态码
+// 余额不足在 HTTP 接口中一直以 200 返回，为兼容已有客户端保持不变
+// 客户端断开时响应不会被读取，按 nginx 的习惯记为 499
+var errorStatus = map[ErrorCode]struct {
+	HTTP int
+	GRPC codes.Code
+}{
+	CodeInternal:          {http.StatusInternalServerError, codes.Internal},
+	CodeInvalidArgument:   {http.StatusBadRequest, codes.InvalidArgument},
+	CodeUnauthenticated:   {http.StatusUnauthorized, codes.Unauthenticated},
+	CodePermissionDenied:  {http.StatusForbidden, codes.PermissionDenied},
+	CodeNotFound:          {http.StatusNotFound, codes.NotFound},
+	CodeConflict:          {http.StatusConflict, codes.FailedPrecondition},
+	CodeInsufficientFunds: {http.StatusOK, codes.FailedPrecondition},
+	CodeRateLimited:       {http.StatusTooManyRequests, codes.ResourceExhausted},
+	CodeDeadlineExceeded:  {http.StatusGatewayTimeout, codes.DeadlineExceeded},
+	CodeCanceled:          {499, codes.Canceled},
+}
+
+// 业务层返回的带分类的错误
+type APIError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func newAPIError(code ErrorCode, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+var (
+	ErrUnauthenticated = newAPIError(CodeUnauthenticated, "unauthorized")
+	ErrForbidden       = newAPIError(CodePermissionDenied, "forbidden")
+	ErrRateLimited     = newAPIError(CodeRateLimited, "rate limit exceeded")
+)
+
+// 错误的分类，数据访问层的错误按类型归类，其余视为内部错误
+func errorCodeOf(err error) ErrorCode {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	switch err {
+	case ErrWalletNotFound, ErrPocketNotFound, ErrUserNotFound, ErrMemberNotFound, ErrTransferRequestNotFound, ErrAPIKeyNotFound,
+		ErrWebhookNotFound, ErrWebhookDeliveryNotFound:
+		return CodeNotFound
+	case ErrPocketExists, ErrUserExists, ErrTransferRequestNotPending, ErrAPIKeyInactive, ErrWebhookDeliveryNotDead:
+		return CodeConflict
+	case ErrNotEnough:
+		return CodeInsufficientFunds
+	}
+	// 超时或取消时事务已回滚
+	if errors.Is(err, context.DeadlineExceeded) {
+		return CodeDeadlineExceeded
+	}
+	if errors.Is(err, context.Canceled) {
+		return CodeCanceled
+	}
+	return CodeInternal
+}
+
+// 按错误分类写入 HTTP 响应，内部错误记录到请求日志，响应中只有错误信息
+func writeError(c *gin.Context, err error) {
+	if errorCodeOf(err) == CodeInternal {
+		loggerFrom(c.Request.Context()).Error("request failed", "error", err)
+	}
+	c.JSON(errorStatus[errorCodeOf(err)].HTTP, gin.H{"error": err.Error()})
+}
+
+// 按错误分类转换为 gRPC 状态
+func grpcError(err error) error {
+	return status.Error(errorStatus[errorCodeOf(err)].GRPC, err.Error())
+}
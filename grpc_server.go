@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"wallet/walletpb"
+)
+
+// gRPC 接口，与 HTTP 接口共用业务逻辑、认证、限流和错误映射
+type walletServer struct {
+	walletpb.UnimplementedWalletServiceServer
+	a *App
+}
+
+func (a *App) newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(a.grpcLogInterceptor, a.grpcAuthInterceptor))...)
+	walletpb.RegisterWalletServiceServer(s, &walletServer{a: a})
+	return s
+}
+
+type callerContextKey struct{}
+
+// 按 metadata 中的 x-api-key 或 authorization 认证调用者，与 HTTP 的请求头一致
+// 没有凭证的请求交给各方法处理，凭证无效时直接返回 Unauthenticated
+func (a *App) grpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	caller, err := a.authenticate(ctx, firstMetadata(md, "x-api-key"), firstMetadata(md, "authorization"))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if caller != nil {
+		caller.CertSubject = grpcCertSubject(ctx)
+		ctx = context.WithValue(ctx, callerContextKey{}, caller)
+	}
+	return handler(ctx, req)
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// 当前请求的调用者，未认证时为 nil
+func grpcCaller(ctx context.Context) *Principal {
+	caller, _ := ctx.Value(callerContextKey{}).(*Principal)
+	return caller
+}
+
+// 按路由限流，超出时通过 retry-after 头返回需要等待的秒数
+func (s *walletServer) rateLimit(ctx context.Context, route string, walletID int64) error {
+	var clientIP string
+	if p, ok := peer.FromContext(ctx); ok {
+		clientIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(clientIP); err == nil {
+			clientIP = host
+		}
+	}
+	wait, err := s.a.checkRateLimit(ctx, route, grpcCaller(ctx), clientIP, func() int64 { return walletID })
+	if err != nil {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter(wait)))
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *walletServer) GetBalance(ctx context.Context, req *walletpb.GetBalanceRequest) (*walletpb.GetBalanceResponse, error) {
+	ctx, cancel := s.a.withRouteDeadline(ctx, "balance")
+	defer cancel()
+	caller := grpcCaller(ctx)
+
+	// 指定 at 时按交易记录计算历史余额
+	var balance *WalletBalance
+	var err error
+	if req.At != nil {
+		balance, err = s.a.getWalletBalanceAt(ctx, caller, req.WalletId, req.At.AsTime())
+	} else {
+		balance, err = s.a.getWalletBalance(ctx, caller, req.WalletId)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &walletpb.GetBalanceResponse{Balance: balance.Balance, MainBalance: balance.MainBalance}
+	for _, p := range balance.Pockets {
+		resp.Pockets = append(resp.Pockets, &walletpb.Pocket{Id: p.ID, WalletId: p.WalletID, Name: p.Name, Balance: p.Balance})
+	}
+	return resp, nil
+}
+
+func (s *walletServer) Deposit(ctx context.Context, req *walletpb.DepositRequest) (*walletpb.DepositResponse, error) {
+	ctx, cancel := s.a.withRouteDeadline(ctx, "deposit_withdraw")
+	defer cancel()
+	if err := s.rateLimit(ctx, "deposit_withdraw", req.WalletId); err != nil {
+		return nil, err
+	}
+	if err := s.a.depositWithdraw(ctx, grpcCaller(ctx), req.WalletId, "deposit", req.Amount, 0); err != nil {
+		return nil, grpcError(err)
+	}
+	return &walletpb.DepositResponse{}, nil
+}
+
+func (s *walletServer) Withdraw(ctx context.Context, req *walletpb.WithdrawRequest) (*walletpb.WithdrawResponse, error) {
+	ctx, cancel := s.a.withRouteDeadline(ctx, "deposit_withdraw")
+	defer cancel()
+	if err := s.rateLimit(ctx, "deposit_withdraw", req.WalletId); err != nil {
+		return nil, err
+	}
+	if err := s.a.depositWithdraw(ctx, grpcCaller(ctx), req.WalletId, "withdraw", req.Amount, req.PocketId); err != nil {
+		return nil, grpcError(err)
+	}
+	return &walletpb.WithdrawResponse{}, nil
+}
+
+func (s *walletServer) Transfer(ctx context.Context, req *walletpb.TransferRequest) (*walletpb.TransferResponse, error) {
+	ctx, cancel := s.a.withRouteDeadline(ctx, "transfer")
+	defer cancel()
+	if err := s.rateLimit(ctx, "transfer", req.FromWalletId); err != nil {
+		return nil, err
+	}
+	tr, err := s.a.transfer(ctx, grpcCaller(ctx), req.FromWalletId, req.FromPocketId, req.ToWalletId, req.Amount)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	// 超过阈值的转账等待审批
+	if tr != nil {
+		return &walletpb.TransferResponse{Pending: true, RequestId: tr.ID}, nil
+	}
+	return &walletpb.TransferResponse{}, nil
+}
+
+func (s *walletServer) ListTransactions(ctx context.Context, req *walletpb.ListTransactionsRequest) (*walletpb.ListTransactionsResponse, error) {
+	ctx, cancel := s.a.withRouteDeadline(ctx, "transactions")
+	defer cancel()
+	// 与 HTTP 接口一致，每页默认 10 条记录
+	limit := int(req.Limit)
+	if limit == 0 {
+		limit = 10
+	}
+	transactions, err := s.a.listTransactions(ctx, grpcCaller(ctx), req.WalletId, limit, int(req.Offset))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &walletpb.ListTransactionsResponse{}
+	for _, t := range transactions {
+		tx := &walletpb.Transaction{
+			Id:        t.ID,
+			WalletId:  t.WalletID,
+			OpType:    t.OpType,
+			Amount:    t.Amount,
+			CreatedAt: timestamppb.New(t.CreatedAt),
+		}
+		if t.PocketID != nil {
+			tx.PocketId = *t.PocketID
+		}
+		resp.Transactions = append(resp.Transactions, tx)
+	}
+	return resp, nil
+}
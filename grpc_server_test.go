@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"wallet/walletpb"
+)
+
+// 在内存中启动 gRPC 服务并返回客户端
+func newTestGRPCClient(t *testing.T, a *App) walletpb.WalletServiceClient {
+	lis := bufconn.Listen(1 << 20)
+	s := a.newGRPCServer()
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return walletpb.NewWalletServiceClient(conn)
+}
+
+func TestGRPCWalletService(t *testing.T) {
+	// Initialize the app and start the server
+	a := &App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Ap: newMockApprovalRepo(), Ak: newMockAPIKeyRepo(), Tokens: testTokens, ApprovalThreshold: 200}
+	client := newTestGRPCClient(t, a)
+
+	withToken := func(userID string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", bearer(userID))
+	}
+
+	resp, err := client.GetBalance(withToken("user1"), &walletpb.GetBalanceRequest{WalletId: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 125.0, resp.Balance)
+	assert.Equal(t, 100.0, resp.MainBalance)
+	require.Len(t, resp.Pockets, 1)
+	assert.Equal(t, int64(1), resp.Pockets[0].Id)
+
+	// 历史余额与当前余额的结构相同
+	resp, err = client.GetBalance(withToken("user1"), &walletpb.GetBalanceRequest{WalletId: 1, At: timestamppb.New(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))})
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, resp.Balance)
+	assert.Equal(t, 20.0, resp.MainBalance)
+	require.Len(t, resp.Pockets, 1)
+	assert.Equal(t, 10.0, resp.Pockets[0].Balance)
+
+	_, err = client.Deposit(withToken("user1"), &walletpb.DepositRequest{WalletId: 1, Amount: 10})
+	assert.NoError(t, err)
+
+	transfer, err := client.Transfer(withToken("user1"), &walletpb.TransferRequest{FromWalletId: 1, ToWalletId: 2, Amount: 500})
+	require.NoError(t, err)
+	assert.True(t, transfer.Pending)
+	assert.NotZero(t, transfer.RequestId)
+
+	txs, err := client.ListTransactions(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "wk_reader"), &walletpb.ListTransactionsRequest{WalletId: 1})
+	require.NoError(t, err)
+	assert.Len(t, txs.Transactions, 2)
+
+	_, err = client.GetBalance(metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer bad"), &walletpb.GetBalanceRequest{WalletId: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+// 同一请求经 HTTP 和 gRPC 调用时，两边的状态码必须对应同一个错误分类
+func TestGRPCAndHTTPErrorParity(t *testing.T) {
+	// Initialize the app and set up the route
+	a := &App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Ap: newMockApprovalRepo(), Ak: newMockAPIKeyRepo(), Tokens: testTokens, ApprovalThreshold: 200}
+	router := a.setupRouter()
+	client := newTestGRPCClient(t, a)
+
+	// Test cases
+	tests := []struct {
+		name         string
+		userID       string
+		method       string
+		path         string
+		body         string
+		call         func(ctx context.Context) error
+		expectedCode ErrorCode
+	}{
+		{
+			name:   "Unauthenticated",
+			method: "GET", path: "/api/balance/1",
+			call: func(ctx context.Context) error {
+				_, err := client.GetBalance(ctx, &walletpb.GetBalanceRequest{WalletId: 1})
+				return err
+			},
+			expectedCode: CodeUnauthenticated,
+		},
+		{
+			name:   "Wallet not found",
+			userID: "user1", method: "GET", path: "/api/transaction/2",
+			call: func(ctx context.Context) error {
+				_, err := client.ListTransactions(ctx, &walletpb.ListTransactionsRequest{WalletId: 2})
+				return err
+			},
+			expectedCode: CodeNotFound,
+		},
+		{
+			name:   "Viewer cannot withdraw",
+			userID: "viewer1", method: "PUT", path: "/api/balance/1", body: `{"op_type": "withdraw", "amount": 10}`,
+			call: func(ctx context.Context) error {
+				_, err := client.Withdraw(ctx, &walletpb.WithdrawRequest{WalletId: 1, Amount: 10})
+				return err
+			},
+			expectedCode: CodePermissionDenied,
+		},
+		{
+			name:   "Not enough",
+			userID: "user1", method: "POST", path: "/api/transfer", body: `{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 150}`,
+			call: func(ctx context.Context) error {
+				_, err := client.Transfer(ctx, &walletpb.TransferRequest{FromWalletId: 1, ToWalletId: 2, Amount: 150})
+				return err
+			},
+			expectedCode: CodeInsufficientFunds,
+		},
+		{
+			name:   "Same wallet",
+			userID: "user1", method: "POST", path: "/api/transfer", body: `{"from_wallet_id": 1, "to_wallet_id": 1, "amount": 10}`,
+			call: func(ctx context.Context) error {
+				_, err := client.Transfer(ctx, &walletpb.TransferRequest{FromWalletId: 1, ToWalletId: 1, Amount: 10})
+				return err
+			},
+			expectedCode: CodeInvalidArgument,
+		},
+		{
+			name:   "Pocket not found",
+			userID: "user1", method: "PUT", path: "/api/balance/1", body: `{"op_type": "withdraw", "amount": 10, "pocket_id": 9}`,
+			call: func(ctx context.Context) error {
+				_, err := client.Withdraw(ctx, &walletpb.WithdrawRequest{WalletId: 1, Amount: 10, PocketId: 9})
+				return err
+			},
+			expectedCode: CodeNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			ctx := context.Background()
+			if tt.userID != "" {
+				req.Header.Set("Authorization", bearer(tt.userID))
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", bearer(tt.userID))
+			}
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that both transports report the same error
+			assert.Equal(t, errorStatus[tt.expectedCode].HTTP, rec.Code, rec.Body.String())
+			assert.Equal(t, errorStatus[tt.expectedCode].GRPC, status.Code(tt.call(ctx)))
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type AccountRequest struct {
@@ -17,43 +18,31 @@ func (a *App) depositWithdrawHandler(c *gin.Context) {
 		return
 	}
 	var request struct {
-		OpType string  `json:"op_type"`
-		Amount float64 `json:"amount"`
+		OpType   string  `json:"op_type"`
+		Amount   float64 `json:"amount"`
+		PocketID int64   `json:"pocket_id"`
 	}
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if !(request.OpType == "deposit" || request.OpType == "withdraw") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operation type"})
-		return
-	}
-	// 获取钱包信息
-	wallet, err := a.Rp.GetWalletInfoById(a.DB, req.Id)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
-		return
-	}
-	if request.OpType == "withdraw" && wallet.Balance < request.Amount {
-		c.JSON(http.StatusOK, gin.H{"message": "not enough"})
-		return
 
+	caller, _ := callerFrom(c)
+	err := a.depositWithdraw(c.Request.Context(), caller, req.Id, request.OpType, request.Amount, request.PocketID)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"message": request.OpType + " successful"})
+	case ErrNotEnough:
+		c.JSON(http.StatusOK, gin.H{"message": "not enough"})
+	default:
+		writeError(c, err)
 	}
-	if request.OpType == "withdraw" {
-		request.Amount = -request.Amount
-	}
-	err = a.Rp.UpdateBalance(a.DB, wallet.ID, request.OpType, request.Amount)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{"message": request.OpType + " successful"})
 }
 
 func (a *App) transferHandler(c *gin.Context) {
 	var request struct {
 		FromWalletId int64   `json:"from_wallet_id"`
+		FromPocketId int64   `json:"from_pocket_id"`
 		ToWalletId   int64   `json:"to_wallet_id"`
 		Amount       float64 `json:"amount"`
 	}
@@ -64,30 +53,15 @@ func (a *App) transferHandler(c *gin.Context) {
 		return
 	}
 
-	// 检查金额是否为正数
-	if request.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "transfer amount must be positive"})
-		return
-	}
-
-	if request.FromWalletId <= 0 || request.ToWalletId <= 0 || request.FromWalletId == request.ToWalletId {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wallet id"})
-		return
-	}
-
-	// 获取发起钱包和接收钱包的信息
-	fromWallet, err := a.Rp.GetWalletInfoById(a.DB, request.FromWalletId)
+	caller, _ := callerFrom(c)
+	tr, err := a.transfer(c.Request.Context(), caller, request.FromWalletId, request.FromPocketId, request.ToWalletId, request.Amount)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "from wallet not found"})
+		writeError(c, err)
 		return
 	}
-	if fromWallet.Balance < request.Amount {
-		c.JSON(http.StatusOK, gin.H{"error": "not enough"})
-		return
-	}
-
-	if err := a.Rp.ExecTransfer(a.DB, request.FromWalletId, request.ToWalletId, request.Amount); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer failed"})
+	// 超过阈值的转账等待审批
+	if tr != nil {
+		c.JSON(http.StatusAccepted, gin.H{"message": "transfer pending approval", "request_id": tr.ID})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "transfer successful"})
@@ -99,15 +73,30 @@ func (a *App) getBalanceHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	caller, _ := callerFrom(c)
 
-	// 获取钱包信息
-	wallet, err := a.Rp.GetWalletInfoById(a.DB, req.Id)
+	// 指定 at 参数时，按交易记录计算历史余额
+	var balance *WalletBalance
+	var err error
+	if at := c.Query("at"); at != "" {
+		atTime, parseErr := time.Parse(time.RFC3339, at)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid at"})
+			return
+		}
+		balance, err = a.getWalletBalanceAt(c.Request.Context(), caller, req.Id, atTime)
+	} else {
+		balance, err = a.getWalletBalance(c.Request.Context(), caller, req.Id)
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		writeError(c, err)
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"balance": wallet.Balance})
+	c.JSON(http.StatusOK, gin.H{
+		"balance":      balance.Balance,
+		"main_balance": balance.MainBalance,
+		"pockets":      balance.Pockets,
+	})
 }
 
 // 查询交易记录的 Handler
@@ -122,32 +111,22 @@ func (a *App) getTransactions(c *gin.Context) {
 
 	// 将查询参数转换为整数
 	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt <= 0 {
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
 		return
 	}
 
 	offsetInt, err := strconv.Atoi(offset)
-	if err != nil || offsetInt < 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
-		return
-	}
-
-	// 获取钱包信息
-	wallet, err := a.Rp.GetWalletInfoById(a.DB, req.Id)
 	if err != nil {
-		if err.Error() == "wallet not found" {
-			c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
 		return
 	}
 
 	// 获取钱包的交易记录
-	transactions, err := a.Rp.GetTransactionsByWalletID(a.DB, wallet.ID, limitInt, offsetInt)
+	caller, _ := callerFrom(c)
+	transactions, err := a.listTransactions(c.Request.Context(), caller, req.Id, limitInt, offsetInt)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
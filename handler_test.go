@@ -2,44 +2,89 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
-type MockWalletRepo struct{}
+// 钱包 1 余额 100，其他钱包不存在，设置 Err 字段后对应的方法返回该错误
+type MockWalletRepo struct {
+	UpdateErr       error
+	TransferErr     error
+	WalletErr       error
+	TransactionsErr error
+}
 
-func (m *MockWalletRepo) UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error {
+func (m *MockWalletRepo) UpdateBalance(ctx context.Context, walletID int64, opType string, amount float64) error {
+	if m.UpdateErr != nil {
+		return m.UpdateErr
+	}
 	if walletID == 1 {
 		return nil
 	}
 	return errors.New("update balance failed")
 }
 
-func (m *MockWalletRepo) ExecTransfer(db *sql.DB, fromWalletID, toWalletID int64, amount float64) error {
-	return nil
+func (m *MockWalletRepo) ExecTransfer(ctx context.Context, fromWalletID, toWalletID int64, amount float64) error {
+	return m.TransferErr
 }
 
-func (m *MockWalletRepo) GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error) {
-	if walletID == 1 {
+func (m *MockWalletRepo) GetWalletInfoById(ctx context.Context, walletID int64) (*Wallet, error) {
+	if m.WalletErr != nil {
+		return nil, m.WalletErr
+	}
+	switch walletID {
+	case 1:
 		return &Wallet{ID: 1, Balance: 100.0}, nil
+	case 2:
+		return &Wallet{ID: 2, UserID: "user2"}, nil
+	}
+	return nil, ErrWalletNotFound
+}
+
+func (m *MockWalletRepo) LockWallet(ctx context.Context, walletID int64) (*Wallet, error) {
+	return m.GetWalletInfoById(ctx, walletID)
+}
+
+func (m *MockWalletRepo) GetTransactionsByWalletID(ctx context.Context, walletID int64, limit, offset int) ([]Transaction, error) {
+	if m.TransactionsErr != nil {
+		return nil, m.TransactionsErr
+	}
+	if walletID == 1 {
+		return []Transaction{
+			{ID: 1, WalletID: 1, Amount: 50.0, OpType: "deposit"},
+			{ID: 2, WalletID: 1, Amount: -20.0, OpType: "withdraw"},
+		}, nil
+	}
+	return nil, ErrWalletNotFound
+}
+
+func (m *MockWalletRepo) GetBalanceAt(ctx context.Context, walletID int64, at time.Time) (float64, error) {
+	if at.Before(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, nil
 	}
-	return nil, errors.New("wallet not found")
+	return 30.0, nil
+}
+
+// 没有真实的事务，fn 直接使用 mock 本身
+func (m *MockWalletRepo) WithTx(ctx context.Context, fn func(ctx context.Context, uow *UnitOfWork) error) error {
+	return fn(ctx, &UnitOfWork{Wallet: m})
 }
 
 func TestDepositWithdrawHandler(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletRepo{}}
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}}
 	router.PUT("/api/balance/:id", a.depositWithdrawHandler)
 
 	// Test cases
@@ -120,6 +165,50 @@ func TestDepositWithdrawHandler(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   map[string]interface{}{"error": "json: cannot unmarshal string into Go struct field .amount of type float64"},
 		},
+		{
+			name: "Withdraw From Pocket",
+			id:   "1",
+			requestBody: map[string]interface{}{
+				"op_type":   "withdraw",
+				"amount":    20.0,
+				"pocket_id": 1,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "withdraw successful"},
+		},
+		{
+			name: "Not Enough In Pocket",
+			id:   "1",
+			requestBody: map[string]interface{}{
+				"op_type":   "withdraw",
+				"amount":    50.0,
+				"pocket_id": 1,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "not enough"},
+		},
+		{
+			name: "Pocket Not Found",
+			id:   "1",
+			requestBody: map[string]interface{}{
+				"op_type":   "withdraw",
+				"amount":    20.0,
+				"pocket_id": 9,
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "pocket not found"},
+		},
+		{
+			name: "Deposit Into Pocket",
+			id:   "1",
+			requestBody: map[string]interface{}{
+				"op_type":   "deposit",
+				"amount":    20.0,
+				"pocket_id": 1,
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "pocket_id is only supported for withdraw"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -148,41 +237,13 @@ func TestDepositWithdrawHandler(t *testing.T) {
 	}
 }
 
-type MockWalletUpdateErrRepo struct{}
-
-func (m *MockWalletUpdateErrRepo) UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error {
-	if walletID == 1 {
-		return errors.New("update balance failed")
-	}
-	return errors.New("update balance failed")
-}
-
-func (m *MockWalletUpdateErrRepo) ExecTransfer(db *sql.DB, fromWalletID, toWalletID int64, amount float64) error {
-	return nil
-}
-
-func (m *MockWalletUpdateErrRepo) GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error) {
-	if walletID == 1 {
-		return &Wallet{ID: 1, Balance: 100.0}, nil
-	}
-	return nil, errors.New("wallet not found")
-}
-func (m *MockWalletUpdateErrRepo) GetTransactionsByWalletID(db *sql.DB, walletID int64, limit, offset int) ([]Transaction, error) {
-	if walletID == 1 {
-		return []Transaction{
-			{ID: 1, WalletID: 1, Amount: 50.0, OpType: "deposit"},
-			{ID: 2, WalletID: 1, Amount: -20.0, OpType: "withdraw"},
-		}, nil
-	}
-	return nil, errors.New("wallet not found")
-}
-
 func TestDepositWithdrawHandler_Err(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletUpdateErrRepo{}}
+	a := App{Rp: &MockWalletRepo{UpdateErr: errors.New("update balance failed")}, Mb: &MockMemberRepo{}}
 	router.PUT("/api/balance/:id", a.depositWithdrawHandler)
 
 	// Test cases
@@ -231,41 +292,13 @@ func TestDepositWithdrawHandler_Err(t *testing.T) {
 	}
 }
 
-type MockWalletTransferErrRepo struct{}
-
-func (m *MockWalletTransferErrRepo) UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error {
-	if walletID == 1 {
-		return nil
-	}
-	return errors.New("update balance failed")
-}
-
-func (m *MockWalletTransferErrRepo) ExecTransfer(db *sql.DB, fromWalletID, toWalletID int64, amount float64) error {
-	return fmt.Errorf("transfer failed: from %d to %d", fromWalletID, toWalletID)
-}
-
-func (m *MockWalletTransferErrRepo) GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error) {
-	if walletID == 1 {
-		return &Wallet{ID: 1, Balance: 100.0}, nil
-	}
-	return nil, errors.New("wallet not found")
-}
-func (m *MockWalletTransferErrRepo) GetTransactionsByWalletID(db *sql.DB, walletID int64, limit, offset int) ([]Transaction, error) {
-	if walletID == 1 {
-		return []Transaction{
-			{ID: 1, WalletID: 1, Amount: 50.0, OpType: "deposit"},
-			{ID: 2, WalletID: 1, Amount: -20.0, OpType: "withdraw"},
-		}, nil
-	}
-	return nil, errors.New("wallet not found")
-}
-
 func TestTransferHandlerError(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletTransferErrRepo{}}
+	a := App{Rp: &MockWalletRepo{TransferErr: errors.New("transfer failed: from 1 to 2")}, Mb: &MockMemberRepo{}}
 	router.POST("/api/transfer", a.transferHandler)
 
 	// Test cases
@@ -316,9 +349,10 @@ func TestTransferHandlerError(t *testing.T) {
 func TestTransferHandler(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletRepo{}}
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}}
 	router.POST("/api/transfer", a.transferHandler)
 
 	// Test cases
@@ -368,6 +402,16 @@ func TestTransferHandler(t *testing.T) {
 			expectedStatus: http.StatusNotFound,
 			expectedBody:   map[string]interface{}{"error": "from wallet not found"},
 		},
+		{
+			name: "To Wallet Not Found",
+			requestBody: map[string]interface{}{
+				"from_wallet_id": 1,
+				"to_wallet_id":   999,
+				"amount":         50.0,
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "to wallet not found"},
+		},
 		{
 			name: "Not Enough Balance",
 			requestBody: map[string]interface{}{
@@ -378,6 +422,39 @@ func TestTransferHandler(t *testing.T) {
 			expectedStatus: http.StatusOK,
 			expectedBody:   map[string]interface{}{"error": "not enough"},
 		},
+		{
+			name: "Transfer From Pocket",
+			requestBody: map[string]interface{}{
+				"from_wallet_id": 1,
+				"from_pocket_id": 1,
+				"to_wallet_id":   2,
+				"amount":         20.0,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "transfer successful"},
+		},
+		{
+			name: "Not Enough In Pocket",
+			requestBody: map[string]interface{}{
+				"from_wallet_id": 1,
+				"from_pocket_id": 1,
+				"to_wallet_id":   2,
+				"amount":         50.0,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"error": "not enough"},
+		},
+		{
+			name: "From Pocket Not Found",
+			requestBody: map[string]interface{}{
+				"from_wallet_id": 1,
+				"from_pocket_id": 9,
+				"to_wallet_id":   2,
+				"amount":         20.0,
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "pocket not found"},
+		},
 		{
 			name: "invalid amount",
 			requestBody: map[string]interface{}{
@@ -428,9 +505,10 @@ func TestTransferHandler(t *testing.T) {
 func TestGetBalanceHandler(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletRepo{}}
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}}
 	router.GET("/api/balance/:id", a.getBalanceHandler)
 
 	// Test cases
@@ -444,7 +522,13 @@ func TestGetBalanceHandler(t *testing.T) {
 			name:           "Get Balance Success",
 			id:             "1",
 			expectedStatus: http.StatusOK,
-			expectedBody:   map[string]interface{}{"balance": 100.0},
+			expectedBody: map[string]interface{}{
+				"balance":      125.0,
+				"main_balance": 100.0,
+				"pockets": []interface{}{
+					map[string]interface{}{"id": 1.0, "wallet_id": 1.0, "name": "rent", "balance": 25.0},
+				},
+			},
 		},
 		{
 			name:           "Wallet Not Found",
@@ -458,6 +542,48 @@ func TestGetBalanceHandler(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   map[string]interface{}{"error": "strconv.ParseInt: parsing \"invalid\": invalid syntax"},
 		},
+		{
+			name:           "Balance At Time",
+			id:             "1?at=2024-06-01T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"balance":      30.0,
+				"main_balance": 20.0,
+				"pockets":      []interface{}{map[string]interface{}{"id": 1.0, "wallet_id": 1.0, "name": "rent", "balance": 10.0}},
+			},
+		},
+		{
+			name:           "Balance Before First Transaction",
+			id:             "1?at=2023-06-01T00:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"balance":      0.0,
+				"main_balance": 0.0,
+				"pockets":      []interface{}{map[string]interface{}{"id": 1.0, "wallet_id": 1.0, "name": "rent", "balance": 0.0}},
+			},
+		},
+		{
+			name:           "Balance At Time With Offset",
+			id:             "1?at=2024-01-01T07:00:00%2B08:00",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"balance":      0.0,
+				"main_balance": 0.0,
+				"pockets":      []interface{}{map[string]interface{}{"id": 1.0, "wallet_id": 1.0, "name": "rent", "balance": 0.0}},
+			},
+		},
+		{
+			name:           "Invalid At",
+			id:             "1?at=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid at"},
+		},
+		{
+			name:           "Balance At Unknown Wallet",
+			id:             "999?at=2024-06-01T00:00:00Z",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "wallet not found"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -482,41 +608,13 @@ func TestGetBalanceHandler(t *testing.T) {
 	}
 }
 
-type MockWalletGetTransactionErrRepo struct{}
-
-func (m *MockWalletGetTransactionErrRepo) UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error {
-	if walletID == 1 {
-		return errors.New("update balance failed")
-	}
-	return nil
-}
-
-func (m *MockWalletGetTransactionErrRepo) ExecTransfer(db *sql.DB, fromWalletID, toWalletID int64, amount float64) error {
-	return nil
-}
-
-func (m *MockWalletGetTransactionErrRepo) GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error) {
-	if walletID == 1 {
-		return &Wallet{ID: 1, Balance: 100.0}, nil
-	}
-	return nil, errors.New("wallet not found")
-}
-func (m *MockWalletGetTransactionErrRepo) GetTransactionsByWalletID(db *sql.DB, walletID int64, limit, offset int) ([]Transaction, error) {
-	if walletID == 1 {
-		return []Transaction{
-			{ID: 1, WalletID: 1, Amount: 50.0, OpType: "deposit"},
-			{ID: 2, WalletID: 1, Amount: -20.0, OpType: "withdraw"},
-		}, errors.New("db error")
-	}
-	return nil, errors.New("db error")
-}
-
 func TestGetTransactionsHandler_Error(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletGetTransactionErrRepo{}}
+	a := App{Rp: &MockWalletRepo{TransactionsErr: errors.New("db error")}, Mb: &MockMemberRepo{}}
 	router.GET("/api/transaction/:id", a.getTransactions)
 
 	// Test cases
@@ -561,41 +659,13 @@ func TestGetTransactionsHandler_Error(t *testing.T) {
 	}
 }
 
-type MockWalletGetTransactionWalletErrRepo struct{}
-
-func (m *MockWalletGetTransactionWalletErrRepo) UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error {
-	if walletID == 1 {
-		return errors.New("update balance failed")
-	}
-	return nil
-}
-
-func (m *MockWalletGetTransactionWalletErrRepo) ExecTransfer(db *sql.DB, fromWalletID, toWalletID int64, amount float64) error {
-	return nil
-}
-
-func (m *MockWalletGetTransactionWalletErrRepo) GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error) {
-	if walletID == 1 {
-		return &Wallet{ID: 1, Balance: 100.0}, errors.New("db error")
-	}
-	return nil, errors.New("db error")
-}
-func (m *MockWalletGetTransactionWalletErrRepo) GetTransactionsByWalletID(db *sql.DB, walletID int64, limit, offset int) ([]Transaction, error) {
-	if walletID == 1 {
-		return []Transaction{
-			{ID: 1, WalletID: 1, Amount: 50.0, OpType: "deposit"},
-			{ID: 2, WalletID: 1, Amount: -20.0, OpType: "withdraw"},
-		}, errors.New("db error")
-	}
-	return nil, errors.New("db error")
-}
-
 func TestGetTransactionsHandler_WalletError(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletGetTransactionWalletErrRepo{}}
+	a := App{Rp: &MockWalletRepo{WalletErr: errors.New("db error")}, Mb: &MockMemberRepo{}}
 	router.GET("/api/transaction/:id", a.getTransactions)
 
 	// Test cases
@@ -640,22 +710,13 @@ func TestGetTransactionsHandler_WalletError(t *testing.T) {
 	}
 }
 
-func (m *MockWalletRepo) GetTransactionsByWalletID(db *sql.DB, walletID int64, limit, offset int) ([]Transaction, error) {
-	if walletID == 1 {
-		return []Transaction{
-			{ID: 1, WalletID: 1, Amount: 50.0, OpType: "deposit"},
-			{ID: 2, WalletID: 1, Amount: -20.0, OpType: "withdraw"},
-		}, nil
-	}
-	return nil, errors.New("wallet not found")
-}
-
 func TestGetTransactionsHandler(t *testing.T) {
 	// Create a new Gin router
 	router := gin.Default()
+	router.Use(asCaller("user1"))
 
 	// Initialize the app and set up the route
-	a := App{Rp: &MockWalletRepo{}}
+	a := App{Rp: &MockWalletRepo{}, Mb: &MockMemberRepo{}}
 	router.GET("/api/transaction/:id", a.getTransactions)
 
 	// Test cases
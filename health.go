@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// 就绪检查中数据库 ping 的默认超时
+const defaultReadyTimeout = 2 * time.Second
+
+// 建表语句中的全部表，就绪检查确认它们都已创建
+var schemaTables = func() []string {
+	var tables []string
+	for _, m := range regexp.MustCompile(`CREATE TABLE IF NOT EXISTS (\w+)`).FindAllStringSubmatch(tableCreationQuery, -1) {
+		tables = append(tables, m[1])
+	}
+	return tables
+}()
+
+// 停机排空开始后就绪检查返回失败，负载均衡不再分配新请求
+func (a *App) startDraining() {
+	a.draining.Store(true)
+}
+
+// 排空等待结束后拒绝新请求，进行中的请求继续完成
+func (a *App) startClosing() {
+	a.closing.Store(true)
+}
+
+// 进程存活即返回 200，不检查依赖
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// 检查是否可以接收请求：未在排空、数据库可连接、表已创建、连接池未用尽
+func (a *App) readyzHandler(c *gin.Context) {
+	if a.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	checks := gin.H{"database": "ok", "migrations": "ok", "pool": "ok"}
+	ready := true
+	fail := func(name string, err error) {
+		checks[name] = err.Error()
+		ready = false
+	}
+
+	if a.DB == nil {
+		fail("database", fmt.Errorf("not configured"))
+		checks["migrations"], checks["pool"] = "skipped", "skipped"
+	} else {
+		timeout := a.ReadyTimeout
+		if timeout <= 0 {
+			timeout = defaultReadyTimeout
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		if err := a.DB.PingContext(ctx); err != nil {
+			fail("database", err)
+			checks["migrations"] = "skipped"
+		} else if err := a.checkSchema(ctx); err != nil {
+			fail("migrations", err)
+		}
+
+		// 全部连接都在使用时新请求只能排队等待
+		if stats := a.DB.Stats(); stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections {
+			fail("pool", fmt.Errorf("all %d connections in use", stats.MaxOpenConnections))
+		}
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
+
+// 确认建表语句中的表都已存在
+func (a *App) checkSchema(ctx context.Context) error {
+	rows, err := a.DB.QueryContext(ctx, "SELECT t FROM unnest($1::text[]) AS t WHERE to_regclass(t) IS NULL", pq.Array(schemaTables))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		missing = append(missing, table)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
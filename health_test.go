@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaTables(t *testing.T) {
+	assert.Contains(t, schemaTables, "wallet")
+	assert.Contains(t, schemaTables, "outbox_events")
+	assert.NotContains(t, schemaTables, "transactions_notify")
+}
+
+func TestReadyzHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		setup          func(mock sqlmock.Sqlmock)
+		draining       bool
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name: "Ready",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+				mock.ExpectQuery("SELECT t FROM unnest").WillReturnRows(sqlmock.NewRows([]string{"t"}))
+			},
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"database": "ok", "migrations": "ok", "pool": "ok"},
+		},
+		{
+			name: "Database down",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"database": "connection refused", "migrations": "skipped", "pool": "ok"},
+		},
+		{
+			name: "Missing tables",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+				mock.ExpectQuery("SELECT t FROM unnest").WillReturnRows(sqlmock.NewRows([]string{"t"}).AddRow("webhooks").AddRow("outbox_events"))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"database": "ok", "migrations": "missing tables: webhooks, outbox_events", "pool": "ok"},
+		},
+		{
+			name:           "Draining",
+			setup:          func(mock sqlmock.Sqlmock) {},
+			draining:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			require.NoError(t, err)
+			defer db.Close()
+			tt.setup(mock)
+
+			// Create a new Gin router
+			router := gin.Default()
+
+			// Initialize the app and set up the route
+			a := &App{DB: db}
+			if tt.draining {
+				a.startDraining()
+			}
+			router.GET("/readyz", a.readyzHandler)
+			router.GET("/healthz", healthzHandler)
+
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("GET", "/readyz", nil)
+			// Create a new HTTP response recorder
+			w := httptest.NewRecorder()
+			// Perform the HTTP request
+			router.ServeHTTP(w, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			var body struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Equal(t, tt.expectedChecks, body.Checks)
+			if tt.draining {
+				assert.Equal(t, "draining", body.Status)
+			}
+
+			// 存活检查不受依赖影响
+			w = httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+// 连接池用尽时不再就绪
+func TestReadyzHandler_PoolExhausted(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	mock.ExpectBegin()
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer func() { _ = tx.Rollback() }()
+
+	router := gin.Default()
+	a := &App{DB: db, ReadyTimeout: 50 * time.Millisecond}
+	router.GET("/readyz", a.readyzHandler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), `"pool":"all 1 connections in use"`)
+}
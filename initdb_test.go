@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// sql.sql 与程序启动时建表的语句保持一致，只少了开发用的初始数据
+func TestSQLFileMatchesTableCreationQuery(t *testing.T) {
+	schema, err := os.ReadFile("sql.sql")
+	if err != nil {
+		t.Fatalf("failed to read sql.sql: %s", err)
+	}
+	if !strings.HasPrefix(tableCreationQuery, strings.TrimSpace(string(schema))) {
+		t.Errorf("sql.sql is out of sync with tableCreationQuery in initdb.go")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// 每天 UTC 零点为前一天生成快照，与交易时间一样按 UTC 划分日期，ctx 取消时退出
+func (a *App) runSnapshotJob(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		day := next.AddDate(0, 0, -1)
+		if err := a.Ss.CreateDailySnapshot(context.Background(), a.DB, day); err != nil {
+			log.Printf("failed to create daily snapshot for %s: %v", day.Format(dateLayout), err)
+		}
+	}
+}
+
+// 定期将过期的待审批转账标记为 expired，ctx 取消时退出
+func (a *App) runApprovalExpiryJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := a.Ap.ExpireTransferRequests(context.Background(), a.DB, time.Now()); err != nil {
+			log.Printf("failed to expire transfer requests: %v", err)
+		}
+	}
+}
+
+// 定期投递到期的 webhook 事件，ctx 取消时退出
+func (a *App) runWebhookJob(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := a.deliverWebhooks(context.Background(), time.Now()); err != nil {
+			log.Printf("failed to deliver webhooks: %v", err)
+		}
+	}
+}
+
+// 定期发布 outbox 中的事件，ctx 取消时退出
+func (a *App) runOutboxRelay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := a.Ob.RelayOutbox(context.Background(), a.DB, outboxBatchSize, a.Publisher.Publish); err != nil {
+			log.Printf("failed to relay outbox events: %v", err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// 接受客户端传入的请求 ID 的格式，不符合时重新生成
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// 输出 JSON 格式日志
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// 解析 LOG_LEVEL，空值为 info
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 一个请求的日志字段，钱包 ID 和交易类型在处理过程中补充
+type requestLog struct {
+	id    string
+	start time.Time
+
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// 设置字段，同名字段覆盖
+func (r *requestLog) set(attrs ...slog.Attr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, attr := range attrs {
+		replaced := false
+		for i := range r.attrs {
+			if r.attrs[i].Key == attr.Key {
+				r.attrs[i], replaced = attr, true
+			}
+		}
+		if !replaced {
+			r.attrs = append(r.attrs, attr)
+		}
+	}
+}
+
+// 每条日志附加请求 ID、已设置的字段和到目前为止的耗时
+type requestLogHandler struct {
+	slog.Handler
+	req *requestLog
+}
+
+func (h requestLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	record = record.Clone()
+	record.AddAttrs(slog.String("request_id", h.req.id))
+	h.req.mu.Lock()
+	record.AddAttrs(h.req.attrs...)
+	h.req.mu.Unlock()
+	record.AddAttrs(slog.Float64("latency_ms", float64(time.Since(h.req.start).Microseconds())/1000))
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestLogHandler{h.Handler.WithAttrs(attrs), h.req}
+}
+
+func (h requestLogHandler) WithGroup(name string) slog.Handler {
+	return requestLogHandler{h.Handler.WithGroup(name), h.req}
+}
+
+type requestLogContextKey struct{}
+type loggerContextKey struct{}
+
+// 创建请求的 logger 并放入 ctx
+func withRequestLogger(ctx context.Context, base *slog.Logger, id string) (context.Context, *slog.Logger) {
+	if base == nil {
+		base = slog.Default()
+	}
+	req := &requestLog{id: id, start: time.Now()}
+	logger := slog.New(requestLogHandler{base.Handler(), req})
+	ctx = context.WithValue(ctx, requestLogContextKey{}, req)
+	return context.WithValue(ctx, loggerContextKey{}, logger), logger
+}
+
+// 当前请求的 logger，不在请求中时为默认 logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// 为当前请求之后的日志补充字段，如 wallet_id、op_type
+func setLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if req, ok := ctx.Value(requestLogContextKey{}).(*requestLog); ok {
+		req.set(attrs...)
+	}
+}
+
+// 生成或沿用请求 ID，请求结束时按状态码级别记录访问日志
+func (a *App) requestLogger(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !requestIDPattern.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Header(requestIDHeader, id)
+	ctx, logger := withRequestLogger(c.Request.Context(), a.Log, id)
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	statusCode := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case statusCode >= 500:
+		level = slog.LevelError
+	case statusCode >= 400:
+		level = slog.LevelWarn
+	case c.FullPath() == "/healthz" || c.FullPath() == "/readyz":
+		// 探针请求很频繁，成功时只在 debug 级别记录
+		level = slog.LevelDebug
+	}
+	attrs := []any{
+		"method", c.Request.Method,
+		"route", c.FullPath(),
+		"path", c.Request.URL.Path,
+		"status", statusCode,
+		"client_ip", c.ClientIP(),
+		"bytes", c.Writer.Size(),
+	}
+	if len(c.Errors) > 0 {
+		attrs = append(attrs, "errors", c.Errors.String())
+	}
+	logger.Log(ctx, level, "request", attrs...)
+}
+
+// 记录 panic 及调用栈后返回 500
+func recoverRequest(c *gin.Context, recovered any) {
+	loggerFrom(c.Request.Context()).Error("panic", "error", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+// gRPC 调用使用 metadata 中的 x-request-id，通过响应头返回，结束时记录日志
+func (a *App) grpcLogInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := firstMetadata(md, strings.ToLower(requestIDHeader))
+	if !requestIDPattern.MatchString(id) {
+		id = newRequestID()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), id))
+	ctx, logger := withRequestLogger(ctx, a.Log, id)
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	attrs := []any{"method", info.FullMethod, "code", code.String()}
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+		level = slog.LevelWarn
+		if code == codes.Internal {
+			level = slog.LevelError
+		}
+	}
+	logger.Log(ctx, level, "grpc request", attrs...)
+	return resp, err
+}
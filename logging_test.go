@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 按行解析 JSON 日志
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	a := App{Log: newLogger(&buf, slog.LevelInfo)}
+
+	// Create a new Gin router
+	router := gin.New()
+	router.Use(a.requestLogger)
+	router.PUT("/api/balance/:id", func(c *gin.Context) {
+		setLogAttrs(c.Request.Context(), slog.Int64("wallet_id", 1), slog.String("op_type", "deposit"))
+		writeError(c, errors.New("connection refused"))
+	})
+
+	tests := []struct {
+		name      string
+		requestID string
+		expected  string
+	}{
+		{"Incoming request id", "req-123", "req-123"},
+		{"Invalid request id", "bad id\n", ""},
+		{"Missing request id", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("PUT", "/api/balance/1", nil)
+			if tt.requestID != "" {
+				req.Header.Set(requestIDHeader, tt.requestID)
+			}
+			// Create a new HTTP response recorder
+			w := httptest.NewRecorder()
+			// Perform the HTTP request
+			router.ServeHTTP(w, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, http.StatusInternalServerError, w.Code)
+			id := w.Header().Get(requestIDHeader)
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+
+			// 处理中的错误日志和访问日志都带有请求的字段
+			lines := logLines(t, &buf)
+			require.Len(t, lines, 2)
+			assert.Equal(t, "request failed", lines[0]["msg"])
+			assert.Equal(t, "connection refused", lines[0]["error"])
+			assert.Equal(t, "request", lines[1]["msg"])
+			assert.Equal(t, "ERROR", lines[1]["level"])
+			assert.Equal(t, "/api/balance/:id", lines[1]["route"])
+			assert.Equal(t, float64(500), lines[1]["status"])
+			for _, line := range lines {
+				assert.Equal(t, id, line["request_id"])
+				assert.Equal(t, float64(1), line["wallet_id"])
+				assert.Equal(t, "deposit", line["op_type"])
+				assert.Contains(t, line, "latency_ms")
+			}
+		})
+	}
+}
+
+// 回滚失败时 DAO 的日志带有请求 ID
+func TestUpdateBalance_RollbackLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").
+		WithArgs(10.0, 1).
+		WillReturnError(errors.New("update failed"))
+	mock.ExpectRollback().WillReturnError(errors.New("connection reset"))
+
+	var buf bytes.Buffer
+	req := httptest.NewRequest("PUT", "/api/balance/1", nil)
+	ctx, _ := withRequestLogger(req.Context(), newLogger(&buf, slog.LevelInfo), "req-1")
+	a := App{Rp: &WalletAccess{DB: db}}
+	if err := a.Rp.UpdateBalance(ctx, 1, "deposit", 10); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	lines := logLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "failed to rollback transaction", lines[0]["msg"])
+	assert.Equal(t, "req-1", lines[0]["request_id"])
+	assert.Equal(t, float64(1), lines[0]["wallet_id"])
+	assert.Equal(t, "connection reset", lines[0]["error"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 其他 DAO 回滚失败的日志同样带有请求 ID
+func TestUpdateTransferRequestStatus_RollbackLog(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE transfer_requests").
+		WillReturnError(errors.New("update failed"))
+	mock.ExpectRollback().WillReturnError(errors.New("connection reset"))
+
+	var buf bytes.Buffer
+	req := httptest.NewRequest("POST", "/api/transfers/1/approve", nil)
+	ctx, _ := withRequestLogger(req.Context(), newLogger(&buf, slog.LevelInfo), "req-2")
+	aa := &ApprovalAccess{}
+	if err := aa.UpdateTransferRequestStatus(ctx, db, 1, TransferPending, TransferRejected, "user1", ""); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	lines := logLines(t, &buf)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "failed to rollback transaction", lines[0]["msg"])
+	assert.Equal(t, "req-2", lines[0]["request_id"])
+	assert.Equal(t, "connection reset", lines[0]["error"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
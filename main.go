@@ -1,31 +1,298 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
+	"flag"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 type App struct {
 	DB *sql.DB
 	Rp IWallet
+	Ss ISnapshot
+	Pk IPocket
+	Us IUser
+	Mb IMember
+	Ap IApproval
+	Ak IAPIKey
+	Wh IWebhook
+	Ob IOutbox
+
+	// JSON 格式的结构化日志，请求中的日志另外带有请求 ID 等字段
+	Log *slog.Logger
+
+	// outbox 中的事件发布到这里
+	Publisher Publisher
+	// 向余额推送的连接分发数据库通知
+	Balances *BalanceHub
+
+	// 验证调用者的 bearer token
+	Tokens *TokenVerifier
+	// 校验合作方的请求签名，未配置时不接受签名请求
+	Signatures *SignatureVerifier
+	// 限流后端和各路由的限流配置
+	Limiter    RateLimiter
+	RateLimits map[string]RouteRateLimit
+
+	// 超过该金额的转账需要审批，0 表示不需要
+	ApprovalThreshold float64
+	// 待审批转账的有效期
+	ApprovalTTL time.Duration
+	// 各路由的超时，为空时使用默认值
+	RouteTimeouts map[string]time.Duration
+
+	// 就绪检查中数据库 ping 的超时
+	ReadyTimeout time.Duration
+	// 停机排空中，就绪检查返回失败
+	draining atomic.Bool
+	// 排空等待结束，开始拒绝新请求
+	closing atomic.Bool
 }
 
 func main() {
-	a := App{}
-	a.initDB(os.Getenv("DB_USERNAME"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"), os.Getenv("DB_HOST"))
-
-	r := gin.Default()
-	r.PUT("/api/balance/:id", a.depositWithdrawHandler) //deposit and withdraw
-	r.GET("/api/balance/:id", a.getBalanceHandler)
-	r.POST("/api/transfer", a.transferHandler)
-	r.GET("/api/transaction/:id", a.getTransactions)
-
-	err := r.Run()
+	// config 子命令只输出配置，不启动服务
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:], os.Stdout, os.LookupEnv); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	cfg, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+
+	a := App{
+		ApprovalThreshold: cfg.Approval.Threshold,
+		ApprovalTTL:       cfg.Approval.TTL,
+		ReadyTimeout:      cfg.HTTP.ReadyTimeout,
+	}
+	level, _ := parseLogLevel(cfg.Log.Level)
+	// 其余使用标准库 log 的日志也以 JSON 输出
+	a.Log = newLogger(os.Stdout, level)
+	slog.SetDefault(a.Log)
+	shutdownTracing, err := setupTracing(cfg.Tracing.Exporter, cfg.Tracing.File, cfg.Tracing.ServiceName)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Failed to set up tracing: ", err)
+	}
+	tokens, err := LoadTokenVerifier(cfg.Auth.JWKSFile, cfg.Auth.Issuer, cfg.Auth.Audience)
+	if err != nil {
+		log.Fatal("Failed to load auth.jwks_file:", err)
+	}
+	a.Tokens = tokens
+	a.initDB(cfg.DB)
+	prometheus.MustRegister(collectors.NewDBStatsCollector(a.DB, cfg.DB.Name))
+
+	if cfg.Auth.HMACClientsFile != "" {
+		var nonces NonceStore = NewMemoryNonceStore()
+		if cfg.Auth.HMACNonceBackend == "postgres" {
+			nonces = &PostgresNonceStore{DB: a.DB}
+		}
+		if a.Signatures, err = LoadSignatureVerifier(cfg.Auth.HMACClientsFile, cfg.Auth.HMACMaxSkew, nonces); err != nil {
+			log.Fatal("Failed to load auth.hmac_clients_file:", err)
+		}
+	}
+
+	a.RateLimits = defaultRateLimits
+	if cfg.RateLimit.Routes != "" {
+		if a.RateLimits, err = parseRateLimits(cfg.RateLimit.Routes); err != nil {
+			log.Fatal("Invalid rate_limit.routes:", err)
+		}
+	}
+	if cfg.HTTP.RouteTimeouts != "" {
+		if a.RouteTimeouts, err = parseRouteTimeouts(cfg.HTTP.RouteTimeouts); err != nil {
+			log.Fatal("Invalid http.route_timeouts:", err)
+		}
+	}
+	if cfg.RateLimit.Backend == "postgres" {
+		a.Limiter = &PostgresRateLimiter{DB: a.DB}
+	} else {
+		a.Limiter = NewMemoryRateLimiter()
+	}
+
+	// 事件可以转为 webhook 投递，另外可以输出到标准输出或文件
+	var publishers MultiPublisher
+	if cfg.Features.Webhooks {
+		publishers = append(publishers, &WebhookPublisher{DB: a.DB, Webhooks: a.Wh})
+	}
+	switch cfg.Outbox.Publisher {
+	case "stdout":
+		publishers = append(publishers, NewWriterPublisher(os.Stdout))
+	case "file":
+		p, err := NewFilePublisher(cfg.Outbox.File)
+		if err != nil {
+			log.Fatal("Failed to open outbox.file:", err)
+		}
+		publishers = append(publishers, p)
 	}
+	a.Publisher = publishers
+
+	// 余额推送监听数据库通知，连接断开后自动重连
+	listener := pq.NewListener(cfg.DB.DSN(), time.Second, time.Minute,
+		func(event pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("wallet event listener: %v", err)
+			}
+		})
+	if err := listener.Listen(walletEventsChannel); err != nil {
+		log.Fatal("Failed to listen for wallet events:", err)
+	}
+	a.Balances = NewBalanceHub(a.loadBalance)
+
+	r := a.setupRouter()
+
+	// 证书文件修改后自动重新加载，不需要重启
+	var certs *certReloader
+	var tlsConfig *tls.Config
+	if cfg.TLS.CertFile != "" {
+		if certs, err = newCertReloader(cfg.TLS); err != nil {
+			log.Fatal("Failed to load TLS certificate:", err)
+		}
+		tlsConfig = certs.TLSConfig(cfg.TLS.ClientAuth)
+	}
+
+	// gRPC 接口使用单独的端口，与 HTTP 使用相同的证书
+	var grpcServer *grpc.Server
+	if cfg.Features.GRPC {
+		lis, err := net.Listen("tcp", cfg.GRPC.Addr)
+		if err != nil {
+			log.Fatal("Failed to listen on grpc.addr:", err)
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = a.newGRPCServer(opts...)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// 停机时按这里的顺序停止
+	workers := &workerGroup{}
+	if cfg.Features.Snapshots {
+		workers.Go("snapshot", a.runSnapshotJob)
+	}
+	workers.Go("approval expiry", func(ctx context.Context) { a.runApprovalExpiryJob(ctx, time.Minute) })
+	workers.Go("outbox relay", func(ctx context.Context) { a.runOutboxRelay(ctx, time.Second) })
+	if cfg.Features.Webhooks {
+		workers.Go("webhook delivery", func(ctx context.Context) { a.runWebhookJob(ctx, 5*time.Second) })
+	}
+	workers.Go("balance stream", func(ctx context.Context) { a.Balances.Run(ctx, listener.Notify) })
+	if certs != nil {
+		workers.Go("tls reload", func(ctx context.Context) { certs.Run(ctx, cfg.TLS.ReloadInterval) })
+	}
+
+	// 不设置 WriteTimeout，推送连接会一直保持
+	srv := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+		TLSConfig:         tlsConfig,
+	}
+	// 推送连接不会自己结束，停机时主动断开
+	srv.RegisterOnShutdown(a.Balances.Close)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			// 证书由 TLSConfig 提供
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	a.shutdown(shutdownCtx, cfg.HTTP.DrainDelay, srv, grpcServer, workers)
+
+	if err := listener.Close(); err != nil {
+		log.Printf("failed to close wallet event listener: %v", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("failed to flush traces: %v", err)
+	}
+	// 所有请求和后台任务结束后最后关闭数据库
+	if err := a.DB.Close(); err != nil {
+		log.Printf("failed to close database: %v", err)
+	}
+	slog.Info("shutdown complete")
+}
+
+// 注册全部路由
+func (a *App) setupRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(a.requestLogger, traceRequest, recordHTTPMetrics, gin.CustomRecoveryWithWriter(io.Discard, recoverRequest), a.rejectWhileClosing, a.authMiddleware, validateRequest)
+	r.GET("/api/openapi.json", openAPIHandler)
+	r.GET("/metrics", a.requireStaff, gin.WrapH(promhttp.Handler()))
+	r.GET("/healthz", healthzHandler)
+	r.GET("/readyz", a.readyzHandler)
+	r.PUT("/api/balance/:id", a.deadline("deposit_withdraw"), a.signatureMiddleware, a.rateLimit("deposit_withdraw", walletFromParam), a.depositWithdrawHandler) //deposit and withdraw
+	r.GET("/api/balance/:id", a.deadline("balance"), a.getBalanceHandler)
+	r.GET("/api/balance/:id/stream", a.balanceStreamHandler)
+	r.GET("/api/balance/:id/ws", a.balanceWebSocketHandler)
+	r.POST("/api/transfer", a.deadline("transfer"), a.signatureMiddleware, a.rateLimit("transfer", walletFromTransferBody), a.transferHandler)
+	r.GET("/api/transfers/pending", a.getPendingTransfersHandler)
+	r.GET("/api/transfers/:id", a.getTransferRequestHandler)
+	r.POST("/api/transfers/:id/approve", a.deadline("approve"), a.approveTransferHandler)
+	r.POST("/api/transfers/:id/reject", a.rejectTransferHandler)
+	r.GET("/api/transaction/:id", a.deadline("transactions"), a.getTransactions)
+	r.POST("/api/users", a.createUserHandler)
+	r.GET("/api/users/:id", a.getUserHandler)
+	r.GET("/api/users/:id/wallets", a.getUserWalletsHandler)
+	r.POST("/api/users/:id/wallets", a.createUserWalletHandler)
+	r.GET("/api/users/:id/balance", a.getUserBalanceHandler)
+	r.POST("/api/wallet/:id/pockets", a.createPocketHandler)
+	r.GET("/api/wallet/:id/pockets", a.getPocketsHandler)
+	r.POST("/api/wallet/:id/pockets/move", a.movePocketFundsHandler)
+	r.GET("/api/wallet/:id/members", a.getMembersHandler)
+	r.PUT("/api/wallet/:id/members/:user_id", a.setMemberHandler)
+	r.DELETE("/api/wallet/:id/members/:user_id", a.removeMemberHandler)
+
+	// 后台接口只对内部员工开放，每个接口再按角色权限矩阵检查
+	admin := r.Group("/api/admin", a.requireStaff)
+	admin.GET("/trial-balance", a.requirePermission(PermViewReports), a.getTrialBalanceHandler)
+	admin.POST("/snapshots", a.requirePermission(PermRunSnapshots), a.createSnapshotHandler)
+	admin.POST("/api-keys", a.requirePermission(PermManageAPIKeys), a.createAPIKeyHandler)
+	admin.GET("/api-keys", a.requirePermission(PermViewAPIKeys), a.getAPIKeysHandler)
+	admin.DELETE("/api-keys/:id", a.requirePermission(PermManageAPIKeys), a.revokeAPIKeyHandler)
+	admin.POST("/api-keys/:id/rotate", a.requirePermission(PermManageAPIKeys), a.rotateAPIKeyHandler)
+	admin.POST("/webhooks", a.requirePermission(PermManageWebhooks), a.createWebhookHandler)
+	admin.GET("/webhooks", a.requirePermission(PermViewWebhooks), a.getWebhooksHandler)
+	admin.DELETE("/webhooks/:id", a.requirePermission(PermManageWebhooks), a.deleteWebhookHandler)
+	admin.GET("/webhooks/:id/deliveries", a.requirePermission(PermViewWebhooks), a.getWebhookDeliveriesHandler)
+	admin.POST("/webhook-deliveries/:id/retry", a.requirePermission(PermManageWebhooks), a.retryWebhookDeliveryHandler)
+	return r
 }
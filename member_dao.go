@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var ErrMemberNotFound = errors.New("member not found")
+
+type MemberAccess struct{}
+
+// 获取用户在钱包中的角色
+func (ma *MemberAccess) GetMemberRole(ctx context.Context, db *sql.DB, walletID int64, userID string) (string, error) {
+	var role string
+	err := db.QueryRowContext(ctx, "SELECT role FROM wallet_members WHERE wallet_id = $1 AND user_id = $2", walletID, userID).
+		Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrMemberNotFound
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// 获取钱包的全部成员
+func (ma *MemberAccess) GetMembers(ctx context.Context, db *sql.DB, walletID int64) ([]WalletMember, error) {
+	rows, err := db.QueryContext(ctx, "SELECT wallet_id, user_id, role FROM wallet_members WHERE wallet_id = $1 ORDER BY user_id", walletID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []WalletMember
+	for rows.Next() {
+		var member WalletMember
+		if err := rows.Scan(&member.WalletID, &member.UserID, &member.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// 添加成员或修改成员角色
+func (ma *MemberAccess) SetMemberRole(ctx context.Context, db *sql.DB, walletID int64, userID, role string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO wallet_members (wallet_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (wallet_id, user_id) DO UPDATE SET role = EXCLUDED.role
+	`, walletID, userID, role)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// 移除钱包成员
+func (ma *MemberAccess) RemoveMember(ctx context.Context, db *sql.DB, walletID int64, userID string) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM wallet_members WHERE wallet_id = $1 AND user_id = $2", walletID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
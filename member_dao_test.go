@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestGetMemberRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT role FROM wallet_members WHERE wallet_id = \\$1 AND user_id = \\$2").
+		WithArgs(int64(1), "user2").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("spender"))
+
+	ma := &MemberAccess{}
+	role, err := ma.GetMemberRole(context.Background(), db, 1, "user2")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if role != "spender" {
+		t.Errorf("expected role spender, got %s", role)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetMemberRole_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT role FROM wallet_members").
+		WithArgs(int64(1), "stranger").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	ma := &MemberAccess{}
+	if _, err := ma.GetMemberRole(context.Background(), db, 1, "stranger"); err != ErrMemberNotFound {
+		t.Errorf("expected ErrMemberNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSetMemberRole_UnknownUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("INSERT INTO wallet_members \\(wallet_id, user_id, role\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT").
+		WithArgs(int64(1), "nobody", "viewer").
+		WillReturnError(&pq.Error{Code: "23503"})
+
+	ma := &MemberAccess{}
+	if err := ma.SetMemberRole(context.Background(), db, 1, "nobody", "viewer"); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRemoveMember_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM wallet_members WHERE wallet_id = \\$1 AND user_id = \\$2").
+		WithArgs(int64(1), "user2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ma := &MemberAccess{}
+	if err := ma.RemoveMember(context.Background(), db, 1, "user2"); err != ErrMemberNotFound {
+		t.Errorf("expected ErrMemberNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MemberRequest struct {
+	Id     int64  `uri:"id" binding:"required"`
+	UserId string `uri:"user_id" binding:"required"`
+}
+
+// 查询钱包成员
+func (a *App) getMembersHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 获取钱包信息
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), req.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		return
+	}
+	if !a.authorizeWallet(c, wallet, PermReadBalance) {
+		return
+	}
+
+	members, err := a.Mb.GetMembers(c.Request.Context(), a.DB, wallet.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if members == nil {
+		members = []WalletMember{}
+	}
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// 添加成员或修改成员角色
+func (a *App) setMemberHandler(c *gin.Context) {
+	var req MemberRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var request struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := rolePermissions[request.Role]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid role"})
+		return
+	}
+
+	// 获取钱包信息
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), req.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		return
+	}
+	if !a.authorizeWallet(c, wallet, PermManageMembers) {
+		return
+	}
+	if req.UserId == wallet.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot change the wallet creator's role"})
+		return
+	}
+
+	if err := a.Mb.SetMemberRole(c.Request.Context(), a.DB, wallet.ID, req.UserId, request.Role); err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"member": WalletMember{WalletID: wallet.ID, UserID: req.UserId, Role: request.Role}})
+}
+
+// 移除钱包成员
+func (a *App) removeMemberHandler(c *gin.Context) {
+	var req MemberRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 获取钱包信息
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), req.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		return
+	}
+	if !a.authorizeWallet(c, wallet, PermManageMembers) {
+		return
+	}
+	if req.UserId == wallet.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot change the wallet creator's role"})
+		return
+	}
+
+	if err := a.Mb.RemoveMember(c.Request.Context(), a.DB, wallet.ID, req.UserId); err != nil {
+		if err == ErrMemberNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "member removed"})
+}
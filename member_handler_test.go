@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// user1 是所有钱包的 owner，spender1 和 viewer1 分别持有对应角色
+type MockMemberRepo struct{}
+
+func (m *MockMemberRepo) GetMemberRole(ctx context.Context, db *sql.DB, walletID int64, userID string) (string, error) {
+	switch userID {
+	case "user1":
+		return "owner", nil
+	case "spender1":
+		return "spender", nil
+	case "viewer1":
+		return "viewer", nil
+	}
+	return "", ErrMemberNotFound
+}
+
+func (m *MockMemberRepo) GetMembers(ctx context.Context, db *sql.DB, walletID int64) ([]WalletMember, error) {
+	return []WalletMember{
+		{WalletID: walletID, UserID: "user1", Role: "owner"},
+		{WalletID: walletID, UserID: "viewer1", Role: "viewer"},
+	}, nil
+}
+
+func (m *MockMemberRepo) SetMemberRole(ctx context.Context, db *sql.DB, walletID int64, userID, role string) error {
+	if userID == "nobody" {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (m *MockMemberRepo) RemoveMember(ctx context.Context, db *sql.DB, walletID int64, userID string) error {
+	if userID == "viewer1" {
+		return nil
+	}
+	return ErrMemberNotFound
+}
+
+func TestMemberHandlers(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Mb: &MockMemberRepo{}, Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.GET("/api/wallet/:id/members", a.getMembersHandler)
+	router.PUT("/api/wallet/:id/members/:user_id", a.setMemberHandler)
+	router.DELETE("/api/wallet/:id/members/:user_id", a.removeMemberHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		caller         string
+		method         string
+		path           string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "List Members",
+			caller:         "viewer1",
+			method:         "GET",
+			path:           "/api/wallet/1/members",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"members": []interface{}{
+					map[string]interface{}{"wallet_id": 1.0, "user_id": "user1", "role": "owner"},
+					map[string]interface{}{"wallet_id": 1.0, "user_id": "viewer1", "role": "viewer"},
+				},
+			},
+		},
+		{
+			name:           "Add Spender",
+			caller:         "user1",
+			method:         "PUT",
+			path:           "/api/wallet/1/members/user2",
+			requestBody:    map[string]interface{}{"role": "spender"},
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"member": map[string]interface{}{"wallet_id": 1.0, "user_id": "user2", "role": "spender"},
+			},
+		},
+		{
+			name:           "Spender Cannot Manage Members",
+			caller:         "spender1",
+			method:         "PUT",
+			path:           "/api/wallet/1/members/user2",
+			requestBody:    map[string]interface{}{"role": "owner"},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]interface{}{"error": "forbidden"},
+		},
+		{
+			name:           "Invalid Role",
+			caller:         "user1",
+			method:         "PUT",
+			path:           "/api/wallet/1/members/user2",
+			requestBody:    map[string]interface{}{"role": "admin"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid role"},
+		},
+		{
+			name:           "Unknown User",
+			caller:         "user1",
+			method:         "PUT",
+			path:           "/api/wallet/1/members/nobody",
+			requestBody:    map[string]interface{}{"role": "viewer"},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "user not found"},
+		},
+		{
+			name:           "Remove Member",
+			caller:         "user1",
+			method:         "DELETE",
+			path:           "/api/wallet/1/members/viewer1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "member removed"},
+		},
+		{
+			name:           "Remove Missing Member",
+			caller:         "user1",
+			method:         "DELETE",
+			path:           "/api/wallet/1/members/user2",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "member not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			var body *bytes.Buffer
+			if tt.requestBody != nil {
+				jsonBody, _ := json.Marshal(tt.requestBody)
+				body = bytes.NewBuffer(jsonBody)
+			} else {
+				body = bytes.NewBuffer(nil)
+			}
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest(tt.method, tt.path, body)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", bearer(tt.caller))
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
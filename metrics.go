@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route and status code.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	walletDAODuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wallet_dao_duration_seconds",
+		Help:    "Latency of IWallet operations.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+	walletDAOErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_dao_errors_total",
+		Help: "Failed IWallet operations.",
+	}, []string{"method"})
+	walletLockWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wallet_lock_wait_seconds",
+		Help:    "Time spent waiting for wallet row locks, by op: lock_wallet for a single wallet, transfer for both wallets of a transfer.",
+		Buckets: []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	}, []string{"op"})
+
+	walletOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_operations_total",
+		Help: "Completed deposits, withdrawals and transfers.",
+	}, []string{"op_type"})
+	walletVolume = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_operation_amount_total",
+		Help: "Amount moved by completed deposits, withdrawals and transfers.",
+	}, []string{"op_type"})
+	walletInsufficientFunds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "wallet_insufficient_funds_total",
+		Help: "Withdrawals and transfers rejected for insufficient funds.",
+	}, []string{"op_type"})
+)
+
+// 按路由记录请求数和耗时，未匹配路由的请求归为 unmatched，避免路径作为标签
+func recordHTTPMetrics(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+	httpRequestDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+}
+
+// 记录存取款和转账的结果，余额不足单独计数
+func recordWalletOperation(opType string, amount float64, err error) {
+	switch err {
+	case nil:
+		walletOperations.WithLabelValues(opType).Inc()
+		walletVolume.WithLabelValues(opType).Add(amount)
+	case ErrNotEnough:
+		walletInsufficientFunds.WithLabelValues(opType).Inc()
+	}
+}
+
+// 记录每个 IWallet 方法的耗时、错误数和 span，钱包不存在和余额不足不算作错误
+type instrumentedWallet struct {
+	next IWallet
+}
+
+func instrumentWallet(next IWallet) IWallet {
+	return &instrumentedWallet{next: next}
+}
+
+// 开始方法的 span，返回的 ctx 把语句的 span 挂在方法的 span 下，done 记录耗时和错误
+func (w *instrumentedWallet) start(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "IWallet."+method)
+	return ctx, func(err error) {
+		walletDAODuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+		if err != nil && err != ErrWalletNotFound && err != ErrNotEnough {
+			walletDAOErrors.WithLabelValues(method).Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (w *instrumentedWallet) UpdateBalance(ctx context.Context, walletID int64, opType string, amount float64) (err error) {
+	ctx, done := w.start(ctx, "UpdateBalance")
+	defer func() { done(err) }()
+	return w.next.UpdateBalance(ctx, walletID, opType, amount)
+}
+
+func (w *instrumentedWallet) ExecTransfer(ctx context.Context, fromWalletID, toWalletID int64, amount float64) (err error) {
+	ctx, done := w.start(ctx, "ExecTransfer")
+	defer func() { done(err) }()
+	return w.next.ExecTransfer(ctx, fromWalletID, toWalletID, amount)
+}
+
+func (w *instrumentedWallet) GetWalletInfoById(ctx context.Context, walletID int64) (wallet *Wallet, err error) {
+	ctx, done := w.start(ctx, "GetWalletInfoById")
+	defer func() { done(err) }()
+	return w.next.GetWalletInfoById(ctx, walletID)
+}
+
+func (w *instrumentedWallet) LockWallet(ctx context.Context, walletID int64) (wallet *Wallet, err error) {
+	ctx, done := w.start(ctx, "LockWallet")
+	defer func() { done(err) }()
+	return w.next.LockWallet(ctx, walletID)
+}
+
+func (w *instrumentedWallet) GetTransactionsByWalletID(ctx context.Context, walletID int64, limit, offset int) (transactions []Transaction, err error) {
+	ctx, done := w.start(ctx, "GetTransactionsByWalletID")
+	defer func() { done(err) }()
+	return w.next.GetTransactionsByWalletID(ctx, walletID, limit, offset)
+}
+
+func (w *instrumentedWallet) GetBalanceAt(ctx context.Context, walletID int64, at time.Time) (balance float64, err error) {
+	ctx, done := w.start(ctx, "GetBalanceAt")
+	defer func() { done(err) }()
+	return w.next.GetBalanceAt(ctx, walletID, at)
+}
+
+// 整个事务一个 span，fn 收到的 ctx 把事务中操作的 span 挂在事务的 span 下
+func (w *instrumentedWallet) WithTx(ctx context.Context, fn func(ctx context.Context, uow *UnitOfWork) error) (err error) {
+	ctx, done := w.start(ctx, "WithTx")
+	defer func() { done(err) }()
+	return w.next.WithTx(ctx, func(ctx context.Context, uow *UnitOfWork) error {
+		return fn(ctx, &UnitOfWork{Wallet: instrumentWallet(uow.Wallet), tx: uow.tx})
+	})
+}
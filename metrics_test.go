@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 直方图已记录的样本数
+func sampleCount(t *testing.T, h prometheus.Observer) uint64 {
+	var m dto.Metric
+	require.NoError(t, h.(prometheus.Metric).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	// Create a new Gin router
+	router := gin.New()
+	router.Use(recordHTTPMetrics)
+	router.GET("/api/balance/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"balance": 100})
+	})
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	ok := httpRequests.WithLabelValues("GET", "/api/balance/:id", "200")
+	unmatched := httpRequests.WithLabelValues("GET", "unmatched", "404")
+	before, beforeUnmatched := testutil.ToFloat64(ok), testutil.ToFloat64(unmatched)
+	beforeLatency := sampleCount(t, httpRequestDuration.WithLabelValues("GET", "/api/balance/:id"))
+
+	for _, path := range []string{"/api/balance/1", "/api/balance/2", "/api/nothing/1"} {
+		// Perform the HTTP request
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	// 请求按路由模板而不是实际路径计数
+	assert.Equal(t, before+2, testutil.ToFloat64(ok))
+	assert.Equal(t, beforeUnmatched+1, testutil.ToFloat64(unmatched))
+	assert.Equal(t, beforeLatency+2, sampleCount(t, httpRequestDuration.WithLabelValues("GET", "/api/balance/:id")))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `http_requests_total{method="GET",route="/api/balance/:id",status="200"}`)
+}
+
+func TestInstrumentedWallet(t *testing.T) {
+	w := instrumentWallet(&MockWalletRepo{})
+	updates := walletDAODuration.WithLabelValues("UpdateBalance")
+	before, beforeErrors := sampleCount(t, updates), testutil.ToFloat64(walletDAOErrors.WithLabelValues("UpdateBalance"))
+	beforeLookupErrors := testutil.ToFloat64(walletDAOErrors.WithLabelValues("GetWalletInfoById"))
+
+	assert.NoError(t, w.UpdateBalance(context.Background(), 1, "deposit", 10))
+	assert.Error(t, w.UpdateBalance(context.Background(), 999, "deposit", 10))
+	// 钱包不存在不计为错误
+	_, err := w.GetWalletInfoById(context.Background(), 999)
+	assert.Equal(t, ErrWalletNotFound, err)
+
+	assert.Equal(t, before+2, sampleCount(t, updates))
+	assert.Equal(t, beforeErrors+1, testutil.ToFloat64(walletDAOErrors.WithLabelValues("UpdateBalance")))
+	assert.Equal(t, beforeLookupErrors, testutil.ToFloat64(walletDAOErrors.WithLabelValues("GetWalletInfoById")))
+}
+
+func TestWalletOperationMetrics(t *testing.T) {
+	a := App{Rp: &MockWalletRepo{}, Mb: &MockMemberRepo{}}
+	caller := &Principal{UserID: "user1"}
+	deposits, withdrawals := testutil.ToFloat64(walletOperations.WithLabelValues("deposit")), testutil.ToFloat64(walletOperations.WithLabelValues("withdraw"))
+	depositVolume := testutil.ToFloat64(walletVolume.WithLabelValues("deposit"))
+	rejected := testutil.ToFloat64(walletInsufficientFunds.WithLabelValues("withdraw"))
+
+	require.NoError(t, a.depositWithdraw(context.Background(), caller, 1, "deposit", 25, 0))
+	require.NoError(t, a.depositWithdraw(context.Background(), caller, 1, "withdraw", 5, 0))
+	assert.Equal(t, ErrNotEnough, a.depositWithdraw(context.Background(), caller, 1, "withdraw", 1000, 0))
+
+	assert.Equal(t, deposits+1, testutil.ToFloat64(walletOperations.WithLabelValues("deposit")))
+	assert.Equal(t, depositVolume+25, testutil.ToFloat64(walletVolume.WithLabelValues("deposit")))
+	assert.Equal(t, withdrawals+1, testutil.ToFloat64(walletOperations.WithLabelValues("withdraw")))
+	assert.Equal(t, rejected+1, testutil.ToFloat64(walletInsufficientFunds.WithLabelValues("withdraw")))
+}
+
+func TestLockWaitMetric(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(1, 100.0, "user1"))
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	transfers := sampleCount(t, walletLockWait.WithLabelValues("transfer"))
+	single := sampleCount(t, walletLockWait.WithLabelValues("lock_wallet"))
+	require.NoError(t, lockwalletForTransfer(context.Background(), tx, 1, 2))
+	assert.Equal(t, transfers+1, sampleCount(t, walletLockWait.WithLabelValues("transfer")))
+	assert.Equal(t, single, sampleCount(t, walletLockWait.WithLabelValues("lock_wallet")))
+
+	// 每次加锁只记录一次
+	_, err = (&WalletAccess{DB: db, tx: tx}).LockWallet(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, transfers+1, sampleCount(t, walletLockWait.WithLabelValues("transfer")))
+	assert.Equal(t, single+1, sampleCount(t, walletLockWait.WithLabelValues("lock_wallet")))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
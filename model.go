@@ -1,27 +1,241 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"time"
 )
 
+type User struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 钱包成员及其角色：owner、spender 或 viewer
+type WalletMember struct {
+	WalletID int64  `json:"wallet_id"`
+	UserID   string `json:"user_id"`
+	Role     string `json:"role"`
+}
+
 type Wallet struct {
 	ID      int64   `json:"id"`
 	Balance float64 `json:"balance"`
 	UserID  string  `json:"user_id"`
 }
 
+// 钱包内的子账户，用于为特定用途预留资金
+type Pocket struct {
+	ID       int64   `json:"id"`
+	WalletID int64   `json:"wallet_id"`
+	Name     string  `json:"name"`
+	Balance  float64 `json:"balance"`
+}
+
 type Transaction struct {
 	ID        int64     `json:"id"`
 	WalletID  int64     `json:"wallet_id"`
+	PocketID  *int64    `json:"pocket_id,omitempty"`
 	OpType    string    `json:"op_type"`
 	Amount    float64   `json:"amount"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// 待审批的大额转账
+type TransferRequest struct {
+	ID           int64      `json:"id"`
+	FromWalletID int64      `json:"from_wallet_id"`
+	FromPocketID int64      `json:"from_pocket_id,omitempty"`
+	ToWalletID   int64      `json:"to_wallet_id"`
+	Amount       float64    `json:"amount"`
+	Status       string     `json:"status"`
+	RequestedBy  string     `json:"requested_by"`
+	DecidedBy    string     `json:"decided_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DecidedAt    *time.Time `json:"decided_at,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+}
+
+// 转账审批流程中的一个步骤
+type TransferRequestEvent struct {
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 服务间调用使用的 API key，只保存哈希
+type APIKey struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Hash        string     `json:"-"`
+	Scopes      []string   `json:"scopes"`
+	WalletIDs   []int64    `json:"wallet_ids"`
+	RotatedFrom int64      `json:"rotated_from,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// API key 未吊销且未过期
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// 已提交的钱包资金变动，转账时 WalletID 为转出钱包
+type WalletEvent struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	WalletID   int64     `json:"wallet_id"`
+	PocketID   int64     `json:"pocket_id,omitempty"`
+	ToWalletID int64     `json:"to_wallet_id,omitempty"`
+	Amount     float64   `json:"amount"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// 订阅钱包事件的 webhook，密钥只在创建时返回
+type Webhook struct {
+	ID         int64     `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"-"`
+	WalletIDs  []int64   `json:"wallet_ids"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// 一个事件到一个 webhook 的投递记录
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+
+	// 投递时使用的 webhook 地址和密钥
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+
+	// 领取时设置的租约到期时间，保存结果时用于确认仍持有租约
+	LeaseUntil time.Time `json:"-"`
+}
+
+// 钱包日终余额快照
+type WalletSnapshot struct {
+	WalletID     int64     `json:"wallet_id"`
+	SnapshotDate time.Time `json:"snapshot_date"`
+	Balance      float64   `json:"balance"`
+}
+
+// 试算平衡表中按交易类型汇总的一行
+type TrialBalanceLine struct {
+	OpType  string  `json:"op_type"`
+	Credits float64 `json:"credits"`
+	Debits  float64 `json:"debits"`
+	Net     float64 `json:"net"`
+}
+
+// 钱包仓库，创建时绑定数据库连接
+// ctx 取消或超时后中断正在执行的语句，日志和 span 归属于 ctx 中的请求
 type IWallet interface {
-	UpdateBalance(db *sql.DB, walletID int64, opType string, amount float64) error
-	ExecTransfer(db *sql.DB, fromWalletID, toWalletID int64, amount float64) error
-	GetWalletInfoById(db *sql.DB, walletID int64) (*Wallet, error)
-	GetTransactionsByWalletID(db *sql.DB, walletID int64, limit, offset int) ([]Transaction, error)
+	UpdateBalance(ctx context.Context, walletID int64, opType string, amount float64) error
+	ExecTransfer(ctx context.Context, fromWalletID, toWalletID int64, amount float64) error
+	GetWalletInfoById(ctx context.Context, walletID int64) (*Wallet, error)
+	// 锁定钱包直到事务结束，只能在 WithTx 中使用
+	LockWallet(ctx context.Context, walletID int64) (*Wallet, error)
+	GetTransactionsByWalletID(ctx context.Context, walletID int64, limit, offset int) ([]Transaction, error)
+	GetBalanceAt(ctx context.Context, walletID int64, at time.Time) (float64, error)
+	// 通过 uow 执行的多个操作在同一事务中提交，fn 返回错误时全部回滚
+	// 在 fn 中再次调用 uow.Wallet.WithTx 时使用保存点，内层失败只撤销内层的操作
+	WithTx(ctx context.Context, fn func(ctx context.Context, uow *UnitOfWork) error) error
+}
+
+// IWallet.WithTx 开启的事务，钱包操作通过 Wallet 执行
+// 子账户和审批仓库中以 Tx 结尾的方法接收 uow，与钱包操作在同一事务中提交
+type UnitOfWork struct {
+	Wallet IWallet
+	tx     *sql.Tx
+}
+
+type ISnapshot interface {
+	CreateDailySnapshot(ctx context.Context, db *sql.DB, day time.Time) error
+	GetTrialBalance(ctx context.Context, db *sql.DB, day time.Time) ([]TrialBalanceLine, error)
+}
+
+// 子账户 ID 为 0 表示钱包的主余额
+// 与 IWallet 相同，ctx 取消或超时后中断正在执行的语句，包括等待钱包行锁的语句
+type IPocket interface {
+	CreatePocket(ctx context.Context, db *sql.DB, walletID int64, name string) (*Pocket, error)
+	GetPocketsByWalletID(ctx context.Context, db *sql.DB, walletID int64) ([]Pocket, error)
+	// 子账户在指定时间的余额，按交易记录计算
+	GetPocketsAt(ctx context.Context, db *sql.DB, walletID int64, at time.Time) ([]Pocket, error)
+	MovePocketFunds(ctx context.Context, db *sql.DB, walletID, fromPocketID, toPocketID int64, amount float64) error
+	WithdrawFromPocket(ctx context.Context, db *sql.DB, walletID, pocketID int64, amount float64) error
+	TransferFromPocket(ctx context.Context, db *sql.DB, fromWalletID, pocketID, toWalletID int64, amount float64) error
+	// 与 TransferFromPocket 相同，在 uow 的事务中执行
+	TransferFromPocketTx(ctx context.Context, uow *UnitOfWork, fromWalletID, pocketID, toWalletID int64, amount float64) error
+}
+
+type IUser interface {
+	CreateUser(ctx context.Context, db *sql.DB, user *User) error
+	GetUserById(ctx context.Context, db *sql.DB, userID string) (*User, error)
+	GetWalletsByUserID(ctx context.Context, db *sql.DB, userID string) ([]Wallet, error)
+	CreateWallet(ctx context.Context, db *sql.DB, userID string) (*Wallet, error)
+}
+
+type IMember interface {
+	GetMemberRole(ctx context.Context, db *sql.DB, walletID int64, userID string) (string, error)
+	GetMembers(ctx context.Context, db *sql.DB, walletID int64) ([]WalletMember, error)
+	SetMemberRole(ctx context.Context, db *sql.DB, walletID int64, userID, role string) error
+	RemoveMember(ctx context.Context, db *sql.DB, walletID int64, userID string) error
+}
+
+type IApproval interface {
+	CreateTransferRequest(ctx context.Context, db *sql.DB, req *TransferRequest) error
+	GetTransferRequest(ctx context.Context, db *sql.DB, requestID int64) (*TransferRequest, error)
+	GetTransferRequestEvents(ctx context.Context, db *sql.DB, requestID int64) ([]TransferRequestEvent, error)
+	GetPendingTransferRequests(ctx context.Context, db *sql.DB, approverID string, now time.Time) ([]TransferRequest, error)
+	UpdateTransferRequestStatus(ctx context.Context, db *sql.DB, requestID int64, from, to, actor, note string) error
+	// 锁定转账审批直到 uow 的事务结束
+	LockTransferRequest(ctx context.Context, uow *UnitOfWork, requestID int64) (*TransferRequest, error)
+	// 与 UpdateTransferRequestStatus 相同，在 uow 的事务中执行
+	UpdateTransferRequestStatusTx(ctx context.Context, uow *UnitOfWork, requestID int64, from, to, actor, note string) error
+	ExpireTransferRequests(ctx context.Context, db *sql.DB, now time.Time) (int64, error)
+}
+
+type IWebhook interface {
+	CreateWebhook(ctx context.Context, db *sql.DB, webhook *Webhook) error
+	GetWebhooks(ctx context.Context, db *sql.DB) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, db *sql.DB, webhookID int64) error
+	// 为订阅了该事件的 webhook 创建投递记录
+	Emit(ctx context.Context, db *sql.DB, event WalletEvent) error
+	// 领取到期的投递，领取后在 lease 内不会被再次领取
+	ClaimDueDeliveries(ctx context.Context, db *sql.DB, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, db *sql.DB, delivery *WebhookDelivery) error
+	GetDeliveries(ctx context.Context, db *sql.DB, webhookID int64, status string, limit int) ([]WebhookDelivery, error)
+	RetryDelivery(ctx context.Context, db *sql.DB, deliveryID int64, now time.Time) error
+}
+
+type IOutbox interface {
+	// 按写入顺序取出未发布的事件交给 publish，成功的事件标记为已发布，返回发布的数量
+	RelayOutbox(ctx context.Context, db *sql.DB, limit int, publish func(WalletEvent) error) (int, error)
+}
+
+type IAPIKey interface {
+	CreateAPIKey(ctx context.Context, db *sql.DB, key *APIKey) error
+	GetAPIKeyByHash(ctx context.Context, db *sql.DB, hash string) (*APIKey, error)
+	GetAPIKeys(ctx context.Context, db *sql.DB) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, db *sql.DB, keyID int64) error
+	RotateAPIKey(ctx context.Context, db *sql.DB, keyID int64, newKey *APIKey, oldExpiresAt time.Time) error
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+)
+
+// 描述全部接口的 OpenAPI 文档，同时用于请求校验
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+var openAPIDoc, openAPIRouter = mustLoadOpenAPI()
+
+// 加载并校验内嵌的 OpenAPI 文档，文档无效属于编程错误
+func mustLoadOpenAPI() (*openapi3.T, routers.Router) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %v", err))
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %v", err))
+	}
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		panic(fmt.Sprintf("invalid openapi.json: %v", err))
+	}
+	return doc, router
+}
+
+// 返回 OpenAPI 文档
+func openAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPISpec)
+}
+
+// 请求体上限，校验在认证和限流之前读取整个请求体
+const maxRequestBodySize = 1 << 20
+
+// 按 OpenAPI 文档校验路径参数、查询参数和请求体，不符合时返回 400，请求体过大时返回 413
+// 认证由 authMiddleware 和各接口负责，文档中未定义的路由直接放行
+func validateRequest(c *gin.Context) {
+	if c.Request.Body != nil {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBodySize)
+	}
+	route, pathParams, err := openAPIRouter.FindRoute(c.Request)
+	if err != nil {
+		c.Next()
+		return
+	}
+
+	// 与 ShouldBindJSON 一致，请求体不论 Content-Type 都按 JSON 校验，例如 curl -d 默认的表单类型
+	if c.ContentType() != "application/json" {
+		c.Request.Header.Set("Content-Type", "application/json")
+	}
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    c.Request,
+		PathParams: pathParams,
+		Route:      route,
+		Options:    &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+	}
+	if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": validationMessage(err)})
+		return
+	}
+	c.Next()
+}
+
+// 将校验错误转为简短的错误信息，不在响应中暴露 schema
+func validationMessage(err error) string {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return err.Error()
+	}
+
+	reason := reqErr.Reason
+	var schemaErr *openapi3.SchemaError
+	if errors.As(reqErr.Err, &schemaErr) {
+		reason = schemaErr.Reason
+		if field := strings.Join(schemaErr.JSONPointer(), "."); field != "" {
+			reason = field + ": " + reason
+		}
+	} else if reqErr.Err != nil && reason == "" {
+		reason = reqErr.Err.Error()
+	}
+
+	switch {
+	case reqErr.Parameter != nil:
+		return fmt.Sprintf("invalid %s parameter %s: %s", reqErr.Parameter.In, reqErr.Parameter.Name, reason)
+	case reqErr.RequestBody != nil:
+		return "invalid request body: " + reason
+	default:
+		return reason
+	}
+}
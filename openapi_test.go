@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gin 的 :id 对应 OpenAPI 的 {id}
+var ginParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPICoversAllRoutes(t *testing.T) {
+	// Initialize the app and set up the routes
+	a := App{}
+	router := a.setupRouter()
+
+	registered := map[string]bool{}
+	for _, route := range router.Routes() {
+		registered[route.Method+" "+ginParam.ReplaceAllString(route.Path, "{$1}")] = true
+	}
+	documented := map[string]bool{}
+	for path, item := range openAPIDoc.Paths.Map() {
+		for method := range item.Operations() {
+			documented[method+" "+path] = true
+		}
+	}
+
+	// 路由和文档必须一一对应
+	assert.Equal(t, registered, documented)
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	// Initialize the app and set up the routes
+	ap := newMockApprovalRepo()
+	a := App{
+		Rp:                &MockWalletRepo{},
+		Ss:                &MockSnapshotRepo{},
+		Pk:                &MockPocketRepo{},
+		Us:                &MockUserRepo{},
+		Mb:                &MockMemberRepo{},
+		Ap:                ap,
+		Ak:                newMockAPIKeyRepo(),
+		Wh:                newMockWebhookRepo(),
+		Tokens:            testTokens,
+		ApprovalThreshold: 200,
+	}
+	router := a.setupRouter()
+
+	tests := []struct {
+		method         string
+		path           string
+		body           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"GET", "/api/openapi.json", "", "", "", http.StatusOK},
+		{"GET", "/metrics", "", "Authorization", bearer("staff1", "support"), http.StatusOK},
+		{"GET", "/metrics", "", "", "", http.StatusUnauthorized},
+		{"GET", "/metrics", "", "Authorization", bearer("user1"), http.StatusForbidden},
+		{"GET", "/healthz", "", "", "", http.StatusOK},
+		{"GET", "/readyz", "", "", "", http.StatusServiceUnavailable},
+		{"PUT", "/api/balance/1", `{"op_type": "deposit", "amount": 10}`, "Authorization", bearer("user1"), http.StatusOK},
+		{"PUT", "/api/balance/1", `{"op_type": "withdraw", "amount": 1000}`, "Authorization", bearer("user1"), http.StatusOK},
+		{"PUT", "/api/balance/1", `{"op_type": "refund", "amount": 10}`, "Authorization", bearer("user1"), http.StatusBadRequest},
+		{"PUT", "/api/balance/1", `{"op_type": "deposit", "amount": 10}`, "", "", http.StatusUnauthorized},
+		{"PUT", "/api/balance/1", `{"op_type": "withdraw", "amount": 10}`, "Authorization", bearer("viewer1"), http.StatusForbidden},
+		{"PUT", "/api/balance/999", `{"op_type": "deposit", "amount": 10}`, "Authorization", bearer("user1"), http.StatusNotFound},
+		{"GET", "/api/balance/1", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/balance/1?at=2024-06-01T00:00:00Z", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/balance/abc", "", "Authorization", bearer("user1"), http.StatusBadRequest},
+		{"GET", "/api/balance/999/stream", "", "Authorization", bearer("user1"), http.StatusNotFound},
+		{"GET", "/api/balance/1/stream", "", "Authorization", bearer("user2"), http.StatusNotFound},
+		{"GET", "/api/balance/999/ws", "", "Authorization", bearer("user1"), http.StatusNotFound},
+		{"GET", "/api/balance/1/ws", "", "", "", http.StatusUnauthorized},
+		{"POST", "/api/transfer", `{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10}`, "Authorization", bearer("user1"), http.StatusOK},
+		{"POST", "/api/transfer", `{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 150}`, "Authorization", bearer("user1"), http.StatusOK},
+		{"POST", "/api/transfer", `{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 500}`, "Authorization", bearer("user1"), http.StatusAccepted},
+		{"POST", "/api/transfer", `{"from_wallet_id": 1, "to_wallet_id": 2}`, "Authorization", bearer("user1"), http.StatusBadRequest},
+		{"GET", "/api/transfers/pending", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/transfers/1", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/transfers/99", "", "Authorization", bearer("user1"), http.StatusNotFound},
+		{"POST", "/api/transfers/1/approve", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"POST", "/api/transfers/3/approve", "", "Authorization", bearer("user1"), http.StatusConflict},
+		{"POST", "/api/transfers/5/reject", `{"note": "duplicate"}`, "Authorization", bearer("user1"), http.StatusForbidden},
+		{"POST", "/api/transfers/4/reject", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/transaction/1", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/transaction/1?limit=0", "", "Authorization", bearer("user1"), http.StatusBadRequest},
+		{"POST", "/api/users", `{"id": "user3", "name": "Carol"}`, "Authorization", bearer("user3"), http.StatusCreated},
+		{"POST", "/api/users", `{"id": "user1", "name": "Alice"}`, "Authorization", bearer("user1"), http.StatusConflict},
+		{"GET", "/api/users/user1", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/users/user1/wallets", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"POST", "/api/users/user1/wallets", "", "Authorization", bearer("user1"), http.StatusCreated},
+		{"GET", "/api/users/user1/balance", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/users/user2/balance", "", "Authorization", bearer("user1"), http.StatusForbidden},
+		{"POST", "/api/wallet/1/pockets", `{"name": "holiday"}`, "Authorization", bearer("user1"), http.StatusCreated},
+		{"POST", "/api/wallet/1/pockets", `{"name": "rent"}`, "Authorization", bearer("user1"), http.StatusConflict},
+		{"GET", "/api/wallet/1/pockets", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"POST", "/api/wallet/1/pockets/move", `{"from_pocket_id": 0, "to_pocket_id": 1, "amount": 10}`, "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/wallet/1/members", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"PUT", "/api/wallet/1/members/viewer1", `{"role": "spender"}`, "Authorization", bearer("user1"), http.StatusOK},
+		{"PUT", "/api/wallet/1/members/viewer1", `{"role": "admin"}`, "Authorization", bearer("user1"), http.StatusBadRequest},
+		{"DELETE", "/api/wallet/1/members/viewer1", "", "Authorization", bearer("user1"), http.StatusOK},
+		{"GET", "/api/admin/trial-balance?date=2024-06-01", "", "Authorization", bearer("staff1", "finance"), http.StatusOK},
+		{"GET", "/api/admin/trial-balance?date=2024-06-02", "", "Authorization", bearer("staff1", "finance"), http.StatusNotFound},
+		{"POST", "/api/admin/snapshots", `{"date": "2024-06-01"}`, "Authorization", bearer("staff1", "finance"), http.StatusOK},
+		{"POST", "/api/admin/snapshots", `{"date": "2024-06-01"}`, "Authorization", bearer("staff1", "support"), http.StatusForbidden},
+		{"POST", "/api/admin/api-keys", `{"name": "reporting", "scopes": ["read_balance"], "wallet_ids": [1]}`, "Authorization", bearer("staff1", "admin"), http.StatusCreated},
+		{"GET", "/api/admin/api-keys", "", "Authorization", bearer("staff1", "support"), http.StatusOK},
+		{"POST", "/api/admin/api-keys/1/rotate", `{"overlap": "1h"}`, "Authorization", bearer("staff1", "admin"), http.StatusCreated},
+		{"DELETE", "/api/admin/api-keys/1", "", "Authorization", bearer("staff1", "admin"), http.StatusOK},
+		{"DELETE", "/api/admin/api-keys/99", "", "Authorization", bearer("staff1", "admin"), http.StatusNotFound},
+		{"POST", "/api/admin/webhooks", `{"url": "https://example.com/hook", "event_types": ["deposit", "transfer"]}`, "Authorization", bearer("staff1", "admin"), http.StatusCreated},
+		{"POST", "/api/admin/webhooks", `{"url": "https://example.com/hook", "event_types": ["refund"]}`, "Authorization", bearer("staff1", "admin"), http.StatusBadRequest},
+		{"GET", "/api/admin/webhooks", "", "Authorization", bearer("staff1", "support"), http.StatusOK},
+		{"GET", "/api/admin/webhooks/1/deliveries?status=dead", "", "Authorization", bearer("staff1", "support"), http.StatusOK},
+		{"GET", "/api/admin/webhooks/9/deliveries", "", "Authorization", bearer("staff1", "support"), http.StatusNotFound},
+		{"POST", "/api/admin/webhook-deliveries/1/retry", "", "Authorization", bearer("staff1", "admin"), http.StatusOK},
+		{"POST", "/api/admin/webhook-deliveries/1/retry", "", "Authorization", bearer("staff1", "admin"), http.StatusConflict},
+		{"DELETE", "/api/admin/webhooks/1", "", "Authorization", bearer("staff1", "admin"), http.StatusOK},
+		{"DELETE", "/api/admin/webhooks/9", "", "Authorization", bearer("staff1", "admin"), http.StatusNotFound},
+	}
+
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code, rec.Body.String())
+
+			// 响应必须符合文档中该接口和状态码的定义
+			route, pathParams, err := openAPIRouter.FindRoute(req)
+			require.NoError(t, err)
+			covered[route.Method+" "+route.Path] = true
+			input := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{
+					Request:    req,
+					PathParams: pathParams,
+					Route:      route,
+				},
+				Status: rec.Code,
+				Header: rec.Header(),
+				Body:   io.NopCloser(bytes.NewReader(rec.Body.Bytes())),
+				Options: &openapi3filter.Options{
+					IncludeResponseStatus: true,
+				},
+			}
+			assert.NoError(t, openapi3filter.ValidateResponse(context.Background(), input))
+		})
+	}
+
+	// 每个接口至少有一个用例
+	for path, item := range openAPIDoc.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, covered[method+" "+path], "no response test for %s %s", method, path)
+		}
+	}
+}
+
+func TestValidateRequest_BodyTooLarge(t *testing.T) {
+	// Create a new Gin router
+	router := gin.New()
+
+	// 校验在认证之前，未认证的请求也不能让服务读取过大的请求体
+	called := false
+	router.Use(validateRequest)
+	router.POST("/api/transfer", func(c *gin.Context) { called = true })
+
+	body := `{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10, "note": "` + strings.Repeat("x", maxRequestBodySize) + `"}`
+	req, _ := http.NewRequest("POST", "/api/transfer", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{"error":"request body too large"}`, rec.Body.String())
+	assert.False(t, called)
+}
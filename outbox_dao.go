@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// 发布事件时持有的事务级 advisory lock，同一时间只有一个实例发布，保证顺序
+const outboxLockID = 4040001
+
+// 每轮发布的事件数
+const outboxBatchSize = 100
+
+type OutboxAccess struct{}
+
+// 在账务事务中写入事件，与交易记录一起提交或回滚
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, event WalletEvent) error {
+	id, err := newEventID()
+	if err != nil {
+		return err
+	}
+	event.ID = id
+	event.CreatedAt = time.Now()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	var toWallet interface{}
+	if event.ToWalletID != 0 {
+		toWallet = event.ToWalletID
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox_events (event_id, event_type, wallet_id, to_wallet_id, payload, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		event.ID, event.Type, event.WalletID, toWallet, string(payload), event.CreatedAt)
+	return err
+}
+
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "evt_" + hex.EncodeToString(b), nil
+}
+
+// 按写入顺序发布未发布的事件，至少发布一次：发布成功但标记前中断的事件会被再次发布
+// 某个钱包的事件发布失败后，本轮跳过该钱包后面的事件，下一轮从失败的事件开始，保证同一钱包的事件按顺序发布
+// 其他实例正在发布时直接返回 0
+func (oa *OutboxAccess) RelayOutbox(ctx context.Context, db *sql.DB, limit int, publish func(WalletEvent) error) (int, error) {
+	// 开始事务
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLockID).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, payload FROM outbox_events
+		WHERE published_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+	type outboxRow struct {
+		id    int64
+		event WalletEvent
+	}
+	var pending []outboxRow
+	for rows.Next() {
+		var r outboxRow
+		var payload string
+		if err := rows.Scan(&r.id, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal([]byte(payload), &r.event); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	blocked := make(map[int64]bool)
+	var published []int64
+	for _, r := range pending {
+		if blocked[r.event.WalletID] || blocked[r.event.ToWalletID] {
+			continue
+		}
+		if err := publish(r.event); err != nil {
+			loggerFrom(ctx).Error("failed to publish event", "event_id", r.event.ID, "error", err)
+			blocked[r.event.WalletID] = true
+			if r.event.ToWalletID != 0 {
+				blocked[r.event.ToWalletID] = true
+			}
+			continue
+		}
+		published = append(published, r.id)
+	}
+
+	if len(published) > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE outbox_events SET published_at = $1 WHERE id = ANY($2)", time.Now(), pq.Array(published)); err != nil {
+			return 0, err
+		}
+	}
+
+	// 提交事务
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(published), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func outboxPayload(event WalletEvent) string {
+	payload, _ := json.Marshal(event)
+	return string(payload)
+}
+
+func TestRelayOutbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	events := []WalletEvent{
+		{ID: "evt_1", Type: "deposit", WalletID: 1, Amount: 10},
+		{ID: "evt_2", Type: "transfer", WalletID: 2, ToWalletID: 3, Amount: 5},
+		{ID: "evt_3", Type: "withdraw", WalletID: 1, Amount: 4},
+		{ID: "evt_4", Type: "deposit", WalletID: 3, Amount: 1},
+		{ID: "evt_5", Type: "deposit", WalletID: 4, Amount: 1},
+	}
+	rows := sqlmock.NewRows([]string{"id", "payload"})
+	for i, e := range events {
+		rows.AddRow(i+1, outboxPayload(e))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock\\(\\$1\\)").
+		WithArgs(outboxLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery("SELECT id, payload FROM outbox_events\\s+WHERE published_at IS NULL\\s+ORDER BY id").
+		WithArgs(100).
+		WillReturnRows(rows)
+	// evt_2 发布失败，同一钱包后面的 evt_4 留到下一轮
+	mock.ExpectExec("UPDATE outbox_events SET published_at = \\$1 WHERE id = ANY\\(\\$2\\)").
+		WithArgs(sqlmock.AnyArg(), "{1,3,5}").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	oa := &OutboxAccess{}
+	var published []string
+	n, err := oa.RelayOutbox(context.Background(), db, 100, func(e WalletEvent) error {
+		if e.ID == "evt_2" {
+			return errors.New("broker unavailable")
+		}
+		published = append(published, e.ID)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if n != 3 || len(published) != 3 || published[0] != "evt_1" || published[1] != "evt_3" || published[2] != "evt_5" {
+		t.Errorf("unexpected published events %v", published)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 其他实例正在发布时不读取事件
+func TestRelayOutbox_Locked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT pg_try_advisory_xact_lock\\(\\$1\\)").
+		WithArgs(outboxLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+	mock.ExpectRollback()
+
+	oa := &OutboxAccess{}
+	n, err := oa.RelayOutbox(context.Background(), db, 100, func(e WalletEvent) error {
+		t.Errorf("unexpected publish of %s", e.ID)
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing to be relayed, got %d, %v", n, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 事件写入失败时整个账务事务回滚
+func TestUpdateBalance_OutboxFail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").
+		WithArgs(10.0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO transactions").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO outbox_events").
+		WillReturnError(errors.New("insert failed"))
+	mock.ExpectRollback()
+
+	wa := &WalletAccess{DB: db}
+	if err := wa.UpdateBalance(context.Background(), 1, "deposit", 10); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrPocketNotFound = errors.New("pocket not found")
+	ErrPocketExists   = errors.New("pocket already exists")
+	ErrNotEnough      = errors.New("not enough")
+)
+
+type PocketAccess struct{}
+
+// 在钱包下创建子账户
+func (pa *PocketAccess) CreatePocket(ctx context.Context, db *sql.DB, walletID int64, name string) (*Pocket, error) {
+	pocket := Pocket{WalletID: walletID, Name: name}
+	err := db.QueryRowContext(ctx, "INSERT INTO pockets (wallet_id, name) VALUES ($1, $2) RETURNING id, balance", walletID, name).
+		Scan(&pocket.ID, &pocket.Balance)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrPocketExists
+		}
+		return nil, contextError(ctx, err)
+	}
+	return &pocket, nil
+}
+
+// 获取钱包下的全部子账户
+func (pa *PocketAccess) GetPocketsByWalletID(ctx context.Context, db *sql.DB, walletID int64) ([]Pocket, error) {
+	return queryPockets(ctx, db, "SELECT id, wallet_id, name, balance FROM pockets WHERE wallet_id = $1 ORDER BY id", walletID)
+}
+
+// 获取钱包的全部子账户，余额按交易记录计算到指定时间，created_at 是不带时区的 UTC 时间
+func (pa *PocketAccess) GetPocketsAt(ctx context.Context, db *sql.DB, walletID int64, at time.Time) ([]Pocket, error) {
+	return queryPockets(ctx, db, `
+		SELECT p.id, p.wallet_id, p.name, COALESCE(SUM(t.amount), 0)
+		FROM pockets p
+		LEFT JOIN transactions t ON t.pocket_id = p.id AND t.created_at <= $2
+		WHERE p.wallet_id = $1
+		GROUP BY p.id
+		ORDER BY p.id
+	`, walletID, at.UTC())
+}
+
+func queryPockets(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]Pocket, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var pockets []Pocket
+	for rows.Next() {
+		var pocket Pocket
+		if err := rows.Scan(&pocket.ID, &pocket.WalletID, &pocket.Name, &pocket.Balance); err != nil {
+			return nil, err
+		}
+		pockets = append(pockets, pocket)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	return pockets, nil
+}
+
+// 在主余额和子账户之间（或子账户之间）划转资金
+func (pa *PocketAccess) MovePocketFunds(ctx context.Context, db *sql.DB, walletID, fromPocketID, toPocketID int64, amount float64) (err error) {
+	defer func() { err = contextError(ctx, err) }()
+	// 开始事务，ctx 取消时 database/sql 自动回滚
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	// 锁定钱包，串行化同一钱包内的划转
+	_, err = tx.ExecContext(ctx, "SELECT 1 FROM wallet WHERE id = $1 FOR UPDATE", walletID)
+	if err != nil {
+		return err
+	}
+
+	if err := debitPocketOrMain(ctx, tx, walletID, fromPocketID, amount); err != nil {
+		return err
+	}
+	if err := adjustPocketOrMain(ctx, tx, walletID, toPocketID, amount); err != nil {
+		return err
+	}
+
+	// 划转的两条记录金额相抵，钱包总额不变
+	if err := insertPocketTransaction(ctx, tx, walletID, fromPocketID, "pocket_move", -amount); err != nil {
+		return err
+	}
+	if err := insertPocketTransaction(ctx, tx, walletID, toPocketID, "pocket_move", amount); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 从指定子账户取款
+func (pa *PocketAccess) WithdrawFromPocket(ctx context.Context, db *sql.DB, walletID, pocketID int64, amount float64) (err error) {
+	defer func() { err = contextError(ctx, err) }()
+	// 开始事务，ctx 取消时 database/sql 自动回滚
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	if err := debitPocketOrMain(ctx, tx, walletID, pocketID, amount); err != nil {
+		return err
+	}
+	if err := insertPocketTransaction(ctx, tx, walletID, pocketID, "withdraw", -amount); err != nil {
+		return err
+	}
+
+	if err := insertOutboxEvent(ctx, tx, WalletEvent{Type: "withdraw", WalletID: walletID, PocketID: pocketID, Amount: amount}); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 从指定子账户向另一个钱包的主余额转账
+func (pa *PocketAccess) TransferFromPocket(ctx context.Context, db *sql.DB, fromWalletID, pocketID, toWalletID int64, amount float64) (err error) {
+	defer func() { err = contextError(ctx, err) }()
+	// 开始事务，ctx 取消时 database/sql 自动回滚
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	if err := transferFromPocket(ctx, tx, fromWalletID, pocketID, toWalletID, amount); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 在 uow 的事务中从子账户转账，可以与钱包操作组成一个事务
+func (pa *PocketAccess) TransferFromPocketTx(ctx context.Context, uow *UnitOfWork, fromWalletID, pocketID, toWalletID int64, amount float64) error {
+	return contextError(ctx, transferFromPocket(ctx, uow.tx, fromWalletID, pocketID, toWalletID, amount))
+}
+
+// 在事务中从子账户向另一个钱包的主余额转账
+func transferFromPocket(ctx context.Context, tx *sql.Tx, fromWalletID, pocketID, toWalletID int64, amount float64) error {
+	// 锁定发起钱包和接收钱包
+	if err := lockwalletForTransfer(ctx, tx, fromWalletID, toWalletID); err != nil {
+		return err
+	}
+
+	if err := debitPocketOrMain(ctx, tx, fromWalletID, pocketID, amount); err != nil {
+		return err
+	}
+	if err := adjustPocketOrMain(ctx, tx, toWalletID, 0, amount); err != nil {
+		return err
+	}
+	if err := insertPocketTransaction(ctx, tx, fromWalletID, pocketID, "transfer", -amount); err != nil {
+		return err
+	}
+	if err := insertPocketTransaction(ctx, tx, toWalletID, 0, "transfer", amount); err != nil {
+		return err
+	}
+
+	return insertOutboxEvent(ctx, tx, WalletEvent{Type: "transfer", WalletID: fromWalletID, PocketID: pocketID, ToWalletID: toWalletID, Amount: amount})
+}
+
+// 从主余额或子账户扣款，余额不足时返回 ErrNotEnough
+func debitPocketOrMain(ctx context.Context, tx *sql.Tx, walletID, pocketID int64, amount float64) error {
+	var balance float64
+	var err error
+	if pocketID == 0 {
+		err = tx.QueryRowContext(ctx, "SELECT balance FROM wallet WHERE id = $1 FOR UPDATE", walletID).Scan(&balance)
+	} else {
+		err = tx.QueryRowContext(ctx, "SELECT balance FROM pockets WHERE id = $1 AND wallet_id = $2 FOR UPDATE", pocketID, walletID).Scan(&balance)
+	}
+	if err == sql.ErrNoRows {
+		if pocketID == 0 {
+			return ErrWalletNotFound
+		}
+		return ErrPocketNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return ErrNotEnough
+	}
+	return adjustPocketOrMain(ctx, tx, walletID, pocketID, -amount)
+}
+
+// 调整主余额或子账户余额
+func adjustPocketOrMain(ctx context.Context, tx *sql.Tx, walletID, pocketID int64, amount float64) error {
+	var res sql.Result
+	var err error
+	if pocketID == 0 {
+		res, err = tx.ExecContext(ctx, "UPDATE wallet SET balance = balance + $1 WHERE id = $2", amount, walletID)
+	} else {
+		res, err = tx.ExecContext(ctx, "UPDATE pockets SET balance = balance + $1 WHERE id = $2 AND wallet_id = $3", amount, pocketID, walletID)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if pocketID == 0 {
+			return ErrWalletNotFound
+		}
+		return ErrPocketNotFound
+	}
+	return nil
+}
+
+// 插入交易记录，子账户 ID 为 0 时记为主余额
+func insertPocketTransaction(ctx context.Context, tx *sql.Tx, walletID, pocketID int64, opType string, amount float64) error {
+	var pocket interface{}
+	if pocketID != 0 {
+		pocket = pocketID
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO transactions (wallet_id, pocket_id, op_type, amount, created_at) VALUES ($1, $2, $3, $4, $5)",
+		walletID, pocket, opType, amount, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestCreatePocket(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO pockets \\(wallet_id, name\\) VALUES \\(\\$1, \\$2\\) RETURNING id, balance").
+		WithArgs(int64(1), "rent").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance"}).AddRow(3, 0.0))
+
+	pa := &PocketAccess{}
+	pocket, err := pa.CreatePocket(context.Background(), db, 1, "rent")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if pocket.ID != 3 || pocket.WalletID != 1 || pocket.Name != "rent" {
+		t.Errorf("unexpected pocket %+v", pocket)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreatePocket_Exists(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO pockets").
+		WithArgs(int64(1), "rent").
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	pa := &PocketAccess{}
+	_, err = pa.CreatePocket(context.Background(), db, 1, "rent")
+	if err != ErrPocketExists {
+		t.Errorf("expected ErrPocketExists, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetPocketsByWalletID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "wallet_id", "name", "balance"}).
+		AddRow(1, 1, "rent", 25.0).
+		AddRow(2, 1, "savings", 10.0)
+	mock.ExpectQuery("SELECT id, wallet_id, name, balance FROM pockets WHERE wallet_id = \\$1 ORDER BY id").
+		WithArgs(int64(1)).
+		WillReturnRows(rows)
+
+	pa := &PocketAccess{}
+	pockets, err := pa.GetPocketsByWalletID(context.Background(), db, 1)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(pockets) != 2 {
+		t.Errorf("expected 2 pockets, got %d", len(pockets))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 子账户的历史余额按交易记录累加到指定时间，时间换算到 UTC
+func TestGetPocketsAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	at := time.Date(2024, 6, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*60*60))
+	rows := sqlmock.NewRows([]string{"id", "wallet_id", "name", "balance"}).
+		AddRow(1, 1, "rent", 15.0).
+		AddRow(2, 1, "savings", 0.0)
+	mock.ExpectQuery("SELECT p.id, p.wallet_id, p.name, COALESCE\\(SUM\\(t.amount\\), 0\\) FROM pockets p LEFT JOIN transactions t ON t.pocket_id = p.id AND t.created_at <= \\$2").
+		WithArgs(int64(1), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(rows)
+
+	pa := &PocketAccess{}
+	pockets, err := pa.GetPocketsAt(context.Background(), db, 1, at)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(pockets) != 2 || pockets[0].Balance != 15.0 || pockets[1].Balance != 0.0 {
+		t.Errorf("unexpected pockets %+v", pockets)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMovePocketFunds(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	walletID := int64(1)
+	pocketID := int64(2)
+	amount := 20.0
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(walletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT balance FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(walletID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100.0))
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").
+		WithArgs(-amount, walletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE pockets SET balance = balance \\+ \\$1 WHERE id = \\$2 AND wallet_id = \\$3").
+		WithArgs(amount, pocketID, walletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO transactions \\(wallet_id, pocket_id, op_type, amount, created_at\\)").
+		WithArgs(walletID, nil, "pocket_move", -amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions \\(wallet_id, pocket_id, op_type, amount, created_at\\)").
+		WithArgs(walletID, pocketID, "pocket_move", amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	pa := &PocketAccess{}
+	if err := pa.MovePocketFunds(context.Background(), db, walletID, 0, pocketID, amount); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestWithdrawFromPocket_NotEnough(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT balance FROM pockets WHERE id = \\$1 AND wallet_id = \\$2 FOR UPDATE").
+		WithArgs(int64(2), int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(5.0))
+	mock.ExpectRollback()
+
+	pa := &PocketAccess{}
+	if err := pa.WithdrawFromPocket(context.Background(), db, 1, 2, 20.0); err != ErrNotEnough {
+		t.Errorf("expected ErrNotEnough, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTransferFromPocket(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	fromWalletID := int64(1)
+	pocketID := int64(2)
+	toWalletID := int64(3)
+	amount := 20.0
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(fromWalletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(toWalletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT balance FROM pockets WHERE id = \\$1 AND wallet_id = \\$2 FOR UPDATE").
+		WithArgs(pocketID, fromWalletID).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(50.0))
+	mock.ExpectExec("UPDATE pockets SET balance = balance \\+ \\$1 WHERE id = \\$2 AND wallet_id = \\$3").
+		WithArgs(-amount, pocketID, fromWalletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").
+		WithArgs(amount, toWalletID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO transactions \\(wallet_id, pocket_id, op_type, amount, created_at\\)").
+		WithArgs(fromWalletID, pocketID, "transfer", -amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions \\(wallet_id, pocket_id, op_type, amount, created_at\\)").
+		WithArgs(toWalletID, nil, "transfer", amount, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO outbox_events").
+		WithArgs(sqlmock.AnyArg(), "transfer", fromWalletID, toWalletID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	pa := &PocketAccess{}
+	if err := pa.TransferFromPocket(context.Background(), db, fromWalletID, pocketID, toWalletID, amount); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// 等待钱包行锁时 ctx 超时，语句被中断并回滚
+func TestTransferFromPocket_LockTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillDelayFor(time.Second).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	pa := &PocketAccess{}
+	start := time.Now()
+	if err := pa.TransferFromPocket(ctx, db, 1, 2, 3, 20.0); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the lock to be abandoned at the deadline, took %s", elapsed)
+	}
+
+	// database/sql 在后台回滚 ctx 已结束的事务
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 在钱包下创建子账户
+func (a *App) createPocketHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Name == "" || len(request.Name) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pocket name"})
+		return
+	}
+
+	// 获取钱包信息
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), req.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		return
+	}
+	if !a.authorizeWallet(c, wallet, PermManagePockets) {
+		return
+	}
+
+	pocket, err := a.Pk.CreatePocket(c.Request.Context(), a.DB, wallet.ID, request.Name)
+	if err != nil {
+		if err == ErrPocketExists {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"pocket": pocket})
+}
+
+// 查询钱包下的子账户
+func (a *App) getPocketsHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 获取钱包信息
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), req.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		return
+	}
+	if !a.authorizeWallet(c, wallet, PermReadBalance) {
+		return
+	}
+
+	pockets, err := a.Pk.GetPocketsByWalletID(c.Request.Context(), a.DB, wallet.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if pockets == nil {
+		pockets = []Pocket{}
+	}
+	c.JSON(http.StatusOK, gin.H{"pockets": pockets})
+}
+
+// 在主余额和子账户之间划转，子账户 ID 为 0 表示主余额
+func (a *App) movePocketFundsHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var request struct {
+		FromPocketID int64   `json:"from_pocket_id"`
+		ToPocketID   int64   `json:"to_pocket_id"`
+		Amount       float64 `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if request.FromPocketID < 0 || request.ToPocketID < 0 || request.FromPocketID == request.ToPocketID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pocket id"})
+		return
+	}
+
+	// 获取钱包信息
+	wallet, err := a.Rp.GetWalletInfoById(c.Request.Context(), req.Id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "wallet not found"})
+		return
+	}
+	if !a.authorizeWallet(c, wallet, PermManagePockets) {
+		return
+	}
+
+	err = a.Pk.MovePocketFunds(c.Request.Context(), a.DB, wallet.ID, request.FromPocketID, request.ToPocketID, request.Amount)
+	switch err {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"message": "move successful"})
+	case ErrPocketNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case ErrNotEnough:
+		c.JSON(http.StatusOK, gin.H{"message": "not enough"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
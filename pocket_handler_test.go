@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 钱包 1 下有一个余额为 25 的子账户 1，2024 年起子账户 1 的历史余额为 10
+type MockPocketRepo struct{}
+
+func (m *MockPocketRepo) CreatePocket(ctx context.Context, db *sql.DB, walletID int64, name string) (*Pocket, error) {
+	if name == "rent" {
+		return nil, ErrPocketExists
+	}
+	return &Pocket{ID: 2, WalletID: walletID, Name: name}, nil
+}
+
+func (m *MockPocketRepo) GetPocketsByWalletID(ctx context.Context, db *sql.DB, walletID int64) ([]Pocket, error) {
+	if walletID == 1 {
+		return []Pocket{{ID: 1, WalletID: 1, Name: "rent", Balance: 25.0}}, nil
+	}
+	return nil, nil
+}
+
+func (m *MockPocketRepo) GetPocketsAt(ctx context.Context, db *sql.DB, walletID int64, at time.Time) ([]Pocket, error) {
+	if walletID != 1 {
+		return nil, nil
+	}
+	pocket := Pocket{ID: 1, WalletID: 1, Name: "rent"}
+	if !at.Before(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		pocket.Balance = 10.0
+	}
+	return []Pocket{pocket}, nil
+}
+
+func (m *MockPocketRepo) MovePocketFunds(ctx context.Context, db *sql.DB, walletID, fromPocketID, toPocketID int64, amount float64) error {
+	if fromPocketID > 1 || toPocketID > 1 {
+		return ErrPocketNotFound
+	}
+	if (fromPocketID == 1 && amount > 25.0) || (fromPocketID == 0 && amount > 100.0) {
+		return ErrNotEnough
+	}
+	return nil
+}
+
+func (m *MockPocketRepo) WithdrawFromPocket(ctx context.Context, db *sql.DB, walletID, pocketID int64, amount float64) error {
+	if pocketID != 1 {
+		return ErrPocketNotFound
+	}
+	if amount > 25.0 {
+		return ErrNotEnough
+	}
+	return nil
+}
+
+func (m *MockPocketRepo) TransferFromPocket(ctx context.Context, db *sql.DB, fromWalletID, pocketID, toWalletID int64, amount float64) error {
+	return m.WithdrawFromPocket(ctx, db, fromWalletID, pocketID, amount)
+}
+
+func (m *MockPocketRepo) TransferFromPocketTx(ctx context.Context, uow *UnitOfWork, fromWalletID, pocketID, toWalletID int64, amount float64) error {
+	return m.WithdrawFromPocket(ctx, nil, fromWalletID, pocketID, amount)
+}
+
+func TestCreatePocketHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+	router.Use(asCaller("user1"))
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}}
+	router.POST("/api/wallet/:id/pockets", a.createPocketHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		id             string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Create Pocket Success",
+			id:             "1",
+			requestBody:    map[string]interface{}{"name": "savings"},
+			expectedStatus: http.StatusCreated,
+			expectedBody: map[string]interface{}{
+				"pocket": map[string]interface{}{"id": 2.0, "wallet_id": 1.0, "name": "savings", "balance": 0.0},
+			},
+		},
+		{
+			name:           "Pocket Exists",
+			id:             "1",
+			requestBody:    map[string]interface{}{"name": "rent"},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]interface{}{"error": "pocket already exists"},
+		},
+		{
+			name:           "Empty Name",
+			id:             "1",
+			requestBody:    map[string]interface{}{"name": ""},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid pocket name"},
+		},
+		{
+			name:           "Wallet Not Found",
+			id:             "999",
+			requestBody:    map[string]interface{}{"name": "savings"},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "wallet not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest("POST", "/api/wallet/"+tt.id+"/pockets", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
+
+func TestGetPocketsHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+	router.Use(asCaller("user1"))
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}}
+	router.GET("/api/wallet/:id/pockets", a.getPocketsHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Get Pockets Success",
+			id:             "1",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"pockets": []interface{}{
+					map[string]interface{}{"id": 1.0, "wallet_id": 1.0, "name": "rent", "balance": 25.0},
+				},
+			},
+		},
+		{
+			name:           "Wallet Not Found",
+			id:             "999",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "wallet not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("GET", "/api/wallet/"+tt.id+"/pockets", nil)
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
+
+func TestMovePocketFundsHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+	router.Use(asCaller("user1"))
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}}
+	router.POST("/api/wallet/:id/pockets/move", a.movePocketFundsHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		id             string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Main To Pocket",
+			id:             "1",
+			requestBody:    map[string]interface{}{"from_pocket_id": 0, "to_pocket_id": 1, "amount": 50.0},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "move successful"},
+		},
+		{
+			name:           "Pocket To Main Not Enough",
+			id:             "1",
+			requestBody:    map[string]interface{}{"from_pocket_id": 1, "to_pocket_id": 0, "amount": 50.0},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "not enough"},
+		},
+		{
+			name:           "Pocket Not Found",
+			id:             "1",
+			requestBody:    map[string]interface{}{"from_pocket_id": 0, "to_pocket_id": 9, "amount": 10.0},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "pocket not found"},
+		},
+		{
+			name:           "Same Pocket",
+			id:             "1",
+			requestBody:    map[string]interface{}{"from_pocket_id": 1, "to_pocket_id": 1, "amount": 10.0},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid pocket id"},
+		},
+		{
+			name:           "Invalid Amount",
+			id:             "1",
+			requestBody:    map[string]interface{}{"from_pocket_id": 0, "to_pocket_id": 1, "amount": -10.0},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "amount must be positive"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest("POST", "/api/wallet/"+tt.id+"/pockets/move", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// 发布已提交的钱包事件，outbox relay 按顺序调用，返回错误时该事件稍后重新发布
+// 同一事件可能被发布多次，接收方按事件 ID 去重
+type Publisher interface {
+	Publish(event WalletEvent) error
+}
+
+// 把事件按行写成 JSON，用于标准输出或文件
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+// 追加写入文件，文件不存在时创建
+func NewFilePublisher(path string) (*WriterPublisher, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterPublisher(f), nil
+}
+
+func (p *WriterPublisher) Publish(event WalletEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(line, '\n'))
+	return err
+}
+
+// 在内存中保存发布的事件，用于测试和本地调试
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []WalletEvent
+}
+
+func (p *MemoryPublisher) Publish(event WalletEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return nil
+}
+
+// 已发布事件的副本
+func (p *MemoryPublisher) Events() []WalletEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]WalletEvent(nil), p.events...)
+}
+
+// 依次发布到多个 Publisher，任一失败时整个事件重新发布
+type MultiPublisher []Publisher
+
+func (m MultiPublisher) Publish(event WalletEvent) error {
+	for _, p := range m {
+		if err := p.Publish(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
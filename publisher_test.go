@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(event WalletEvent) error {
+	return errors.New("unavailable")
+}
+
+func TestFilePublisher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	// 重新打开时追加写入
+	for _, id := range []string{"evt_1", "evt_2"} {
+		p, err := NewFilePublisher(path)
+		require.NoError(t, err)
+		require.NoError(t, p.Publish(WalletEvent{ID: id, Type: "deposit", WalletID: 1, Amount: 10}))
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"id":"evt_1"`)
+	assert.Contains(t, lines[1], `"id":"evt_2"`)
+}
+
+func TestMultiPublisher(t *testing.T) {
+	first, second := &MemoryPublisher{}, &MemoryPublisher{}
+	event := WalletEvent{ID: "evt_1", Type: "deposit", WalletID: 1, Amount: 10}
+
+	require.NoError(t, MultiPublisher{first, second}.Publish(event))
+	assert.Equal(t, []WalletEvent{event}, first.Events())
+	assert.Equal(t, []WalletEvent{event}, second.Events())
+
+	// 任一失败时返回错误，由 relay 重新发布整个事件
+	assert.Error(t, MultiPublisher{first, failingPublisher{}, second}.Publish(event))
+	assert.Len(t, first.Events(), 2)
+	assert.Len(t, second.Events(), 1)
+}
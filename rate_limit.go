@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 令牌桶参数：每秒补充 Rate 个令牌，最多积累 Burst 个，Rate 为 0 表示不限流
+type RateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// 单个路由按调用方和按钱包的限流
+type RouteRateLimit struct {
+	Client RateLimit `json:"client"`
+	Wallet RateLimit `json:"wallet"`
+}
+
+// 默认的路由限流配置
+var defaultRateLimits = map[string]RouteRateLimit{
+	"deposit_withdraw": {
+		Client: RateLimit{Rate: 10, Burst: 20},
+		Wallet: RateLimit{Rate: 5, Burst: 10},
+	},
+	"transfer": {
+		Client: RateLimit{Rate: 5, Burst: 10},
+		Wallet: RateLimit{Rate: 2, Burst: 5},
+	},
+}
+
+// 解析 JSON 格式的路由限流配置，覆盖默认配置中的同名路由
+func parseRateLimits(config string) (map[string]RouteRateLimit, error) {
+	limits := map[string]RouteRateLimit{}
+	for route, limit := range defaultRateLimits {
+		limits[route] = limit
+	}
+	if err := json.Unmarshal([]byte(config), &limits); err != nil {
+		return nil, err
+	}
+	for route, limit := range limits {
+		for _, l := range []RateLimit{limit.Client, limit.Wallet} {
+			if l.Rate < 0 || (l.Rate > 0 && l.Burst < 1) {
+				return nil, fmt.Errorf("route %q: rate must not be negative and burst must be at least 1", route)
+			}
+		}
+	}
+	return limits, nil
+}
+
+type RateLimiter interface {
+	// 从 key 对应的桶中取一个令牌，取不到时返回需要等待的时间
+	Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error)
+}
+
+// 按经过的时间补充令牌后尝试取出一个
+func takeToken(tokens float64, updatedAt, now time.Time, limit RateLimit) (float64, bool, time.Duration) {
+	elapsed := now.Sub(updatedAt).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+	}
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, false, wait
+}
+
+// 单实例使用的内存限流
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+func (l *MemoryRateLimiter) Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 每分钟最多清理一次已经补满的桶，补满的桶与新桶等价
+	if now.Sub(l.lastPrune) >= time.Minute {
+		for k, b := range l.buckets {
+			if !now.Before(b.fullAt) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Burst), updatedAt: now}
+		l.buckets[key] = b
+	}
+	tokens, allowed, wait := takeToken(b.tokens, b.updatedAt, now, limit)
+	b.tokens, b.updatedAt = tokens, now
+	b.fullAt = now.Add(time.Duration((float64(limit.Burst) - tokens) / limit.Rate * float64(time.Second)))
+	return allowed, wait, nil
+}
+
+// 多实例部署时使用的 Postgres 限流，令牌桶保存在 rate_limit_buckets 表中
+type PostgresRateLimiter struct {
+	DB *sql.DB
+}
+
+func (l *PostgresRateLimiter) Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	// 开始事务
+	tx, err := l.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	// 第一次访问时创建满的桶，然后锁定该桶
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at) VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING
+	`, key, float64(limit.Burst), now); err != nil {
+		return false, 0, err
+	}
+	var tokens float64
+	var updatedAt time.Time
+	if err := tx.QueryRowContext(ctx, "SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = $1 FOR UPDATE", key).
+		Scan(&tokens, &updatedAt); err != nil {
+		return false, 0, err
+	}
+
+	tokens, allowed, wait := takeToken(tokens, updatedAt, now, limit)
+	if _, err := tx.ExecContext(ctx, "UPDATE rate_limit_buckets SET tokens = $2, updated_at = $3 WHERE key = $1", key, tokens, now); err != nil {
+		return false, 0, err
+	}
+
+	// 提交事务
+	if err := tx.Commit(); err != nil {
+		return false, 0, err
+	}
+	return allowed, wait, nil
+}
+
+// 按调用方和钱包对路由限流，超出时返回 429 和 Retry-After
+// walletID 从请求中取出要操作的钱包，返回 0 表示不按钱包限流
+func (a *App) rateLimit(route string, walletID func(c *gin.Context) int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := callerFrom(c)
+		wait, err := a.checkRateLimit(c.Request.Context(), route, caller, c.ClientIP(), func() int64 { return walletID(c) })
+		if err != nil {
+			c.Header("Retry-After", retryAfter(wait))
+			c.AbortWithStatusJSON(errorStatus[errorCodeOf(err)].HTTP, gin.H{"error": err.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// 按调用方和钱包各取一个令牌，取不到时返回 ErrRateLimited 和需要等待的时间，HTTP 和 gRPC 共用
+// caller 为 nil 时按客户端 IP 限流，walletID 只在需要按钱包限流时调用
+func (a *App) checkRateLimit(ctx context.Context, route string, caller *Principal, clientIP string, walletID func() int64) (time.Duration, error) {
+	limits, ok := a.RateLimits[route]
+	if a.Limiter == nil || !ok {
+		return 0, nil
+	}
+
+	now := time.Now()
+	if limits.Client.Rate > 0 {
+		if wait, ok := a.takeRateLimitToken(ctx, "client:"+route+":"+rateLimitClientKey(caller, clientIP), limits.Client, now); !ok {
+			return wait, ErrRateLimited
+		}
+	}
+	// 只对已认证的调用者按钱包限流，避免匿名请求耗尽别人钱包的令牌
+	if caller != nil && limits.Wallet.Rate > 0 {
+		if id := walletID(); id > 0 {
+			if wait, ok := a.takeRateLimitToken(ctx, "wallet:"+route+":"+strconv.FormatInt(id, 10), limits.Wallet, now); !ok {
+				return wait, ErrRateLimited
+			}
+		}
+	}
+	return 0, nil
+}
+
+// 取一个令牌，取不到时返回需要等待的时间；限流后端出错时放行
+func (a *App) takeRateLimitToken(ctx context.Context, key string, limit RateLimit, now time.Time) (time.Duration, bool) {
+	allowed, wait, err := a.Limiter.Allow(ctx, key, limit, now)
+	if err != nil {
+		loggerFrom(ctx).Error("rate limiter failed", "key", key, "error", err)
+		return 0, true
+	}
+	return wait, allowed
+}
+
+// Retry-After 的秒数，至少为 1
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+// 限流使用的调用方标识，未认证的请求按客户端 IP
+func rateLimitClientKey(caller *Principal, clientIP string) string {
+	switch {
+	case caller == nil:
+		return "ip:" + clientIP
+	case caller.APIKeyID != 0:
+		return "api_key:" + strconv.FormatInt(caller.APIKeyID, 10)
+	case caller.ClientID != "":
+		return "hmac:" + caller.ClientID
+	default:
+		return "user:" + caller.UserID
+	}
+}
+
+// 从路径参数 :id 中取钱包 ID
+func walletFromParam(c *gin.Context) int64 {
+	id, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	return id
+}
+
+// 限流时读取的转账请求体上限
+const maxTransferBodySize = 64 << 10
+
+// 从转账请求体中取转出钱包 ID，请求体读取后会被放回
+// 请求体超出上限时不按钱包限流，处理函数读取剩余部分时会得到错误
+func walletFromTransferBody(c *gin.Context) int64 {
+	if c.Request.Body == nil {
+		return 0
+	}
+	limited := http.MaxBytesReader(c.Writer, c.Request.Body, maxTransferBodySize)
+	body, err := io.ReadAll(limited)
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), limited))
+	if err != nil {
+		return 0
+	}
+	var request struct {
+		FromWalletId int64 `json:"from_wallet_id"`
+	}
+	_ = json.Unmarshal(body, &request)
+	return request.FromWalletId
+}
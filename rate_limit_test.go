@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	l := NewMemoryRateLimiter()
+	limit := RateLimit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	// 桶满时可以连续取 Burst 个令牌
+	allowed, _, _ := l.Allow(context.Background(), "k", limit, now)
+	assert.True(t, allowed)
+	allowed, _, _ = l.Allow(context.Background(), "k", limit, now)
+	assert.True(t, allowed)
+	allowed, wait, _ := l.Allow(context.Background(), "k", limit, now)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	// 补充半个令牌后仍需等待半秒
+	allowed, wait, _ = l.Allow(context.Background(), "k", limit, now.Add(500*time.Millisecond))
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	allowed, _, _ = l.Allow(context.Background(), "k", limit, now.Add(time.Second))
+	assert.True(t, allowed)
+
+	// 不同的 key 互不影响
+	allowed, _, _ = l.Allow(context.Background(), "other", limit, now)
+	assert.True(t, allowed)
+}
+
+func TestPostgresRateLimiter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	limit := RateLimit{Rate: 2, Burst: 5}
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO rate_limit_buckets \\(key, tokens, updated_at\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT \\(key\\) DO NOTHING").
+		WithArgs("wallet:transfer:1", 5.0, now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT tokens, updated_at FROM rate_limit_buckets WHERE key = \\$1 FOR UPDATE").
+		WithArgs("wallet:transfer:1").
+		WillReturnRows(sqlmock.NewRows([]string{"tokens", "updated_at"}).AddRow(0.5, now.Add(-100*time.Millisecond)))
+	mock.ExpectExec("UPDATE rate_limit_buckets SET tokens = \\$2, updated_at = \\$3 WHERE key = \\$1").
+		WithArgs("wallet:transfer:1", sqlmock.AnyArg(), now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	l := &PostgresRateLimiter{DB: db}
+	allowed, wait, err := l.Allow(context.Background(), "wallet:transfer:1", limit, now)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	// 0.5 个令牌加上 0.1 秒补充的 0.2 个，还差 0.3 个，需要等待 150 毫秒
+	assert.False(t, allowed)
+	assert.InDelta(t, float64(150*time.Millisecond), float64(wait), float64(time.Millisecond))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{
+		Rp:      &MockWalletRepo{},
+		Pk:      &MockPocketRepo{},
+		Mb:      &MockMemberRepo{},
+		Tokens:  testTokens,
+		Limiter: NewMemoryRateLimiter(),
+		RateLimits: map[string]RouteRateLimit{
+			"transfer": {
+				Client: RateLimit{Rate: 0.001, Burst: 2},
+				Wallet: RateLimit{Rate: 0.001, Burst: 3},
+			},
+		},
+	}
+	router.Use(a.authMiddleware)
+	router.POST("/api/transfer", a.rateLimit("transfer", walletFromTransferBody), a.transferHandler)
+
+	transfer := func(caller string) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10.0})
+		req, _ := http.NewRequest("POST", "/api/transfer", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", bearer(caller))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 每个调用方最多连续两次
+	assert.Equal(t, http.StatusOK, transfer("user1").Code)
+	assert.Equal(t, http.StatusOK, transfer("user1").Code)
+	rec := transfer("user1")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1000", rec.Header().Get("Retry-After"))
+
+	// 同一个钱包在所有调用方之间最多连续三次
+	assert.Equal(t, http.StatusOK, transfer("spender1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, transfer("spender1").Code)
+}
+
+func TestWalletFromTransferBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 请求体读取后放回，处理函数可以再次读取
+	body := `{"from_wallet_id": 7, "to_wallet_id": 2, "amount": 10}`
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/api/transfer", bytes.NewBufferString(body))
+	assert.Equal(t, int64(7), walletFromTransferBody(c))
+	restored, err := io.ReadAll(c.Request.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(restored))
+
+	// 超出上限时只读取到上限，处理函数读取时得到错误
+	large := `{"from_wallet_id": 7, "note": "` + strings.Repeat("x", maxTransferBodySize) + `"}`
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("POST", "/api/transfer", bytes.NewBufferString(large))
+	assert.Equal(t, int64(0), walletFromTransferBody(c))
+	_, err = io.ReadAll(c.Request.Body)
+	var maxBytesErr *http.MaxBytesError
+	assert.ErrorAs(t, err, &maxBytesErr)
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := parseRateLimits(`{"transfer":{"client":{"rate":1,"burst":1}},"approve":{"client":{"rate":1,"burst":2}}}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assert.Equal(t, RouteRateLimit{Client: RateLimit{Rate: 1, Burst: 1}}, limits["transfer"])
+	assert.Equal(t, defaultRateLimits["deposit_withdraw"], limits["deposit_withdraw"])
+	assert.Equal(t, RateLimit{Rate: 1, Burst: 2}, limits["approve"].Client)
+
+	_, err = parseRateLimits(`{"transfer":{"client":{"rate":1,"burst":0}}}`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+)
+
+// 停机时等待进行中的请求和后台任务的默认时长，以及就绪检查失败后继续处理请求的默认时长
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	defaultDrainDelay      = 5 * time.Second
+)
+
+// 后台任务，停机时按启动顺序逐个停止，每个任务执行完当前一轮后退出
+type workerGroup struct {
+	workers []*worker
+}
+
+type worker struct {
+	name   string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// 在新的 goroutine 中运行任务，run 在 ctx 取消后返回
+func (g *workerGroup) Go(name string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &worker{name: name, cancel: cancel, done: make(chan struct{})}
+	g.workers = append(g.workers, w)
+	go func() {
+		defer close(w.done)
+		run(ctx)
+	}()
+}
+
+// 按启动顺序停止任务，ctx 到期后不再等待剩下的任务
+func (g *workerGroup) Stop(ctx context.Context) {
+	for _, w := range g.workers {
+		w.cancel()
+		select {
+		case <-w.done:
+			slog.Info("stopped worker", "worker", w.name)
+		case <-ctx.Done():
+			slog.Error("worker did not stop in time", "worker", w.name)
+		}
+	}
+}
+
+// 排空等待结束后拒绝新请求，探针除外，让负载均衡看到未就绪
+func (a *App) rejectWhileClosing(c *gin.Context) {
+	if !a.closing.Load() || c.FullPath() == "/healthz" || c.FullPath() == "/readyz" {
+		c.Next()
+		return
+	}
+	c.Header("Connection", "close")
+	c.Header("Retry-After", "1")
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+}
+
+// 停机：就绪检查先失败，drainDelay 内继续处理请求，让负载均衡有时间摘除本实例
+// 之后拒绝新请求并等待进行中的 HTTP 和 gRPC 请求完成，再按顺序停止后台任务
+// 数据库连接由调用方在最后关闭
+func (a *App) shutdown(ctx context.Context, drainDelay time.Duration, srv *http.Server, grpcServer *grpc.Server, workers *workerGroup) {
+	a.startDraining()
+	slog.Info("draining requests", "drain_delay", drainDelay.String())
+	timer := time.NewTimer(drainDelay)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+	a.startClosing()
+
+	done := make(chan struct{})
+	if grpcServer != nil {
+		go func() {
+			grpcServer.GracefulStop()
+			close(done)
+		}()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("requests did not finish in time", "error", err)
+		srv.Close()
+	}
+	if grpcServer != nil {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			slog.Error("grpc requests did not finish in time")
+			grpcServer.Stop()
+		}
+	}
+
+	workers.Stop(ctx)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectWhileClosing(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := &App{}
+	router.Use(a.rejectWhileClosing)
+	router.GET("/api/balance/:id", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"balance": 100}) })
+	router.GET("/readyz", a.readyzHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/balance/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 排空等待期间就绪检查失败，但仍处理请求
+	a.startDraining()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/balance/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	a.startClosing()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/balance/1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "close", w.Header().Get("Connection"))
+
+	// 探针不被拒绝，就绪检查报告排空中
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"status":"draining"}`, w.Body.String())
+}
+
+func TestShutdown(t *testing.T) {
+	a := &App{}
+	started, release := make(chan struct{}), make(chan struct{})
+	router := gin.New()
+	router.Use(a.rejectWhileClosing)
+	router.POST("/api/transfer", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.JSON(http.StatusOK, gin.H{"message": "transfer successful"})
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: router}
+	go func() { _ = srv.Serve(ln) }()
+
+	// 后台任务按启动顺序停止
+	var mu sync.Mutex
+	var stopped []string
+	workers := &workerGroup{}
+	for _, name := range []string{"outbox relay", "webhook delivery"} {
+		name := name
+		workers.Go(name, func(ctx context.Context) {
+			<-ctx.Done()
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+		})
+	}
+
+	// 停机开始时有一个转账正在进行
+	type result struct {
+		status int
+		body   string
+	}
+	results := make(chan result)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/api/transfer", "application/json", nil)
+		if err != nil {
+			results <- result{}
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		results <- result{resp.StatusCode, string(body)}
+	}()
+	<-started
+
+	done := make(chan struct{})
+	go func() {
+		a.shutdown(context.Background(), 0, srv, nil, workers)
+		close(done)
+	}()
+
+	// 进行中的请求完成前不会停止后台任务
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("shutdown returned before the request finished")
+	default:
+	}
+	mu.Lock()
+	assert.Empty(t, stopped)
+	mu.Unlock()
+	// 新连接不再被接受
+	_, err = http.Post("http://"+ln.Addr().String()+"/api/transfer", "application/json", nil)
+	assert.Error(t, err)
+
+	close(release)
+	res := <-results
+	assert.Equal(t, http.StatusOK, res.status)
+	assert.JSONEq(t, `{"message":"transfer successful"}`, res.body)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	assert.Equal(t, []string{"outbox relay", "webhook delivery"}, stopped)
+}
+
+func TestShutdown_DrainDelay(t *testing.T) {
+	a := &App{}
+	router := gin.New()
+	router.Use(a.rejectWhileClosing)
+	router.GET("/api/balance/:id", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"balance": 100}) })
+	router.GET("/readyz", a.readyzHandler)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: router}
+	go func() { _ = srv.Serve(ln) }()
+
+	done := make(chan struct{})
+	go func() {
+		a.shutdown(context.Background(), 200*time.Millisecond, srv, nil, &workerGroup{})
+		close(done)
+	}()
+
+	// 排空等待期间就绪检查失败，新连接的请求仍被处理
+	time.Sleep(50 * time.Millisecond)
+	resp, err := http.Get("http://" + ln.Addr().String() + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, err = http.Get("http://" + ln.Addr().String() + "/api/balance/1")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	_, err = http.Get("http://" + ln.Addr().String() + "/api/balance/1")
+	assert.Error(t, err)
+}
+
+// 没有 gRPC 服务且 ctx 已到期时不会 panic
+func TestShutdown_NoGRPCAfterDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 20; i++ {
+		a := &App{}
+		assert.NotPanics(t, func() { a.shutdown(ctx, time.Second, &http.Server{}, nil, &workerGroup{}) })
+	}
+}
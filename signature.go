@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 签名请求使用的请求头
+const (
+	HeaderClientID  = "X-Client-ID"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+// 签名请求的请求体上限
+const maxSignedBodySize = 1 << 20
+
+var (
+	ErrUnknownClient    = errors.New("unknown client")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleTimestamp   = errors.New("timestamp outside the allowed window")
+	ErrReplayedNonce    = errors.New("nonce already used")
+)
+
+// 使用共享密钥签名请求的合作方
+type SigningClient struct {
+	ID        string   `json:"id"`
+	Secret    string   `json:"secret"`
+	Scopes    []string `json:"scopes"`
+	WalletIDs []int64  `json:"wallet_ids"`
+}
+
+// 校验请求签名，并拒绝时间戳超出范围或 nonce 重复的请求
+type SignatureVerifier struct {
+	clients map[string]SigningClient
+	maxSkew time.Duration
+	nonces  NonceStore
+	now     func() time.Time
+}
+
+// 从 JSON 文件加载签名客户端
+func LoadSignatureVerifier(path string, maxSkew time.Duration, nonces NonceStore) (*SignatureVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Clients []SigningClient `json:"clients"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return NewSignatureVerifier(file.Clients, maxSkew, nonces)
+}
+
+func NewSignatureVerifier(clients []SigningClient, maxSkew time.Duration, nonces NonceStore) (*SignatureVerifier, error) {
+	v := &SignatureVerifier{
+		clients: map[string]SigningClient{},
+		maxSkew: maxSkew,
+		nonces:  nonces,
+		now:     time.Now,
+	}
+	for _, client := range clients {
+		if client.ID == "" || client.Secret == "" {
+			return nil, errors.New("signing clients need an id and a secret")
+		}
+		if _, ok := v.clients[client.ID]; ok {
+			return nil, fmt.Errorf("duplicate client id %q", client.ID)
+		}
+		for _, scope := range client.Scopes {
+			if !apiKeyScopes[scope] {
+				return nil, fmt.Errorf("client %q: invalid scope %q", client.ID, scope)
+			}
+		}
+		v.clients[client.ID] = client
+	}
+	return v, nil
+}
+
+// 签名内容：方法、路径（含查询参数）、时间戳、nonce 和请求体的 SHA-256，以换行分隔
+func signingString(method, path, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+// 计算请求签名，十六进制编码的 HMAC-SHA256
+func signRequest(secret, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingString(method, path, timestamp, nonce, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 校验签名请求，成功时返回对应的调用者；请求体读取后会被放回
+func (v *SignatureVerifier) Verify(r *http.Request) (*Principal, error) {
+	client, ok := v.clients[r.Header.Get(HeaderClientID)]
+	if !ok {
+		return nil, ErrUnknownClient
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	if nonce == "" || len(nonce) > 128 {
+		return nil, ErrInvalidSignature
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrStaleTimestamp
+	}
+	now := v.now()
+	signedAt := time.Unix(sec, 0)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return nil, ErrStaleTimestamp
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxSignedBodySize {
+			return nil, ErrInvalidSignature
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := signRequest(client.Secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return nil, ErrInvalidSignature
+	}
+
+	// 签名正确后才记录 nonce，超出时间窗口的请求已被拒绝，nonce 只需保留到窗口结束
+	added, err := v.nonces.Add(r.Context(), client.ID, nonce, signedAt.Add(v.maxSkew+time.Second), now)
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrReplayedNonce
+	}
+
+	return &Principal{ClientID: client.ID, Scopes: client.Scopes, WalletIDs: client.WalletIDs}, nil
+}
+
+// 带签名头的请求按签名认证，其余请求交给其他认证方式
+func (a *App) signatureMiddleware(c *gin.Context) {
+	if c.GetHeader(HeaderClientID) == "" {
+		c.Next()
+		return
+	}
+	if a.Signatures == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "request signing is not enabled"})
+		return
+	}
+
+	caller, err := a.Signatures.Verify(c.Request)
+	switch err {
+	case nil:
+	case ErrUnknownClient, ErrInvalidSignature, ErrStaleTimestamp, ErrReplayedNonce:
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	default:
+		// 读取请求体或记录 nonce 失败
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	c.Set(callerKey, caller)
+	c.Next()
+}
+
+// 记录签名客户端已使用的 nonce
+type NonceStore interface {
+	// 记录客户端的 nonce，已存在且未过期时返回 false
+	Add(ctx context.Context, clientID, nonce string, expiresAt, now time.Time) (bool, error)
+}
+
+// 单实例使用的内存 nonce 记录，过期后清理
+type MemoryNonceStore struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	lastPrune time.Time
+}
+
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: map[string]time.Time{}}
+}
+
+func (s *MemoryNonceStore) Add(ctx context.Context, clientID, nonce string, expiresAt, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 每分钟最多清理一次过期的 nonce
+	if now.Sub(s.lastPrune) >= time.Minute {
+		for n, exp := range s.nonces {
+			if !now.Before(exp) {
+				delete(s.nonces, n)
+			}
+		}
+		s.lastPrune = now
+	}
+	key := clientID + ":" + nonce
+	if exp, ok := s.nonces[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	s.nonces[key] = expiresAt
+	return true, nil
+}
+
+// 多实例共享的 nonce 记录，保存在 request_nonces 表中
+type PostgresNonceStore struct {
+	DB *sql.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func (s *PostgresNonceStore) Add(ctx context.Context, clientID, nonce string, expiresAt, now time.Time) (bool, error) {
+	// 每分钟最多清理一次过期的 nonce
+	s.mu.Lock()
+	prune := now.Sub(s.lastPrune) >= time.Minute
+	if prune {
+		s.lastPrune = now
+	}
+	s.mu.Unlock()
+	if prune {
+		if _, err := s.DB.ExecContext(ctx, "DELETE FROM request_nonces WHERE expires_at <= $1", now); err != nil {
+			return false, err
+		}
+	}
+
+	// 已存在且未过期时不更新，影响的行数为 0
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO request_nonces (client_id, nonce, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (client_id, nonce) DO UPDATE SET expires_at = EXCLUDED.expires_at
+		WHERE request_nonces.expires_at <= $4
+	`, clientID, nonce, expiresAt, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
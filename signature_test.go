@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureMiddleware(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	signatures, err := NewSignatureVerifier([]SigningClient{
+		{ID: "partner1", Secret: "partner1-secret", Scopes: []string{PermDeposit, PermTransfer}},
+		{ID: "partner2", Secret: "partner2-secret", Scopes: []string{PermDeposit}, WalletIDs: []int64{2}},
+	}, 5*time.Minute, NewMemoryNonceStore())
+	if err != nil {
+		t.Fatalf("failed to create verifier: %s", err)
+	}
+	signatures.now = func() time.Time { return now }
+
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}, Pk: &MockPocketRepo{}, Mb: &MockMemberRepo{}, Signatures: signatures}
+	router.PUT("/api/balance/:id", a.signatureMiddleware, a.depositWithdrawHandler)
+	router.POST("/api/transfer", a.signatureMiddleware, a.transferHandler)
+
+	deposit, _ := json.Marshal(map[string]interface{}{"op_type": "deposit", "amount": 10.0})
+	withdraw, _ := json.Marshal(map[string]interface{}{"op_type": "withdraw", "amount": 10.0})
+	transfer, _ := json.Marshal(map[string]interface{}{"from_wallet_id": 1, "to_wallet_id": 2, "amount": 10.0})
+	ts := strconv.FormatInt(now.Unix(), 10)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           []byte
+		client         string
+		secret         string
+		timestamp      string
+		nonce          string
+		signedBody     []byte
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Signed Deposit",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      ts,
+			nonce:          "n1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Replayed Nonce",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      ts,
+			nonce:          "n1",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "nonce already used",
+		},
+		{
+			name:           "Signed Transfer",
+			method:         "POST",
+			path:           "/api/transfer",
+			body:           transfer,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      ts,
+			nonce:          "n2",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Body Changed After Signing",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           withdraw,
+			signedBody:     deposit,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      ts,
+			nonce:          "n3",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid signature",
+		},
+		{
+			name:           "Wrong Secret",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "partner1",
+			secret:         "guess",
+			timestamp:      ts,
+			nonce:          "n4",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "invalid signature",
+		},
+		{
+			name:           "Timestamp Too Old",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      strconv.FormatInt(now.Add(-6*time.Minute).Unix(), 10),
+			nonce:          "n5",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "timestamp outside the allowed window",
+		},
+		{
+			name:           "Timestamp In The Future",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      strconv.FormatInt(now.Add(6*time.Minute).Unix(), 10),
+			nonce:          "n6",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "timestamp outside the allowed window",
+		},
+		{
+			name:           "Unknown Client",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "stranger",
+			secret:         "partner1-secret",
+			timestamp:      ts,
+			nonce:          "n7",
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "unknown client",
+		},
+		{
+			name:           "Missing Scope",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           withdraw,
+			client:         "partner1",
+			secret:         "partner1-secret",
+			timestamp:      ts,
+			nonce:          "n8",
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "forbidden",
+		},
+		{
+			name:           "Wallet Not Allowed",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			client:         "partner2",
+			secret:         "partner2-secret",
+			timestamp:      ts,
+			nonce:          "n1",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "wallet not found",
+		},
+		{
+			name:           "Unsigned Request",
+			method:         "PUT",
+			path:           "/api/balance/1",
+			body:           deposit,
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "unauthorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest(tt.method, tt.path, bytes.NewBuffer(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.client != "" {
+				signedBody := tt.signedBody
+				if signedBody == nil {
+					signedBody = tt.body
+				}
+				req.Header.Set(HeaderClientID, tt.client)
+				req.Header.Set(HeaderTimestamp, tt.timestamp)
+				req.Header.Set(HeaderNonce, tt.nonce)
+				req.Header.Set(HeaderSignature, signRequest(tt.secret, tt.method, tt.path, tt.timestamp, tt.nonce, signedBody))
+			}
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			if tt.expectedError != "" {
+				assert.Equal(t, tt.expectedError, responseBody["error"])
+			}
+		})
+	}
+}
+
+func TestSignatureMiddleware_Disabled(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Rp: &MockWalletRepo{}}
+	router.PUT("/api/balance/:id", a.signatureMiddleware, a.depositWithdrawHandler)
+
+	req, _ := http.NewRequest("PUT", "/api/balance/1", bytes.NewBufferString("{}"))
+	req.Header.Set(HeaderClientID, "partner1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	s := NewMemoryNonceStore()
+	ctx := context.Background()
+	now := time.Now()
+
+	added, err := s.Add(ctx, "partner1", "a", now.Add(time.Minute), now)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, _ = s.Add(ctx, "partner1", "a", now.Add(time.Minute), now.Add(30*time.Second))
+	assert.False(t, added)
+	// 不同客户端的 nonce 互不影响
+	added, _ = s.Add(ctx, "partner2", "a", now.Add(time.Minute), now.Add(30*time.Second))
+	assert.True(t, added)
+	// 过期后同一个 nonce 可以再次使用
+	added, _ = s.Add(ctx, "partner1", "a", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	assert.True(t, added)
+}
+
+func TestPostgresNonceStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	expiresAt := now.Add(time.Minute)
+	mock.ExpectExec("DELETE FROM request_nonces WHERE expires_at <= \\$1").
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("INSERT INTO request_nonces \\(client_id, nonce, expires_at\\) VALUES \\(\\$1, \\$2, \\$3\\) ON CONFLICT \\(client_id, nonce\\) DO UPDATE .* WHERE request_nonces.expires_at <= \\$4").
+		WithArgs("partner1", "a", expiresAt, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 一分钟内不再清理；nonce 已存在且未过期时没有行被更新
+	mock.ExpectExec("INSERT INTO request_nonces").
+		WithArgs("partner1", "a", expiresAt, now.Add(time.Second)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	s := &PostgresNonceStore{DB: db}
+	added, err := s.Add(context.Background(), "partner1", "a", expiresAt, now)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	assert.True(t, added)
+	added, err = s.Add(context.Background(), "partner1", "a", expiresAt, now.Add(time.Second))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	assert.False(t, added)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+type SnapshotAccess struct{}
+
+// 记录指定日期所有钱包的日终余额，并生成当日的试算平衡表
+func (sa *SnapshotAccess) CreateDailySnapshot(ctx context.Context, db *sql.DB, day time.Time) error {
+	date := day.Format(dateLayout)
+
+	// 开始事务
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	// 日终余额取当日结束前的全部交易之和，重复执行时覆盖旧快照
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO wallet_snapshots (wallet_id, snapshot_date, balance)
+		SELECT w.id, $1::date, COALESCE(SUM(t.amount), 0)
+		FROM wallet w
+		LEFT JOIN transactions t ON t.wallet_id = w.id AND t.created_at < $1::date + 1
+		GROUP BY w.id
+		ON CONFLICT (wallet_id, snapshot_date) DO UPDATE SET balance = EXCLUDED.balance, created_at = CURRENT_TIMESTAMP
+	`, date)
+	if err != nil {
+		return err
+	}
+
+	// 重新生成当日的试算平衡表
+	_, err = tx.ExecContext(ctx, "DELETE FROM trial_balances WHERE report_date = $1::date", date)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO trial_balances (report_date, op_type, credits, debits, net)
+		SELECT $1::date, op_type,
+			COALESCE(SUM(amount) FILTER (WHERE amount > 0), 0),
+			COALESCE(-SUM(amount) FILTER (WHERE amount < 0), 0),
+			COALESCE(SUM(amount), 0)
+		FROM transactions
+		WHERE created_at >= $1::date AND created_at < $1::date + 1
+		GROUP BY op_type
+	`, date)
+	if err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 获取指定日期的试算平衡表
+func (sa *SnapshotAccess) GetTrialBalance(ctx context.Context, db *sql.DB, day time.Time) ([]TrialBalanceLine, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT op_type, credits, debits, net
+		FROM trial_balances
+		WHERE report_date = $1::date
+		ORDER BY op_type
+	`, day.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []TrialBalanceLine
+	for rows.Next() {
+		var line TrialBalanceLine
+		if err := rows.Scan(&line.OpType, &line.Credits, &line.Debits, &line.Net); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
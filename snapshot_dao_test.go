@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCreateDailySnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO wallet_snapshots").
+		WithArgs("2024-06-01").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM trial_balances WHERE report_date = \\$1::date").
+		WithArgs("2024-06-01").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO trial_balances").
+		WithArgs("2024-06-01").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	sa := &SnapshotAccess{}
+	if err := sa.CreateDailySnapshot(context.Background(), db, day); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateDailySnapshot_SnapshotFail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO wallet_snapshots").
+		WithArgs("2024-06-01").
+		WillReturnError(fmt.Errorf("snapshot failed"))
+	mock.ExpectRollback()
+
+	sa := &SnapshotAccess{}
+	if err := sa.CreateDailySnapshot(context.Background(), db, day); err == nil || err.Error() != "snapshot failed" {
+		t.Errorf("expected 'snapshot failed' error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetTrialBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"op_type", "credits", "debits", "net"}).
+		AddRow("deposit", 100.0, 0.0, 100.0).
+		AddRow("transfer", 20.0, 20.0, 0.0)
+	mock.ExpectQuery("SELECT op_type, credits, debits, net FROM trial_balances WHERE report_date = \\$1::date ORDER BY op_type").
+		WithArgs("2024-06-01").
+		WillReturnRows(rows)
+
+	sa := &SnapshotAccess{}
+	lines, err := sa.GetTrialBalance(context.Background(), db, day)
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(lines) != 2 {
+		t.Errorf("expected 2 lines, got %d", len(lines))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 查询指定日期的试算平衡表
+func (a *App) getTrialBalanceHandler(c *gin.Context) {
+	day, err := time.Parse(dateLayout, c.Query("date"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date"})
+		return
+	}
+
+	lines, err := a.Ss.GetTrialBalance(c.Request.Context(), a.DB, day)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(lines) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "trial balance not found"})
+		return
+	}
+
+	// 汇总所有交易类型
+	var total TrialBalanceLine
+	total.OpType = "total"
+	for _, line := range lines {
+		total.Credits += line.Credits
+		total.Debits += line.Debits
+		total.Net += line.Net
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":  day.Format(dateLayout),
+		"lines": lines,
+		"total": total,
+	})
+}
+
+// 手动生成指定日期的快照和试算平衡表，用于补跑
+func (a *App) createSnapshotHandler(c *gin.Context) {
+	var request struct {
+		Date string `json:"date"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	day, err := time.Parse(dateLayout, request.Date)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date"})
+		return
+	}
+
+	if err := a.Ss.CreateDailySnapshot(c.Request.Context(), a.DB, day); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "snapshot created"})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockSnapshotRepo struct{}
+
+func (m *MockSnapshotRepo) CreateDailySnapshot(ctx context.Context, db *sql.DB, day time.Time) error {
+	if day.Year() < 2000 {
+		return errors.New("snapshot failed")
+	}
+	return nil
+}
+
+func (m *MockSnapshotRepo) GetTrialBalance(ctx context.Context, db *sql.DB, day time.Time) ([]TrialBalanceLine, error) {
+	if day.Format(dateLayout) == "2024-06-01" {
+		return []TrialBalanceLine{
+			{OpType: "deposit", Credits: 100.0, Debits: 0, Net: 100.0},
+			{OpType: "withdraw", Credits: 0, Debits: 30.0, Net: -30.0},
+		}, nil
+	}
+	return nil, nil
+}
+
+func TestGetTrialBalanceHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Ss: &MockSnapshotRepo{}}
+	router.GET("/api/admin/trial-balance", a.getTrialBalanceHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		date           string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Get Trial Balance Success",
+			date:           "2024-06-01",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"date": "2024-06-01",
+				"lines": []interface{}{
+					map[string]interface{}{"op_type": "deposit", "credits": 100.0, "debits": 0.0, "net": 100.0},
+					map[string]interface{}{"op_type": "withdraw", "credits": 0.0, "debits": 30.0, "net": -30.0},
+				},
+				"total": map[string]interface{}{"op_type": "total", "credits": 100.0, "debits": 30.0, "net": 70.0},
+			},
+		},
+		{
+			name:           "Trial Balance Not Found",
+			date:           "2024-06-02",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "trial balance not found"},
+		},
+		{
+			name:           "Invalid Date",
+			date:           "yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid date"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("GET", "/api/admin/trial-balance?date="+tt.date, nil)
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
+
+func TestCreateSnapshotHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Ss: &MockSnapshotRepo{}}
+	router.POST("/api/admin/snapshots", a.createSnapshotHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Create Snapshot Success",
+			requestBody:    map[string]interface{}{"date": "2024-06-01"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"message": "snapshot created"},
+		},
+		{
+			name:           "Create Snapshot Error",
+			requestBody:    map[string]interface{}{"date": "1999-06-01"},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   map[string]interface{}{"error": "snapshot failed"},
+		},
+		{
+			name:           "Invalid Date",
+			requestBody:    map[string]interface{}{"date": "2024/06/01"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid date"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest("POST", "/api/admin/snapshots", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
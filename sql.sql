@@ -1,26 +1,321 @@
+-- Create the users table to store the owners of wallets
+CREATE TABLE IF NOT EXISTS users (
+    id VARCHAR(255) PRIMARY KEY, -- Unique identifier for each user
+    name VARCHAR(255) NOT NULL, -- Display name of the user
+    email VARCHAR(255) UNIQUE, -- Optional contact email, unique when set
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP -- Time the user was created
+);
+
+COMMENT ON COLUMN users.id IS 'Unique identifier for each user';
+COMMENT ON COLUMN users.name IS 'Display name of the user';
+COMMENT ON COLUMN users.email IS 'Optional contact email, unique when set';
+COMMENT ON COLUMN users.created_at IS 'Time the user was created';
+
 -- Create the wallet table to store user wallet information
 CREATE TABLE IF NOT EXISTS wallet (
     id SERIAL PRIMARY KEY, -- Unique identifier for each wallet
     balance DECIMAL(10, 2) DEFAULT 0.00, -- Wallet balance with a default value of 0.00
-    user_id VARCHAR(255) NOT NULL -- User ID associated with the wallet
+    user_id VARCHAR(255) NOT NULL REFERENCES users(id) -- User ID associated with the wallet
 );
 
 COMMENT ON COLUMN wallet.id IS 'Unique identifier for each wallet';
 COMMENT ON COLUMN wallet.balance IS 'Wallet balance with a default value of 0.00';
 COMMENT ON COLUMN wallet.user_id IS 'User ID associated with the wallet';
 
+-- Link existing wallets to users; wallets created before the users table get a user of the same id
+INSERT INTO users (id, name) SELECT DISTINCT user_id, user_id FROM wallet ON CONFLICT (id) DO NOTHING;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'wallet_user_id_fkey') THEN
+        ALTER TABLE wallet ADD CONSTRAINT wallet_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
+    END IF;
+END $$;
+
 -- Create the transactions table to store transaction details
 CREATE TABLE IF NOT EXISTS transactions (
     id SERIAL PRIMARY KEY, -- Unique identifier for each transaction
     wallet_id INT, -- Foreign key referencing the wallet table
-    op_type VARCHAR(20) CHECK (op_type IN ('deposit', 'withdraw', 'transfer')) NOT NULL, -- Type of transaction: 'deposit', 'withdraw', or 'transfer'
+    op_type VARCHAR(20) CHECK (op_type IN ('deposit', 'withdraw', 'transfer', 'pocket_move')) NOT NULL, -- Type of transaction: 'deposit', 'withdraw', 'transfer' or 'pocket_move'
     amount DECIMAL(10, 2) NOT NULL, -- Amount involved in the transaction
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Timestamp of the transaction, defaults to the current time
+    created_at TIMESTAMP DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC'), -- Timestamp of the transaction in UTC, defaults to the current time
     FOREIGN KEY (wallet_id) REFERENCES wallet(id) -- Foreign key constraint linking to the wallet table
 );
 
 COMMENT ON COLUMN transactions.id IS 'Unique identifier for each transaction';
 COMMENT ON COLUMN transactions.wallet_id IS 'Foreign key referencing the wallet table';
-COMMENT ON COLUMN transactions.op_type IS 'Type of transaction: deposit, withdraw, or transfer';
+COMMENT ON COLUMN transactions.op_type IS 'Type of transaction: deposit, withdraw, transfer or pocket_move';
 COMMENT ON COLUMN transactions.amount IS 'Amount involved in the transaction';
-COMMENT ON COLUMN transactions.created_at IS 'Timestamp of the transaction, defaults to the current time';
\ No newline at end of file
+COMMENT ON COLUMN transactions.created_at IS 'Timestamp of the transaction in UTC, defaults to the current time';
+
+-- Create the wallet_snapshots table to store end-of-day wallet balances
+CREATE TABLE IF NOT EXISTS wallet_snapshots (
+    wallet_id INT NOT NULL, -- Foreign key referencing the wallet table
+    snapshot_date DATE NOT NULL, -- Day the snapshot closes
+    balance DECIMAL(10, 2) NOT NULL, -- Closing balance at the end of the day
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the snapshot was recorded
+    PRIMARY KEY (wallet_id, snapshot_date),
+    FOREIGN KEY (wallet_id) REFERENCES wallet(id)
+);
+
+COMMENT ON COLUMN wallet_snapshots.wallet_id IS 'Foreign key referencing the wallet table';
+COMMENT ON COLUMN wallet_snapshots.snapshot_date IS 'Day the snapshot closes';
+COMMENT ON COLUMN wallet_snapshots.balance IS 'Closing balance at the end of the day';
+COMMENT ON COLUMN wallet_snapshots.created_at IS 'Time the snapshot was recorded';
+
+-- Create the trial_balances table to store the daily trial balance report
+CREATE TABLE IF NOT EXISTS trial_balances (
+    report_date DATE NOT NULL, -- Day the report covers
+    op_type VARCHAR(20) NOT NULL, -- Type of transaction being summarised
+    credits DECIMAL(12, 2) NOT NULL, -- Sum of positive amounts
+    debits DECIMAL(12, 2) NOT NULL, -- Sum of negative amounts, as a positive number
+    net DECIMAL(12, 2) NOT NULL, -- Credits minus debits
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the report was generated
+    PRIMARY KEY (report_date, op_type)
+);
+
+COMMENT ON COLUMN trial_balances.report_date IS 'Day the report covers';
+COMMENT ON COLUMN trial_balances.op_type IS 'Type of transaction being summarised';
+COMMENT ON COLUMN trial_balances.credits IS 'Sum of positive amounts';
+COMMENT ON COLUMN trial_balances.debits IS 'Sum of negative amounts, as a positive number';
+COMMENT ON COLUMN trial_balances.net IS 'Credits minus debits';
+COMMENT ON COLUMN trial_balances.created_at IS 'Time the report was generated';
+
+-- Create the pockets table to store named sub-accounts inside a wallet
+CREATE TABLE IF NOT EXISTS pockets (
+    id SERIAL PRIMARY KEY, -- Unique identifier for each pocket
+    wallet_id INT NOT NULL, -- Foreign key referencing the wallet table
+    name VARCHAR(100) NOT NULL, -- Pocket name, unique within the wallet
+    balance DECIMAL(10, 2) DEFAULT 0.00, -- Money earmarked in the pocket
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the pocket was created
+    UNIQUE (wallet_id, name),
+    FOREIGN KEY (wallet_id) REFERENCES wallet(id)
+);
+
+COMMENT ON COLUMN pockets.id IS 'Unique identifier for each pocket';
+COMMENT ON COLUMN pockets.wallet_id IS 'Foreign key referencing the wallet table';
+COMMENT ON COLUMN pockets.name IS 'Pocket name, unique within the wallet';
+COMMENT ON COLUMN pockets.balance IS 'Money earmarked in the pocket';
+COMMENT ON COLUMN pockets.created_at IS 'Time the pocket was created';
+
+-- Record which pocket a transaction touched; NULL means the main balance
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS pocket_id INT REFERENCES pockets(id);
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_op_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_op_type_check CHECK (op_type IN ('deposit', 'withdraw', 'transfer', 'pocket_move'));
+-- Transaction times are stored in UTC whatever the session time zone
+ALTER TABLE transactions ALTER COLUMN created_at SET DEFAULT (CURRENT_TIMESTAMP AT TIME ZONE 'UTC');
+
+COMMENT ON COLUMN transactions.pocket_id IS 'Pocket the transaction touched, NULL for the main balance';
+
+-- Create the wallet_members table to store who can use a shared wallet and how
+CREATE TABLE IF NOT EXISTS wallet_members (
+    wallet_id INT NOT NULL, -- Foreign key referencing the wallet table
+    user_id VARCHAR(255) NOT NULL, -- Foreign key referencing the users table
+    role VARCHAR(20) CHECK (role IN ('owner', 'spender', 'viewer')) NOT NULL, -- Role of the user on the wallet
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the membership was granted
+    PRIMARY KEY (wallet_id, user_id),
+    FOREIGN KEY (wallet_id) REFERENCES wallet(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+COMMENT ON COLUMN wallet_members.wallet_id IS 'Foreign key referencing the wallet table';
+COMMENT ON COLUMN wallet_members.user_id IS 'Foreign key referencing the users table';
+COMMENT ON COLUMN wallet_members.role IS 'Role of the user on the wallet: owner, spender or viewer';
+COMMENT ON COLUMN wallet_members.created_at IS 'Time the membership was granted';
+
+-- The user that created a wallet is always one of its owners
+INSERT INTO wallet_members (wallet_id, user_id, role) SELECT id, user_id, 'owner' FROM wallet ON CONFLICT (wallet_id, user_id) DO NOTHING;
+
+-- Create the transfer_requests table to store large transfers waiting for approval
+CREATE TABLE IF NOT EXISTS transfer_requests (
+    id SERIAL PRIMARY KEY, -- Unique identifier for each transfer request
+    from_wallet_id INT NOT NULL, -- Wallet the money leaves
+    from_pocket_id INT, -- Pocket the money leaves, NULL for the main balance
+    to_wallet_id INT NOT NULL, -- Wallet the money goes to
+    amount DECIMAL(10, 2) NOT NULL, -- Amount to transfer
+    status VARCHAR(20) CHECK (status IN ('pending', 'approved', 'rejected', 'expired', 'executed', 'failed')) NOT NULL, -- Current state of the request
+    requested_by VARCHAR(255) NOT NULL, -- User that asked for the transfer
+    decided_by VARCHAR(255), -- User that approved or rejected the transfer
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the transfer was requested
+    decided_at TIMESTAMP, -- Time the request left the pending state
+    expires_at TIMESTAMP NOT NULL, -- Pending requests expire at this time
+    FOREIGN KEY (from_wallet_id) REFERENCES wallet(id),
+    FOREIGN KEY (from_pocket_id) REFERENCES pockets(id),
+    FOREIGN KEY (to_wallet_id) REFERENCES wallet(id),
+    FOREIGN KEY (requested_by) REFERENCES users(id)
+);
+
+COMMENT ON COLUMN transfer_requests.id IS 'Unique identifier for each transfer request';
+COMMENT ON COLUMN transfer_requests.from_wallet_id IS 'Wallet the money leaves';
+COMMENT ON COLUMN transfer_requests.from_pocket_id IS 'Pocket the money leaves, NULL for the main balance';
+COMMENT ON COLUMN transfer_requests.to_wallet_id IS 'Wallet the money goes to';
+COMMENT ON COLUMN transfer_requests.amount IS 'Amount to transfer';
+COMMENT ON COLUMN transfer_requests.status IS 'Current state: pending, approved, rejected, expired, executed or failed';
+COMMENT ON COLUMN transfer_requests.requested_by IS 'User that asked for the transfer';
+COMMENT ON COLUMN transfer_requests.decided_by IS 'User that approved or rejected the transfer';
+COMMENT ON COLUMN transfer_requests.created_at IS 'Time the transfer was requested';
+COMMENT ON COLUMN transfer_requests.decided_at IS 'Time the request left the pending state';
+COMMENT ON COLUMN transfer_requests.expires_at IS 'Pending requests expire at this time';
+
+-- Create the transfer_request_events table to record every step of an approval
+CREATE TABLE IF NOT EXISTS transfer_request_events (
+    id SERIAL PRIMARY KEY, -- Unique identifier for each event
+    request_id INT NOT NULL, -- Foreign key referencing the transfer_requests table
+    action VARCHAR(20) NOT NULL, -- Step taken: requested, approved, rejected, expired, executed or failed
+    actor VARCHAR(255) NOT NULL, -- User that took the step, or 'system'
+    note TEXT, -- Optional reason or error message
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the step was taken
+    FOREIGN KEY (request_id) REFERENCES transfer_requests(id)
+);
+
+COMMENT ON COLUMN transfer_request_events.id IS 'Unique identifier for each event';
+COMMENT ON COLUMN transfer_request_events.request_id IS 'Foreign key referencing the transfer_requests table';
+COMMENT ON COLUMN transfer_request_events.action IS 'Step taken: requested, approved, rejected, expired, executed or failed';
+COMMENT ON COLUMN transfer_request_events.actor IS 'User that took the step, or system';
+COMMENT ON COLUMN transfer_request_events.note IS 'Optional reason or error message';
+COMMENT ON COLUMN transfer_request_events.created_at IS 'Time the step was taken';
+
+-- Create the api_keys table to store credentials for machine-to-machine callers
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY, -- Unique identifier for each API key
+    name VARCHAR(255) NOT NULL, -- Human readable name of the integration
+    prefix VARCHAR(16) NOT NULL, -- First characters of the key, used to recognise it
+    key_hash CHAR(64) NOT NULL UNIQUE, -- SHA-256 hash of the key, the key itself is never stored
+    scopes TEXT[] NOT NULL, -- Operations the key may perform
+    wallet_ids INT[] NOT NULL DEFAULT '{}', -- Wallets the key may act on, empty for all wallets
+    rotated_from INT, -- Key this key replaced during rotation
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the key was issued
+    expires_at TIMESTAMP, -- The key stops working at this time, NULL for never
+    revoked_at TIMESTAMP, -- Time the key was revoked
+    FOREIGN KEY (rotated_from) REFERENCES api_keys(id)
+);
+
+COMMENT ON COLUMN api_keys.id IS 'Unique identifier for each API key';
+COMMENT ON COLUMN api_keys.name IS 'Human readable name of the integration';
+COMMENT ON COLUMN api_keys.prefix IS 'First characters of the key, used to recognise it';
+COMMENT ON COLUMN api_keys.key_hash IS 'SHA-256 hash of the key, the key itself is never stored';
+COMMENT ON COLUMN api_keys.scopes IS 'Operations the key may perform';
+COMMENT ON COLUMN api_keys.wallet_ids IS 'Wallets the key may act on, empty for all wallets';
+COMMENT ON COLUMN api_keys.rotated_from IS 'Key this key replaced during rotation';
+COMMENT ON COLUMN api_keys.created_at IS 'Time the key was issued';
+COMMENT ON COLUMN api_keys.expires_at IS 'The key stops working at this time, NULL for never';
+COMMENT ON COLUMN api_keys.revoked_at IS 'Time the key was revoked';
+
+-- Create the rate_limit_buckets table to share token buckets between instances
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key VARCHAR(255) PRIMARY KEY, -- Route and client or wallet the bucket limits
+    tokens DOUBLE PRECISION NOT NULL, -- Tokens left in the bucket
+    updated_at TIMESTAMP NOT NULL -- Time the tokens were last counted
+);
+
+COMMENT ON COLUMN rate_limit_buckets.key IS 'Route and client or wallet the bucket limits';
+COMMENT ON COLUMN rate_limit_buckets.tokens IS 'Tokens left in the bucket';
+COMMENT ON COLUMN rate_limit_buckets.updated_at IS 'Time the tokens were last counted';
+
+-- Create the request_nonces table to share the used nonces of signed requests between instances
+CREATE TABLE IF NOT EXISTS request_nonces (
+    client_id VARCHAR(64) NOT NULL, -- Signing client that sent the nonce
+    nonce VARCHAR(128) NOT NULL, -- Nonce of a signed request
+    expires_at TIMESTAMP NOT NULL, -- The nonce can be used again after this time
+    PRIMARY KEY (client_id, nonce)
+);
+
+COMMENT ON COLUMN request_nonces.client_id IS 'Signing client that sent the nonce';
+COMMENT ON COLUMN request_nonces.nonce IS 'Nonce of a signed request';
+COMMENT ON COLUMN request_nonces.expires_at IS 'The nonce can be used again after this time';
+
+-- Create the webhooks table to store subscriptions to wallet events
+CREATE TABLE IF NOT EXISTS webhooks (
+    id SERIAL PRIMARY KEY, -- Unique identifier for each webhook
+    url TEXT NOT NULL, -- Endpoint the events are posted to
+    event_types TEXT[] NOT NULL, -- Event types the webhook receives
+    secret VARCHAR(255) NOT NULL, -- Shared secret used to sign the payloads
+    wallet_ids INT[] NOT NULL DEFAULT '{}', -- Wallets the webhook receives events for, empty for all wallets
+    active BOOLEAN NOT NULL DEFAULT TRUE, -- Deleted webhooks are deactivated and kept for the delivery log
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP -- Time the webhook was created
+);
+
+COMMENT ON COLUMN webhooks.id IS 'Unique identifier for each webhook';
+COMMENT ON COLUMN webhooks.url IS 'Endpoint the events are posted to';
+COMMENT ON COLUMN webhooks.event_types IS 'Event types the webhook receives';
+COMMENT ON COLUMN webhooks.secret IS 'Shared secret used to sign the payloads';
+COMMENT ON COLUMN webhooks.wallet_ids IS 'Wallets the webhook receives events for, empty for all wallets';
+COMMENT ON COLUMN webhooks.active IS 'Deleted webhooks are deactivated and kept for the delivery log';
+COMMENT ON COLUMN webhooks.created_at IS 'Time the webhook was created';
+
+-- Create the webhook_deliveries table to queue and log every delivery of an event to a webhook
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id SERIAL PRIMARY KEY, -- Unique identifier for each delivery
+    webhook_id INT NOT NULL, -- Foreign key referencing the webhooks table
+    event_id VARCHAR(64) NOT NULL, -- Identifier of the event, the same for every webhook it is delivered to
+    event_type VARCHAR(20) NOT NULL, -- Type of the event
+    payload TEXT NOT NULL, -- JSON body posted to the webhook
+    status VARCHAR(20) CHECK (status IN ('pending', 'delivered', 'dead')) NOT NULL DEFAULT 'pending', -- Current state of the delivery
+    attempts INT NOT NULL DEFAULT 0, -- Number of attempts made so far
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP, -- Pending deliveries are attempted from this time
+    last_status_code INT, -- HTTP status of the last attempt, NULL when no response was received
+    last_error TEXT, -- Error of the last failed attempt
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the event was queued
+    delivered_at TIMESTAMP, -- Time the webhook accepted the event
+    FOREIGN KEY (webhook_id) REFERENCES webhooks(id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+COMMENT ON COLUMN webhook_deliveries.id IS 'Unique identifier for each delivery';
+COMMENT ON COLUMN webhook_deliveries.webhook_id IS 'Foreign key referencing the webhooks table';
+COMMENT ON COLUMN webhook_deliveries.event_id IS 'Identifier of the event, the same for every webhook it is delivered to';
+COMMENT ON COLUMN webhook_deliveries.event_type IS 'Type of the event';
+COMMENT ON COLUMN webhook_deliveries.payload IS 'JSON body posted to the webhook';
+COMMENT ON COLUMN webhook_deliveries.status IS 'Current state: pending, delivered or dead';
+COMMENT ON COLUMN webhook_deliveries.attempts IS 'Number of attempts made so far';
+COMMENT ON COLUMN webhook_deliveries.next_attempt_at IS 'Pending deliveries are attempted from this time';
+COMMENT ON COLUMN webhook_deliveries.last_status_code IS 'HTTP status of the last attempt, NULL when no response was received';
+COMMENT ON COLUMN webhook_deliveries.last_error IS 'Error of the last failed attempt';
+COMMENT ON COLUMN webhook_deliveries.created_at IS 'Time the event was queued';
+COMMENT ON COLUMN webhook_deliveries.delivered_at IS 'Time the webhook accepted the event';
+
+-- Create the outbox_events table to hold wallet events written in the same transaction as the ledger rows
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY, -- Publishing order; events of one wallet are inserted in commit order
+    event_id VARCHAR(64) NOT NULL UNIQUE, -- Identifier of the event, sent to publishers
+    event_type VARCHAR(20) NOT NULL, -- Type of the event
+    wallet_id INT NOT NULL, -- Wallet the event belongs to, the source wallet for transfers
+    to_wallet_id INT, -- Receiving wallet of a transfer
+    payload TEXT NOT NULL, -- JSON encoded event
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- Time the event was committed
+    published_at TIMESTAMP -- Time the relay published the event, NULL until then
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_idx ON outbox_events (id) WHERE published_at IS NULL;
+
+COMMENT ON COLUMN outbox_events.id IS 'Publishing order; events of one wallet are inserted in commit order';
+COMMENT ON COLUMN outbox_events.event_id IS 'Identifier of the event, sent to publishers';
+COMMENT ON COLUMN outbox_events.event_type IS 'Type of the event';
+COMMENT ON COLUMN outbox_events.wallet_id IS 'Wallet the event belongs to, the source wallet for transfers';
+COMMENT ON COLUMN outbox_events.to_wallet_id IS 'Receiving wallet of a transfer';
+COMMENT ON COLUMN outbox_events.payload IS 'JSON encoded event';
+COMMENT ON COLUMN outbox_events.created_at IS 'Time the event was committed';
+COMMENT ON COLUMN outbox_events.published_at IS 'Time the relay published the event, NULL until then';
+
+-- Notify listeners on the wallet_events channel when a transaction is committed, so every app instance can push it to its balance streams
+CREATE OR REPLACE FUNCTION notify_wallet_transaction() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('wallet_events', json_build_object(
+        'wallet_id', NEW.wallet_id,
+        'transaction', json_build_object(
+            'id', NEW.id,
+            'wallet_id', NEW.wallet_id,
+            'pocket_id', NEW.pocket_id,
+            'op_type', NEW.op_type,
+            'amount', NEW.amount,
+            -- created_at is stored in UTC
+            'created_at', to_char(NEW.created_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+        )
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_notify ON transactions;
+CREATE TRIGGER transactions_notify AFTER INSERT ON transactions
+    FOR EACH ROW EXECUTE PROCEDURE notify_wallet_transaction();
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 默认的路由超时，超时后正在执行的语句被中断，事务回滚
+// 写操作要等待钱包的行锁，给的时间比查询长
+var defaultRouteTimeouts = map[string]time.Duration{
+	"balance":          5 * time.Second,
+	"transactions":     5 * time.Second,
+	"deposit_withdraw": 10 * time.Second,
+	"transfer":         10 * time.Second,
+	"approve":          10 * time.Second,
+}
+
+// 解析 JSON 格式的路由超时，如 {"transfer":"30s"}，未指定的路由使用默认值，0 表示不限时
+func parseRouteTimeouts(config string) (map[string]time.Duration, error) {
+	var overrides map[string]string
+	if err := json.Unmarshal([]byte(config), &overrides); err != nil {
+		return nil, err
+	}
+	timeouts := map[string]time.Duration{}
+	for route, timeout := range defaultRouteTimeouts {
+		timeouts[route] = timeout
+	}
+	for route, v := range overrides {
+		if _, ok := defaultRouteTimeouts[route]; !ok {
+			return nil, fmt.Errorf("unknown route %q", route)
+		}
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %v", route, err)
+		}
+		if timeout < 0 {
+			return nil, fmt.Errorf("route %q: timeout must not be negative", route)
+		}
+		timeouts[route] = timeout
+	}
+	return timeouts, nil
+}
+
+// 按路由的超时设置 ctx 的截止时间，调用方已设置更早的截止时间时以调用方为准，HTTP 和 gRPC 共用
+func (a *App) withRouteDeadline(ctx context.Context, route string) (context.Context, context.CancelFunc) {
+	timeouts := a.RouteTimeouts
+	if timeouts == nil {
+		timeouts = defaultRouteTimeouts
+	}
+	if timeout := timeouts[route]; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// 请求的 ctx 在路由超时或客户端断开时取消
+func (a *App) deadline(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := a.withRouteDeadline(c.Request.Context(), route)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 转账一直等待钱包的锁，直到 ctx 结束
+type MockWalletLockedRepo struct {
+	MockWalletRepo
+}
+
+func (m *MockWalletLockedRepo) WithTx(ctx context.Context, fn func(ctx context.Context, uow *UnitOfWork) error) error {
+	return fn(ctx, &UnitOfWork{Wallet: m})
+}
+
+func (m *MockWalletLockedRepo) ExecTransfer(ctx context.Context, fromWalletID, toWalletID int64, amount float64) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestParseRouteTimeouts(t *testing.T) {
+	timeouts, err := parseRouteTimeouts(`{"transfer":"30s","balance":"0s"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, timeouts["transfer"])
+	assert.Equal(t, time.Duration(0), timeouts["balance"])
+	assert.Equal(t, defaultRouteTimeouts["deposit_withdraw"], timeouts["deposit_withdraw"])
+
+	for _, config := range []string{`{"refund":"1s"}`, `{"transfer":"soon"}`, `{"transfer":"-1s"}`, `[]`} {
+		_, err := parseRouteTimeouts(config)
+		assert.Error(t, err, config)
+	}
+}
+
+func TestTransferHandler_Deadline(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeouts       map[string]time.Duration
+		cancel         bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Route deadline",
+			timeouts:       map[string]time.Duration{"transfer": 20 * time.Millisecond},
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedBody:   `{"error":"context deadline exceeded"}`,
+		},
+		{
+			name:           "Client disconnected",
+			timeouts:       map[string]time.Duration{"transfer": time.Minute},
+			cancel:         true,
+			expectedStatus: 499,
+			expectedBody:   `{"error":"context canceled"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new Gin router
+			router := gin.Default()
+			router.Use(asCaller("user1"))
+
+			// Initialize the app and set up the route
+			a := App{Rp: &MockWalletLockedRepo{}, Mb: &MockMemberRepo{}, RouteTimeouts: tt.timeouts}
+			router.POST("/api/transfer", a.deadline("transfer"), a.transferHandler)
+
+			// Create a new HTTP request with the test route
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, "POST", "/api/transfer", bytes.NewBufferString(`{"from_wallet_id":1,"to_wallet_id":2,"amount":10}`))
+			if tt.cancel {
+				time.AfterFunc(20*time.Millisecond, cancel)
+			}
+			// Create a new HTTP response recorder
+			w := httptest.NewRecorder()
+			// Perform the HTTP request
+			router.ServeHTTP(w, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.JSONEq(t, tt.expectedBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 测试用的证书，parent 为 nil 时自签名
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	require.NoError(t, err)
+	return cert
+}
+
+// 写入证书和私钥，修改时间设为 mtime
+func writeCertFiles(t *testing.T, dir string, c *testCert, mtime time.Time) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(certFile, c.certPEM(), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, c.keyPEM(t), 0o600))
+	require.NoError(t, os.Chtimes(certFile, mtime, mtime))
+	require.NoError(t, os.Chtimes(keyFile, mtime, mtime))
+	return certFile, keyFile
+}
+
+// 在随机端口上以 TLS 提供服务
+func serveTLS(t *testing.T, handler http.Handler, cfg *tls.Config) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: handler, TLSConfig: cfg}
+	go func() { _ = srv.ServeTLS(ln, "", "") }()
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := newTestCert(t, "Test CA", 1, nil)
+	dir := t.TempDir()
+	certFile, keyFile := writeCertFiles(t, dir, newTestCert(t, "wallet", 2, ca), time.Now().Add(-time.Minute))
+
+	certs, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	url := serveTLS(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), certs.TLSConfig("none"))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	// 每次请求使用新的连接，确认新连接使用的证书
+	servedSerial := func() int64 {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, DisableKeepAlives: true}}
+		resp, err := client.Get(url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	assert.Equal(t, int64(2), servedSerial())
+
+	// 文件没有变化时不重新加载
+	reloaded, err := certs.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeCertFiles(t, dir, newTestCert(t, "wallet", 3, ca), time.Now())
+	reloaded, err = certs.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, int64(3), servedSerial())
+
+	// 文件损坏时继续使用之前的证书
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	require.NoError(t, os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute)))
+	_, err = certs.reload()
+	assert.Error(t, err)
+	assert.Equal(t, int64(3), servedSerial())
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "Test CA", 1, nil)
+	otherCA := newTestCert(t, "Other CA", 2, nil)
+	dir := t.TempDir()
+	certFile, keyFile := writeCertFiles(t, dir, newTestCert(t, "wallet", 3, ca), time.Now())
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, ca.certPEM(), 0o600))
+
+	// Create a new Gin router
+	router := gin.New()
+
+	// Initialize the app and set up the route
+	a := App{Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.GET("/whoami", func(c *gin.Context) {
+		subject := ""
+		if caller, ok := callerFrom(c); ok {
+			subject = caller.CertSubject
+		}
+		c.JSON(http.StatusOK, gin.H{"caller_cert": subject})
+	})
+
+	tests := []struct {
+		name           string
+		clientAuth     string
+		clientCert     *testCert
+		expectedError  bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "Verified client certificate",
+			clientAuth:     "require",
+			clientCert:     newTestCert(t, "billing", 4, ca),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"caller_cert":"CN=billing,O=Example"}`,
+		},
+		{
+			name:          "Missing client certificate",
+			clientAuth:    "require",
+			expectedError: true,
+		},
+		{
+			name:          "Client certificate from another CA",
+			clientAuth:    "optional",
+			clientCert:    newTestCert(t, "billing", 5, otherCA),
+			expectedError: true,
+		},
+		{
+			name:           "Optional client certificate",
+			clientAuth:     "optional",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"caller_cert":""}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certs, err := newCertReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+			require.NoError(t, err)
+			url := serveTLS(t, router, certs.TLSConfig(tt.clientAuth))
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca.cert)
+			clientTLS := &tls.Config{RootCAs: roots}
+			if tt.clientCert != nil {
+				// 不管服务端接受哪些 CA 都发送证书
+				cert := tt.clientCert.tlsCertificate(t)
+				clientTLS.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil }
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("GET", url+"/whoami", nil)
+			req.Header.Set("Authorization", bearer("user1"))
+			// Perform the HTTP request
+			resp, err := client.Do(req)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBody, string(body))
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			},
+			{
+				"kty": "oct",
+				"kid": "hmac",
+				"k":   base64.RawURLEncoding.EncodeToString(testSecret),
+			},
+		},
+	})
+	v, err := NewTokenVerifier(jwks, "wallet-issuer", "wallet")
+	if err != nil {
+		t.Fatalf("failed to load key set: %s", err)
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %s", err)
+		}
+		return signed
+	}
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "user1", "iss": "wallet-issuer", "aud": "wallet", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range overrides {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+
+	// Test cases
+	tests := []struct {
+		name          string
+		token         string
+		expectedSub   string
+		expectedRoles []string
+		expectedError error
+	}{
+		{
+			name:        "RSA Token",
+			token:       sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)),
+			expectedSub: "user1",
+		},
+		{
+			name:        "HMAC Token",
+			token:       sign(jwt.SigningMethodHS256, "hmac", testSecret, claims(nil)),
+			expectedSub: "user1",
+		},
+		{
+			name:          "Roles Claim",
+			token:         sign(jwt.SigningMethodHS256, "hmac", testSecret, claims(jwt.MapClaims{"roles": []string{"admin"}})),
+			expectedSub:   "user1",
+			expectedRoles: []string{"admin"},
+		},
+		{
+			name:          "Expired",
+			token:         sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "No Expiry",
+			token:         sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": nil})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "Wrong Issuer",
+			token:         sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"iss": "someone-else"})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "Wrong Audience",
+			token:         sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"aud": "other"})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "No Subject",
+			token:         sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"sub": nil})),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "Unknown Key",
+			token:         sign(jwt.SigningMethodHS256, "missing", testSecret, claims(nil)),
+			expectedError: ErrInvalidToken,
+		},
+		{
+			name:          "HMAC Signed With RSA Key ID",
+			token:         sign(jwt.SigningMethodHS256, "rsa", testSecret, claims(nil)),
+			expectedError: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caller, err := v.Verify(tt.token)
+			assert.Equal(t, tt.expectedError, err)
+			if tt.expectedError == nil {
+				assert.Equal(t, tt.expectedSub, caller.UserID)
+				assert.Equal(t, tt.expectedRoles, caller.Roles)
+			}
+		})
+	}
+}
+
+// 仓库中的示例 JWKS 可以直接用于本地运行
+func TestJWKSExample(t *testing.T) {
+	jwks, err := os.ReadFile("jwks.example.json")
+	if err != nil {
+		t.Fatalf("failed to read jwks.example.json: %s", err)
+	}
+	verifier, err := NewTokenVerifier(jwks, "", "")
+	if err != nil {
+		t.Fatalf("failed to load jwks.example.json: %s", err)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(jwks, &set); err != nil {
+		t.Fatalf("failed to parse jwks.example.json: %s", err)
+	}
+	secret, _ := base64.RawURLEncoding.DecodeString(set.Keys[0].K)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user1", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = set.Keys[0].Kid
+	signed, _ := token.SignedString(secret)
+
+	principal, err := verifier.Verify(signed)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", principal.UserID)
+}
+
+func TestNewTokenVerifier_Invalid(t *testing.T) {
+	_, err := NewTokenVerifier([]byte(`{"keys":[]}`), "", "")
+	assert.Error(t, err)
+
+	_, err = NewTokenVerifier([]byte(`{"keys":[{"kty":"EC","kid":"ec"}]}`), "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 在 setupTracing 之前获取也会使用之后设置的 TracerProvider
+var tracer = otel.Tracer("wallet")
+
+// 按 exporter 设置全局的 TracerProvider 和 W3C trace context 传播，返回的函数导出剩余的 span
+// exporter 为 none 时不导出，但仍然沿用请求头中的 trace ID
+func setupTracing(exporter, file, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var closeFile func() error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		spanExporter = e
+	case "file":
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		spanExporter, closeFile = e, f.Close
+	case "otlp":
+		// 地址等配置读取标准的 OTEL_EXPORTER_OTLP_* 环境变量
+		e, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		spanExporter = e
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", exporter)
+	}
+
+	if serviceName == "" {
+		serviceName = "wallet"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closeFile != nil {
+			if cerr := closeFile(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}, nil
+}
+
+// 为每个请求创建 server span，父 span 取自请求头的 traceparent，trace ID 同时写入请求日志
+func traceRequest(c *gin.Context) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	route := c.FullPath()
+	name := c.Request.Method + " " + route
+	if route == "" {
+		name = c.Request.Method
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(c.Request.Method),
+		semconv.HTTPRoute(route),
+		semconv.URLPath(c.Request.URL.Path),
+	))
+	defer span.End()
+	if sc := span.SpanContext(); sc.HasTraceID() {
+		setLogAttrs(ctx, slog.String("trace_id", sc.TraceID().String()))
+	}
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+}
+
+// 在 span 中执行一个步骤，如加锁、提交，出错时记录到 span
+func traceStep(ctx context.Context, name string, fn func() error, attrs ...trace.SpanStartOption) error {
+	_, span := tracer.Start(ctx, name, attrs...)
+	defer span.End()
+	err := fn()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// 在事务中执行一条 SQL 语句，每条语句一个 client span
+func tracedExec(ctx context.Context, tx *sql.Tx, name, query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := traceStep(ctx, name, func() error {
+		var err error
+		result, err = tx.ExecContext(ctx, query, args...)
+		return err
+	}, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBQueryText(query)))
+	return result, err
+}
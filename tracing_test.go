@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	spanExporter     = tracetest.NewInMemoryExporter()
+	setupSpanCapture sync.Once
+)
+
+// 把全局 TracerProvider 设为内存 exporter，只能设置一次
+func capturedSpans(t *testing.T) *tracetest.InMemoryExporter {
+	setupSpanCapture.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spanExporter)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	spanExporter.Reset()
+	return spanExporter
+}
+
+func TestTraceRequest_Transfer(t *testing.T) {
+	spans := capturedSpans(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(1, 100.0, "user1"))
+	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(2, 0.0, "user2"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, balance, user_id FROM wallet WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "user_id"}).AddRow(1, 100.0, "user1"))
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT 1 FROM wallet WHERE id = \\$1 FOR UPDATE").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE wallet SET balance = balance - \\$1 WHERE id = \\$2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE wallet SET balance = balance \\+ \\$1 WHERE id = \\$2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO outbox_events").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Create a new Gin router
+	router := gin.New()
+	router.Use(traceRequest, asCaller("user1"))
+
+	// Initialize the app and set up the route
+	a := App{DB: db, Rp: instrumentWallet(&WalletAccess{DB: db}), Mb: &MockMemberRepo{}}
+	router.POST("/api/transfer", a.transferHandler)
+
+	// Create a new HTTP request with the test route
+	req, _ := http.NewRequest("POST", "/api/transfer", bytes.NewBufferString(`{"from_wallet_id":1,"to_wallet_id":2,"amount":10}`))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	// Create a new HTTP response recorder
+	w := httptest.NewRecorder()
+	// Perform the HTTP request
+	router.ServeHTTP(w, req)
+
+	// Assert that the response status code is as expected
+	assert.Equal(t, http.StatusOK, w.Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	byName := map[string]tracetest.SpanStub{}
+	var names []string
+	for _, span := range spans.GetSpans() {
+		// 所有 span 沿用请求头中的 trace ID
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+		byName[span.Name] = span
+		names = append(names, span.Name)
+	}
+	assert.ElementsMatch(t, []string{
+		"IWallet.GetWalletInfoById", "IWallet.GetWalletInfoById",
+		"lock wallets", "update wallet", "update wallet", "insert transactions", "insert transactions", "insert outbox_events", "commit",
+		"IWallet.WithTx", "IWallet.LockWallet", "IWallet.ExecTransfer",
+		"POST /api/transfer",
+	}, names)
+
+	server := byName["POST /api/transfer"]
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+	assert.Equal(t, server.SpanContext.SpanID(), byName["IWallet.WithTx"].Parent.SpanID())
+	assert.Equal(t, server.SpanContext.SpanID(), byName["IWallet.GetWalletInfoById"].Parent.SpanID())
+	// 事务中的操作挂在事务的 span 下，提交在所有操作之后
+	for _, name := range []string{"IWallet.LockWallet", "IWallet.ExecTransfer", "commit"} {
+		assert.Equal(t, byName["IWallet.WithTx"].SpanContext.SpanID(), byName[name].Parent.SpanID(), name)
+	}
+	for _, name := range []string{"lock wallets", "update wallet", "insert transactions", "insert outbox_events"} {
+		assert.Equal(t, byName["IWallet.ExecTransfer"].SpanContext.SpanID(), byName[name].Parent.SpanID(), name)
+	}
+}
+
+func TestSetupTracing_File(t *testing.T) {
+	// 其他测试使用的全局 TracerProvider 在结束后恢复
+	capturedSpans(t)
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := setupTracing("file", path, "wallet-test")
+	require.NoError(t, err)
+
+	_, span := otel.GetTracerProvider().Tracer("test").Start(context.Background(), "test span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"test span"`)
+	assert.Contains(t, string(data), `"Value":"wallet-test"`)
+
+	_, err = setupTracing("jaeger", "", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+)
+
+type UserAccess struct{}
+
+// 创建用户
+func (ua *UserAccess) CreateUser(ctx context.Context, db *sql.DB, user *User) error {
+	err := db.QueryRowContext(ctx, "INSERT INTO users (id, name, email) VALUES ($1, $2, NULLIF($3, '')) RETURNING created_at",
+		user.ID, user.Name, user.Email).Scan(&user.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrUserExists
+		}
+		return err
+	}
+	return nil
+}
+
+// 根据用户 ID 获取用户信息
+func (ua *UserAccess) GetUserById(ctx context.Context, db *sql.DB, userID string) (*User, error) {
+	var user User
+	err := db.QueryRowContext(ctx, "SELECT id, name, COALESCE(email, ''), created_at FROM users WHERE id = $1", userID).
+		Scan(&user.ID, &user.Name, &user.Email, &user.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// 获取用户的全部钱包，余额包含子账户
+func (ua *UserAccess) GetWalletsByUserID(ctx context.Context, db *sql.DB, userID string) ([]Wallet, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT w.id, w.balance + COALESCE((SELECT SUM(p.balance) FROM pockets p WHERE p.wallet_id = w.id), 0), w.user_id
+		FROM wallet w
+		WHERE w.user_id = $1
+		ORDER BY w.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wallets []Wallet
+	for rows.Next() {
+		var wallet Wallet
+		if err := rows.Scan(&wallet.ID, &wallet.Balance, &wallet.UserID); err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return wallets, nil
+}
+
+// 为用户创建新钱包，并将用户登记为钱包的 owner
+func (ua *UserAccess) CreateWallet(ctx context.Context, db *sql.DB, userID string) (*Wallet, error) {
+	wallet := Wallet{UserID: userID}
+	err := db.QueryRowContext(ctx, `
+		WITH w AS (
+			INSERT INTO wallet (user_id) VALUES ($1) RETURNING id, balance
+		), m AS (
+			INSERT INTO wallet_members (wallet_id, user_id, role) SELECT id, $1, 'owner' FROM w
+		)
+		SELECT id, balance FROM w
+	`, userID).Scan(&wallet.ID, &wallet.Balance)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &wallet, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestCreateUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO users \\(id, name, email\\) VALUES \\(\\$1, \\$2, NULLIF\\(\\$3, ''\\)\\) RETURNING created_at").
+		WithArgs("user3", "User Three", "").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+
+	ua := &UserAccess{}
+	user := User{ID: "user3", Name: "User Three"}
+	if err := ua.CreateUser(context.Background(), db, &user); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if !user.CreatedAt.Equal(now) {
+		t.Errorf("expected created_at %v, got %v", now, user.CreatedAt)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateUser_Exists(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("user1", "User One", "").
+		WillReturnError(&pq.Error{Code: "23505"})
+
+	ua := &UserAccess{}
+	if err := ua.CreateUser(context.Background(), db, &User{ID: "user1", Name: "User One"}); err != ErrUserExists {
+		t.Errorf("expected ErrUserExists, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetUserById_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, COALESCE\\(email, ''\\), created_at FROM users WHERE id = \\$1").
+		WithArgs("nobody").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "created_at"}))
+
+	ua := &UserAccess{}
+	if _, err := ua.GetUserById(context.Background(), db, "nobody"); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetWalletsByUserID(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "balance", "user_id"}).
+		AddRow(1, 100.0, "user1").
+		AddRow(3, 20.0, "user1")
+	mock.ExpectQuery("SELECT w.id, .* FROM wallet w WHERE w.user_id = \\$1 ORDER BY w.id").
+		WithArgs("user1").
+		WillReturnRows(rows)
+
+	ua := &UserAccess{}
+	wallets, err := ua.GetWalletsByUserID(context.Background(), db, "user1")
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(wallets) != 2 {
+		t.Errorf("expected 2 wallets, got %d", len(wallets))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateWallet_UnknownUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO wallet \\(user_id\\) VALUES \\(\\$1\\) RETURNING id, balance .* INSERT INTO wallet_members").
+		WithArgs("nobody").
+		WillReturnError(&pq.Error{Code: "23503"})
+
+	ua := &UserAccess{}
+	if _, err := ua.CreateWallet(context.Background(), db, "nobody"); err != ErrUserNotFound {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserRequest struct {
+	Id string `uri:"id" binding:"required"`
+}
+
+// 创建用户
+func (a *App) createUserHandler(c *gin.Context) {
+	var request struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.ID == "" || len(request.ID) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+	if request.Name == "" || len(request.Name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user name"})
+		return
+	}
+	if !a.authorizeUser(c, request.ID) {
+		return
+	}
+
+	user := User{ID: request.ID, Name: request.Name, Email: request.Email}
+	if err := a.Us.CreateUser(c.Request.Context(), a.DB, &user); err != nil {
+		if err == ErrUserExists {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"user": user})
+}
+
+// 获取用户信息
+func (a *App) getUserHandler(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !a.authorizeUser(c, req.Id) {
+		return
+	}
+
+	user, err := a.Us.GetUserById(c.Request.Context(), a.DB, req.Id)
+	if err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// 查询用户的全部钱包
+func (a *App) getUserWalletsHandler(c *gin.Context) {
+	wallets, ok := a.loadUserWallets(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"wallets": wallets})
+}
+
+// 为用户创建新钱包
+func (a *App) createUserWalletHandler(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !a.authorizeUser(c, req.Id) {
+		return
+	}
+
+	wallet, err := a.Us.CreateWallet(c.Request.Context(), a.DB, req.Id)
+	if err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"wallet": wallet})
+}
+
+// 查询用户所有钱包的合计余额
+func (a *App) getUserBalanceHandler(c *gin.Context) {
+	wallets, ok := a.loadUserWallets(c)
+	if !ok {
+		return
+	}
+
+	var total float64
+	for _, wallet := range wallets {
+		total += wallet.Balance
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": c.Param("id"),
+		"balance": total,
+		"wallets": wallets,
+	})
+}
+
+// 校验用户存在并获取其钱包，失败时已写入响应
+func (a *App) loadUserWallets(c *gin.Context) ([]Wallet, bool) {
+	var req UserRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !a.authorizeUser(c, req.Id) {
+		return nil, false
+	}
+
+	if _, err := a.Us.GetUserById(c.Request.Context(), a.DB, req.Id); err != nil {
+		if err == ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+
+	wallets, err := a.Us.GetWalletsByUserID(c.Request.Context(), a.DB, req.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if wallets == nil {
+		wallets = []Wallet{}
+	}
+	return wallets, true
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// user1 拥有钱包 1 和 3，user2 没有钱包
+type MockUserRepo struct{}
+
+func (m *MockUserRepo) CreateUser(ctx context.Context, db *sql.DB, user *User) error {
+	if user.ID == "user1" {
+		return ErrUserExists
+	}
+	user.CreatedAt = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	return nil
+}
+
+func (m *MockUserRepo) GetUserById(ctx context.Context, db *sql.DB, userID string) (*User, error) {
+	switch userID {
+	case "user1", "user2":
+		return &User{ID: userID, Name: userID, CreatedAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, nil
+	}
+	return nil, ErrUserNotFound
+}
+
+func (m *MockUserRepo) GetWalletsByUserID(ctx context.Context, db *sql.DB, userID string) ([]Wallet, error) {
+	if userID == "user1" {
+		return []Wallet{
+			{ID: 1, Balance: 100.0, UserID: "user1"},
+			{ID: 3, Balance: 20.0, UserID: "user1"},
+		}, nil
+	}
+	return nil, nil
+}
+
+func (m *MockUserRepo) CreateWallet(ctx context.Context, db *sql.DB, userID string) (*Wallet, error) {
+	if _, err := m.GetUserById(ctx, db, userID); err != nil {
+		return nil, err
+	}
+	return &Wallet{ID: 4, UserID: userID}, nil
+}
+
+func TestCreateUserHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Us: &MockUserRepo{}, Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.POST("/api/users", a.createUserHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		caller         string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Create User Success",
+			requestBody:    map[string]interface{}{"id": "user3", "name": "User Three", "email": "three@example.com"},
+			expectedStatus: http.StatusCreated,
+			expectedBody: map[string]interface{}{
+				"user": map[string]interface{}{"id": "user3", "name": "User Three", "email": "three@example.com", "created_at": "2024-06-01T00:00:00Z"},
+			},
+		},
+		{
+			name:           "User Exists",
+			requestBody:    map[string]interface{}{"id": "user1", "name": "User One"},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   map[string]interface{}{"error": "user already exists"},
+		},
+		{
+			name:           "Create Another User",
+			caller:         "user1",
+			requestBody:    map[string]interface{}{"id": "user3", "name": "User Three"},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]interface{}{"error": "forbidden"},
+		},
+		{
+			name:           "Missing Id",
+			requestBody:    map[string]interface{}{"name": "User Three"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid user id"},
+		},
+		{
+			name:           "Missing Name",
+			requestBody:    map[string]interface{}{"id": "user3"},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   map[string]interface{}{"error": "invalid user name"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a test request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+
+			// Create a new HTTP request with the test route and request body
+			req, _ := http.NewRequest("POST", "/api/users", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.caller != "" {
+				req.Header.Set("Authorization", bearer(tt.caller))
+			} else if id, ok := tt.requestBody["id"].(string); ok {
+				req.Header.Set("Authorization", bearer(id))
+			}
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
+
+func TestUserReadHandlers(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Us: &MockUserRepo{}, Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.GET("/api/users/:id", a.getUserHandler)
+	router.GET("/api/users/:id/wallets", a.getUserWalletsHandler)
+	router.GET("/api/users/:id/balance", a.getUserBalanceHandler)
+
+	wallets := []interface{}{
+		map[string]interface{}{"id": 1.0, "balance": 100.0, "user_id": "user1"},
+		map[string]interface{}{"id": 3.0, "balance": 20.0, "user_id": "user1"},
+	}
+
+	// Test cases
+	tests := []struct {
+		name           string
+		caller         string
+		path           string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Get User Success",
+			caller:         "user1",
+			path:           "/api/users/user1",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"user": map[string]interface{}{"id": "user1", "name": "user1", "email": "", "created_at": "2024-06-01T00:00:00Z"},
+			},
+		},
+		{
+			name:           "User Not Found",
+			caller:         "nobody",
+			path:           "/api/users/nobody",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "user not found"},
+		},
+		{
+			name:           "List Wallets",
+			caller:         "user1",
+			path:           "/api/users/user1/wallets",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"wallets": wallets},
+		},
+		{
+			name:           "List Wallets Empty",
+			caller:         "user2",
+			path:           "/api/users/user2/wallets",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"wallets": []interface{}{}},
+		},
+		{
+			name:           "List Wallets Unknown User",
+			caller:         "nobody",
+			path:           "/api/users/nobody/wallets",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "user not found"},
+		},
+		{
+			name:           "Other User's Wallets",
+			caller:         "user2",
+			path:           "/api/users/user1/wallets",
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   map[string]interface{}{"error": "forbidden"},
+		},
+		{
+			name:           "Consolidated Balance",
+			caller:         "user1",
+			path:           "/api/users/user1/balance",
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"user_id": "user1", "balance": 120.0, "wallets": wallets},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			req.Header.Set("Authorization", bearer(tt.caller))
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
+
+func TestCreateUserWalletHandler(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	a := App{Us: &MockUserRepo{}, Tokens: testTokens}
+	router.Use(a.authMiddleware)
+	router.POST("/api/users/:id/wallets", a.createUserWalletHandler)
+
+	// Test cases
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Create Wallet Success",
+			id:             "user2",
+			expectedStatus: http.StatusCreated,
+			expectedBody: map[string]interface{}{
+				"wallet": map[string]interface{}{"id": 4.0, "balance": 0.0, "user_id": "user2"},
+			},
+		},
+		{
+			name:           "Unknown User",
+			id:             "nobody",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   map[string]interface{}{"error": "user not found"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new HTTP request with the test route
+			req, _ := http.NewRequest("POST", "/api/users/"+tt.id+"/wallets", nil)
+			req.Header.Set("Authorization", bearer(tt.id))
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			// Assert that the response body is as expected
+			var responseBody map[string]interface{}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedBody, responseBody)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// 钱包余额、存取款、转账和交易记录的业务逻辑，HTTP 和 gRPC 接口共用
+// 调用者为 nil 表示请求未认证，返回的错误按 errors.go 中的表映射状态码
+
+// 钱包的总余额、主余额和各子账户余额
+type WalletBalance struct {
+	Balance     float64  `json:"balance"`
+	MainBalance float64  `json:"main_balance"`
+	Pockets     []Pocket `json:"pockets"`
+}
+
+// 加载钱包并检查调用者的权限，未认证的请求在查询钱包之前拒绝
+func (a *App) loadWalletFor(ctx context.Context, caller *Principal, walletID int64, perm string) (*Wallet, error) {
+	if caller == nil {
+		return nil, ErrUnauthenticated
+	}
+	wallet, err := a.Rp.GetWalletInfoById(ctx, walletID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkWalletPermission(ctx, caller, wallet, perm); err != nil {
+		return nil, err
+	}
+	return wallet, nil
+}
+
+// 查询钱包的当前余额，总余额为主余额加上各子账户余额
+func (a *App) getWalletBalance(ctx context.Context, caller *Principal, walletID int64) (*WalletBalance, error) {
+	setLogAttrs(ctx, slog.Int64("wallet_id", walletID))
+	wallet, err := a.loadWalletFor(ctx, caller, walletID, PermReadBalance)
+	if err != nil {
+		return nil, err
+	}
+	return a.balanceOf(ctx, wallet)
+}
+
+// 钱包的当前余额，不检查权限
+func (a *App) balanceOf(ctx context.Context, wallet *Wallet) (*WalletBalance, error) {
+	pockets, err := a.Pk.GetPocketsByWalletID(ctx, a.DB, wallet.ID)
+	if err != nil {
+		return nil, err
+	}
+	if pockets == nil {
+		pockets = []Pocket{}
+	}
+
+	balance := &WalletBalance{Balance: wallet.Balance, MainBalance: wallet.Balance, Pockets: pockets}
+	for _, pocket := range pockets {
+		balance.Balance += pocket.Balance
+	}
+	return balance, nil
+}
+
+// 按交易记录计算钱包在指定时间的余额，与当前余额的结构相同，主余额为总余额减去各子账户余额
+func (a *App) getWalletBalanceAt(ctx context.Context, caller *Principal, walletID int64, at time.Time) (*WalletBalance, error) {
+	setLogAttrs(ctx, slog.Int64("wallet_id", walletID))
+	wallet, err := a.loadWalletFor(ctx, caller, walletID, PermReadBalance)
+	if err != nil {
+		return nil, err
+	}
+	total, err := a.Rp.GetBalanceAt(ctx, wallet.ID, at)
+	if err != nil {
+		return nil, err
+	}
+	pockets, err := a.Pk.GetPocketsAt(ctx, a.DB, wallet.ID, at)
+	if err != nil {
+		return nil, err
+	}
+	if pockets == nil {
+		pockets = []Pocket{}
+	}
+
+	balance := &WalletBalance{Balance: total, MainBalance: total, Pockets: pockets}
+	for _, pocket := range pockets {
+		balance.MainBalance -= pocket.Balance
+	}
+	return balance, nil
+}
+
+// 存款或取款，指定子账户时从子账户取款，否则只操作主余额
+func (a *App) depositWithdraw(ctx context.Context, caller *Principal, walletID int64, opType string, amount float64, pocketID int64) error {
+	setLogAttrs(ctx, slog.Int64("wallet_id", walletID), slog.String("op_type", opType))
+	if pocketID != 0 {
+		setLogAttrs(ctx, slog.Int64("pocket_id", pocketID))
+	}
+	if opType != "deposit" && opType != "withdraw" {
+		return newAPIError(CodeInvalidArgument, "invalid operation type")
+	}
+	if amount <= 0 {
+		return newAPIError(CodeInvalidArgument, "amount must be positive")
+	}
+	if pocketID != 0 && opType != "withdraw" {
+		return newAPIError(CodeInvalidArgument, "pocket_id is only supported for withdraw")
+	}
+
+	perm := PermDeposit
+	if opType == "withdraw" {
+		perm = PermWithdraw
+	}
+	wallet, err := a.loadWalletFor(ctx, caller, walletID, perm)
+	if err != nil {
+		return err
+	}
+
+	if pocketID != 0 {
+		err = a.Pk.WithdrawFromPocket(ctx, a.DB, wallet.ID, pocketID, amount)
+	} else if opType == "withdraw" {
+		// 锁定钱包后检查余额再扣款，并发取款不会透支
+		err = a.Rp.WithTx(ctx, func(ctx context.Context, uow *UnitOfWork) error {
+			locked, err := uow.Wallet.LockWallet(ctx, wallet.ID)
+			if err != nil {
+				return err
+			}
+			if locked.Balance < amount {
+				return ErrNotEnough
+			}
+			return uow.Wallet.UpdateBalance(ctx, wallet.ID, opType, -amount)
+		})
+	} else {
+		err = a.Rp.UpdateBalance(ctx, wallet.ID, opType, amount)
+	}
+	recordWalletOperation(opType, amount, err)
+	return err
+}
+
+// 转账，超过审批阈值时创建待审批的转账并返回，否则直接执行并返回 nil
+func (a *App) transfer(ctx context.Context, caller *Principal, fromWalletID, fromPocketID, toWalletID int64, amount float64) (*TransferRequest, error) {
+	setLogAttrs(ctx, slog.Int64("wallet_id", fromWalletID), slog.Int64("to_wallet_id", toWalletID), slog.String("op_type", "transfer"))
+	if fromPocketID != 0 {
+		setLogAttrs(ctx, slog.Int64("pocket_id", fromPocketID))
+	}
+	if amount <= 0 {
+		return nil, newAPIError(CodeInvalidArgument, "transfer amount must be positive")
+	}
+	if fromWalletID <= 0 || toWalletID <= 0 || fromWalletID == toWalletID {
+		return nil, newAPIError(CodeInvalidArgument, "invalid wallet id")
+	}
+
+	if caller == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	// 转出钱包不存在和无权访问返回相同的错误
+	fromWallet, err := a.Rp.GetWalletInfoById(ctx, fromWalletID)
+	if err == nil {
+		err = a.checkWalletPermission(ctx, caller, fromWallet, PermTransfer)
+	}
+	if err == ErrWalletNotFound {
+		return nil, newAPIError(CodeNotFound, "from wallet not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 转入钱包不存在时直接拒绝，不创建审批
+	if _, err := a.Rp.GetWalletInfoById(ctx, toWalletID); err != nil {
+		if err == ErrWalletNotFound {
+			return nil, newAPIError(CodeNotFound, "to wallet not found")
+		}
+		return nil, err
+	}
+
+	// 超过阈值的转账先进入审批，由另一位 owner 审批通过后执行，审批记录的发起人必须是用户
+	if a.ApprovalThreshold > 0 && amount > a.ApprovalThreshold {
+		if caller.isService() {
+			return nil, newAPIError(CodePermissionDenied, "transfers above the approval threshold must be requested by a user")
+		}
+		tr := TransferRequest{
+			FromWalletID: fromWallet.ID,
+			FromPocketID: fromPocketID,
+			ToWalletID:   toWalletID,
+			Amount:       amount,
+			RequestedBy:  caller.UserID,
+			ExpiresAt:    time.Now().Add(a.ApprovalTTL),
+		}
+		if err := a.Ap.CreateTransferRequest(ctx, a.DB, &tr); err != nil {
+			return nil, err
+		}
+		return &tr, nil
+	}
+
+	// 指定子账户时从子账户转出，否则只从主余额转出
+	if fromPocketID != 0 {
+		err = a.Pk.TransferFromPocket(ctx, a.DB, fromWallet.ID, fromPocketID, toWalletID, amount)
+	} else {
+		err = a.Rp.WithTx(ctx, func(ctx context.Context, uow *UnitOfWork) error {
+			return transferFromMain(ctx, uow.Wallet, fromWallet.ID, toWalletID, amount)
+		})
+	}
+	recordWalletOperation("transfer", amount, err)
+	if err != nil && errorCodeOf(err) == CodeInternal {
+		loggerFrom(ctx).Error("transfer failed", "error", err)
+		return nil, newAPIError(CodeInternal, "transfer failed")
+	}
+	return nil, err
+}
+
+// 从主余额转账，锁定转出钱包后检查余额，检查和扣款之间余额不会被并发修改，需在 WithTx 中调用
+func transferFromMain(ctx context.Context, tx IWallet, fromWalletID, toWalletID int64, amount float64) error {
+	wallet, err := tx.LockWallet(ctx, fromWalletID)
+	if err != nil {
+		return err
+	}
+	if wallet.Balance < amount {
+		return ErrNotEnough
+	}
+	return tx.ExecTransfer(ctx, fromWalletID, toWalletID, amount)
+}
+
+// 分页查询钱包的交易记录
+func (a *App) listTransactions(ctx context.Context, caller *Principal, walletID int64, limit, offset int) ([]Transaction, error) {
+	setLogAttrs(ctx, slog.Int64("wallet_id", walletID))
+	if limit <= 0 {
+		return nil, newAPIError(CodeInvalidArgument, "invalid limit")
+	}
+	if offset < 0 {
+		return nil, newAPIError(CodeInvalidArgument, "invalid offset")
+	}
+	wallet, err := a.loadWalletFor(ctx, caller, walletID, PermReadTransactions)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := a.Rp.GetTransactionsByWalletID(ctx, wallet.ID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	if transactions == nil {
+		transactions = []Transaction{}
+	}
+	return transactions, nil
+}
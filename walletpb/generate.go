@@ -0,0 +1,5 @@
+// Package walletpb contains the protobuf messages and gRPC service of the
+// wallet API.
+package walletpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative wallet.proto
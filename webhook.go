@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// webhook 请求使用的请求头
+const (
+	HeaderWebhookEventID   = "X-Webhook-Event-ID"
+	HeaderWebhookEventType = "X-Webhook-Event-Type"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// 可以订阅的事件类型
+var webhookEventTypes = map[string]bool{
+	"deposit":  true,
+	"withdraw": true,
+	"transfer": true,
+}
+
+// 投递的重试策略：第 n 次失败后等待 webhookBaseBackoff * 2^(n-1)，最长 webhookMaxBackoff，
+// 失败 webhookMaxAttempts 次后不再重试
+const (
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookMaxAttempts = 8
+
+	// 每轮领取的投递数和单次请求的超时
+	webhookBatchSize = 50
+	webhookTimeout   = 10 * time.Second
+
+	// 领取后其他实例不会再次领取的时间，一轮内的投递依次发送，需要覆盖整轮的请求超时
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// 把发布的事件转为订阅了该事件的 webhook 的投递记录
+type WebhookPublisher struct {
+	DB       *sql.DB
+	Webhooks IWebhook
+}
+
+func (p *WebhookPublisher) Publish(event WalletEvent) error {
+	return p.Webhooks.Emit(context.Background(), p.DB, event)
+}
+
+// 计算 webhook 签名，十六进制编码的 HMAC-SHA256，签名内容为 "时间戳.请求体"
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// 第 attempts 次失败后到下次投递的等待时间
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// 投递所有到期的事件，返回本轮尝试的投递数
+func (a *App) deliverWebhooks(ctx context.Context, now time.Time) (int, error) {
+	deliveries, err := a.Wh.ClaimDueDeliveries(ctx, a.DB, now, webhookLease, webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	// 每次投递按实际发送的时间签名和记录，而不是本轮开始的时间
+	start := time.Now()
+	for i := range deliveries {
+		d := &deliveries[i]
+		sentAt := now.Add(time.Since(start))
+		statusCode, err := postWebhook(d, sentAt)
+		recordWebhookAttempt(d, statusCode, err, sentAt)
+		if err := a.Wh.UpdateDelivery(ctx, a.DB, d); err != nil {
+			log.Printf("failed to update webhook delivery %d: %v", d.ID, err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// 发送一次签名的投递请求，返回响应状态码，非 2xx 的响应视为失败
+func postWebhook(d *WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookEventID, d.EventID)
+	req.Header.Set(HeaderWebhookEventType, d.EventType)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, signWebhook(d.Secret, timestamp, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// 按投递结果更新状态，失败时安排重试，超过最大次数后标记为 dead
+func recordWebhookAttempt(d *WebhookDelivery, statusCode int, err error, now time.Time) {
+	d.Attempts++
+	d.LastStatusCode = statusCode
+	if err == nil {
+		d.Status = DeliveryDelivered
+		d.LastError = ""
+		d.DeliveredAt = &now
+		return
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= webhookMaxAttempts {
+		d.Status = DeliveryDead
+		return
+	}
+	d.Status = DeliveryPending
+	d.NextAttemptAt = now.Add(webhookBackoff(d.Attempts))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// webhook 投递状态
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrWebhookDeliveryNotDead  = errors.New("only dead deliveries can be retried")
+	ErrWebhookLeaseLost        = errors.New("webhook delivery lease lost")
+)
+
+const webhookColumns = `id, url, event_types, secret, wallet_ids, active, created_at`
+
+const webhookDeliveryColumns = `d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.status, d.attempts, d.next_attempt_at,
+	COALESCE(d.last_status_code, 0), COALESCE(d.last_error, ''), d.created_at, d.delivered_at`
+
+type WebhookAccess struct{}
+
+// 保存新的 webhook
+func (wa *WebhookAccess) CreateWebhook(ctx context.Context, db *sql.DB, webhook *Webhook) error {
+	if webhook.WalletIDs == nil {
+		webhook.WalletIDs = []int64{}
+	}
+	webhook.Active = true
+	return db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (url, event_types, secret, wallet_ids)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, webhook.URL, pq.Array(webhook.EventTypes), webhook.Secret, pq.Array(webhook.WalletIDs)).
+		Scan(&webhook.ID, &webhook.CreatedAt)
+}
+
+// 获取全部 webhook，包括已删除的
+func (wa *WebhookAccess) GetWebhooks(ctx context.Context, db *sql.DB) ([]Webhook, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+webhookColumns+" FROM webhooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		if err := rows.Scan(&w.ID, &w.URL, pq.Array(&w.EventTypes), &w.Secret, pq.Array(&w.WalletIDs), &w.Active, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// 停用 webhook，未完成的投递标记为 dead，投递记录保留
+func (wa *WebhookAccess) DeleteWebhook(ctx context.Context, db *sql.DB, webhookID int64) error {
+	// 开始事务
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			loggerFrom(ctx).Error("failed to rollback transaction", "error", err)
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, "UPDATE webhooks SET active = FALSE WHERE id = $1", webhookID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $2, last_error = 'webhook deleted'
+		WHERE webhook_id = $1 AND status = $3
+	`, webhookID, DeliveryDead, DeliveryPending); err != nil {
+		return err
+	}
+
+	// 提交事务
+	return tx.Commit()
+}
+
+// 为订阅了该事件类型和钱包的 webhook 各创建一条待投递记录
+func (wa *WebhookAccess) Emit(ctx context.Context, db *sql.DB, event WalletEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at)
+		SELECT id, $1, $2, $3, $4, $5
+		FROM webhooks
+		WHERE active AND $2 = ANY(event_types)
+			AND (cardinality(wallet_ids) = 0 OR $6 = ANY(wallet_ids) OR $7 = ANY(wallet_ids))
+	`, event.ID, event.Type, string(payload), DeliveryPending, event.CreatedAt, event.WalletID, event.ToWalletID)
+	return err
+}
+
+// 领取到期的投递并把下次投递时间推迟 lease，多个实例不会同时投递同一条记录
+func (wa *WebhookAccess) ClaimDueDeliveries(ctx context.Context, db *sql.DB, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $3 AND next_attempt_at <= $1
+			ORDER BY next_attempt_at, id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+webhookDeliveryColumns+`, w.url, w.secret
+	`, now, now.Add(lease), DeliveryPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanWebhookDelivery(rows, &d, &d.URL, &d.Secret); err != nil {
+			return nil, err
+		}
+		d.LeaseUntil = d.NextAttemptAt
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// 保存一次投递的结果，租约已被其他实例重新领取时不覆盖
+func (wa *WebhookAccess) UpdateDelivery(ctx context.Context, db *sql.DB, d *WebhookDelivery) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_status_code = NULLIF($5, 0), last_error = NULLIF($6, ''), delivered_at = $7
+		WHERE id = $1 AND status = $8 AND next_attempt_at = $9
+	`, d.ID, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, d.DeliveredAt, DeliveryPending, d.LeaseUntil)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWebhookLeaseLost
+	}
+	return nil
+}
+
+// 查询 webhook 的投递记录，最新的在前，status 为空时不过滤
+func (wa *WebhookAccess) GetDeliveries(ctx context.Context, db *sql.DB, webhookID int64, status string, limit int) ([]WebhookDelivery, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1)", webhookID).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		WHERE d.webhook_id = $1 AND ($2 = '' OR d.status = $2)
+		ORDER BY d.id DESC
+		LIMIT $3
+	`, webhookID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := scanWebhookDelivery(rows, &d); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// 将 dead 的投递重新放回队列，重新计算重试次数
+func (wa *WebhookAccess) RetryDelivery(ctx context.Context, db *sql.DB, deliveryID int64, now time.Time) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status = $2, attempts = 0, next_attempt_at = $3
+		WHERE id = $1 AND status = $4
+	`, deliveryID, DeliveryPending, now, DeliveryDead)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM webhook_deliveries WHERE id = $1)", deliveryID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrWebhookDeliveryNotFound
+	}
+	return ErrWebhookDeliveryNotDead
+}
+
+// 扫描 webhookDeliveryColumns，extra 为追加在后面的列
+func scanWebhookDelivery(row rowScanner, d *WebhookDelivery, extra ...interface{}) error {
+	var payload string
+	dest := []interface{}{&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+		&d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	d.Payload = json.RawMessage(payload)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var webhookDeliveryRowColumns = []string{"id", "webhook_id", "event_id", "event_type", "payload", "status", "attempts", "next_attempt_at",
+	"last_status_code", "last_error", "created_at", "delivered_at"}
+
+func TestEmitWebhookEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectExec("INSERT INTO webhook_deliveries .* SELECT id, \\$1, \\$2, \\$3, \\$4, \\$5\\s+FROM webhooks\\s+WHERE active AND \\$2 = ANY\\(event_types\\)").
+		WithArgs("evt_1", "transfer", sqlmock.AnyArg(), DeliveryPending, now, int64(1), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	wa := &WebhookAccess{}
+	event := WalletEvent{ID: "evt_1", Type: "transfer", WalletID: 1, ToWalletID: 2, Amount: 10, CreatedAt: now}
+	if err := wa.Emit(context.Background(), db, event); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestClaimDueDeliveries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("UPDATE webhook_deliveries d SET next_attempt_at = \\$2 .* FOR UPDATE SKIP LOCKED").
+		WithArgs(now, now.Add(time.Minute), DeliveryPending, 50).
+		WillReturnRows(sqlmock.NewRows(append(webhookDeliveryRowColumns, "url", "secret")).
+			AddRow(7, 3, "evt_1", "deposit", `{"id":"evt_1"}`, DeliveryPending, 2, now.Add(time.Minute), 500, "unexpected status 500", now, nil, "https://example.com/hook", "whsec_test"))
+
+	wa := &WebhookAccess{}
+	deliveries, err := wa.ClaimDueDeliveries(context.Background(), db, now, time.Minute, 50)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.ID != 7 || d.Attempts != 2 || d.URL != "https://example.com/hook" || d.Secret != "whsec_test" || string(d.Payload) != `{"id":"evt_1"}` {
+		t.Errorf("unexpected delivery %+v", d)
+	}
+	if !d.LeaseUntil.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected lease until %v, got %v", now.Add(time.Minute), d.LeaseUntil)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpdateDelivery_LeaseLost(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// 租约到期后被其他实例重新领取，next_attempt_at 已改变，不覆盖其结果
+	lease := time.Now()
+	d := &WebhookDelivery{ID: 7, Status: DeliveryDelivered, Attempts: 1, NextAttemptAt: lease, LastStatusCode: 200, LeaseUntil: lease}
+	mock.ExpectExec("UPDATE webhook_deliveries .* WHERE id = \\$1 AND status = \\$8 AND next_attempt_at = \\$9").
+		WithArgs(int64(7), DeliveryDelivered, 1, lease, 200, "", nil, DeliveryPending, lease).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	wa := &WebhookAccess{}
+	if err := wa.UpdateDelivery(context.Background(), db, d); err != ErrWebhookLeaseLost {
+		t.Errorf("expected ErrWebhookLeaseLost, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE webhooks SET active = FALSE WHERE id = \\$1").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2, last_error = 'webhook deleted'").
+		WithArgs(3, DeliveryDead, DeliveryPending).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE webhooks SET active = FALSE WHERE id = \\$1").
+		WithArgs(99).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	wa := &WebhookAccess{}
+	if err := wa.DeleteWebhook(context.Background(), db, 3); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := wa.DeleteWebhook(context.Background(), db, 99); err != ErrWebhookNotFound {
+		t.Errorf("expected ErrWebhookNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRetryDelivery(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2, attempts = 0, next_attempt_at = \\$3").
+		WithArgs(7, DeliveryPending, now, DeliveryDead).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2, attempts = 0, next_attempt_at = \\$3").
+		WithArgs(8, DeliveryPending, now, DeliveryDead).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM webhook_deliveries WHERE id = \\$1\\)").
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE webhook_deliveries SET status = \\$2, attempts = 0, next_attempt_at = \\$3").
+		WithArgs(99, DeliveryPending, now, DeliveryDead).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM webhook_deliveries WHERE id = \\$1\\)").
+		WithArgs(99).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	wa := &WebhookAccess{}
+	if err := wa.RetryDelivery(context.Background(), db, 7, now); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := wa.RetryDelivery(context.Background(), db, 8, now); err != ErrWebhookDeliveryNotDead {
+		t.Errorf("expected ErrWebhookDeliveryNotDead, got %v", err)
+	}
+	if err := wa.RetryDelivery(context.Background(), db, 99, now); err != ErrWebhookDeliveryNotFound {
+		t.Errorf("expected ErrWebhookDeliveryNotFound, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
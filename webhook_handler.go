@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 创建 webhook，未指定密钥时自动生成，密钥只在响应中返回一次
+func (a *App) createWebhookHandler(c *gin.Context) {
+	var request struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+		WalletIDs  []int64  `json:"wallet_ids"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if u, err := url.Parse(request.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook url"})
+		return
+	}
+	if len(request.EventTypes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "event_types are required"})
+		return
+	}
+	for _, eventType := range request.EventTypes {
+		if !webhookEventTypes[eventType] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event type: " + eventType})
+			return
+		}
+	}
+	if request.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		request.Secret = secret
+	}
+
+	webhook := Webhook{
+		URL:        request.URL,
+		EventTypes: request.EventTypes,
+		Secret:     request.Secret,
+		WalletIDs:  request.WalletIDs,
+	}
+	if err := a.Wh.CreateWebhook(c.Request.Context(), a.DB, &webhook); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"webhook": webhook, "secret": webhook.Secret})
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// 查询全部 webhook，不包含密钥
+func (a *App) getWebhooksHandler(c *gin.Context) {
+	webhooks, err := a.Wh.GetWebhooks(c.Request.Context(), a.DB)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []Webhook{}
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+// 删除 webhook，不再投递新的和未完成的事件，投递记录保留
+func (a *App) deleteWebhookHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.Wh.DeleteWebhook(c.Request.Context(), a.DB, req.Id); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook deleted"})
+}
+
+// 查询 webhook 的投递记录，可以按状态过滤，默认返回最近 50 条
+func (a *App) getWebhookDeliveriesHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && status != DeliveryPending && status != DeliveryDelivered && status != DeliveryDead {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+
+	deliveries, err := a.Wh.GetDeliveries(c.Request.Context(), a.DB, req.Id, status, limit)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// 重新投递 dead 的投递记录
+func (a *App) retryWebhookDeliveryHandler(c *gin.Context) {
+	var req AccountRequest
+	if err := c.ShouldBindUri(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.Wh.RetryDelivery(c.Request.Context(), a.DB, req.Id, time.Now()); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "webhook delivery queued for retry"})
+}
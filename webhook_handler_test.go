@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// 在内存中保存 webhook 和投递记录，行为与数据库一致
+type MockWebhookRepo struct {
+	webhooks   []*Webhook
+	deliveries []*WebhookDelivery
+}
+
+func (m *MockWebhookRepo) CreateWebhook(ctx context.Context, db *sql.DB, webhook *Webhook) error {
+	if webhook.WalletIDs == nil {
+		webhook.WalletIDs = []int64{}
+	}
+	webhook.ID = int64(len(m.webhooks) + 1)
+	webhook.Active = true
+	webhook.CreatedAt = time.Now()
+	copied := *webhook
+	m.webhooks = append(m.webhooks, &copied)
+	return nil
+}
+
+func (m *MockWebhookRepo) GetWebhooks(ctx context.Context, db *sql.DB) ([]Webhook, error) {
+	var webhooks []Webhook
+	for _, w := range m.webhooks {
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks, nil
+}
+
+func (m *MockWebhookRepo) DeleteWebhook(ctx context.Context, db *sql.DB, webhookID int64) error {
+	if webhookID < 1 || webhookID > int64(len(m.webhooks)) {
+		return ErrWebhookNotFound
+	}
+	m.webhooks[webhookID-1].Active = false
+	for _, d := range m.deliveries {
+		if d.WebhookID == webhookID && d.Status == DeliveryPending {
+			d.Status, d.LastError = DeliveryDead, "webhook deleted"
+		}
+	}
+	return nil
+}
+
+func (m *MockWebhookRepo) Emit(ctx context.Context, db *sql.DB, event WalletEvent) error {
+	payload, _ := json.Marshal(event)
+	for _, w := range m.webhooks {
+		if !w.Active || !containsString(w.EventTypes, event.Type) {
+			continue
+		}
+		if len(w.WalletIDs) > 0 && !containsInt64(w.WalletIDs, event.WalletID) && !containsInt64(w.WalletIDs, event.ToWalletID) {
+			continue
+		}
+		m.deliveries = append(m.deliveries, &WebhookDelivery{
+			ID:            int64(len(m.deliveries) + 1),
+			WebhookID:     w.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: event.CreatedAt,
+			CreatedAt:     event.CreatedAt,
+		})
+	}
+	return nil
+}
+
+func (m *MockWebhookRepo) ClaimDueDeliveries(ctx context.Context, db *sql.DB, now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	for _, d := range m.deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if d.Status != DeliveryPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		d.NextAttemptAt = now.Add(lease)
+		claimed := *d
+		claimed.LeaseUntil = d.NextAttemptAt
+		w := m.webhooks[d.WebhookID-1]
+		claimed.URL, claimed.Secret = w.URL, w.Secret
+		deliveries = append(deliveries, claimed)
+	}
+	return deliveries, nil
+}
+
+func (m *MockWebhookRepo) UpdateDelivery(ctx context.Context, db *sql.DB, delivery *WebhookDelivery) error {
+	current := m.deliveries[delivery.ID-1]
+	if current.Status != DeliveryPending || !current.NextAttemptAt.Equal(delivery.LeaseUntil) {
+		return ErrWebhookLeaseLost
+	}
+	updated := *delivery
+	updated.URL, updated.Secret, updated.LeaseUntil = "", "", time.Time{}
+	m.deliveries[delivery.ID-1] = &updated
+	return nil
+}
+
+func (m *MockWebhookRepo) GetDeliveries(ctx context.Context, db *sql.DB, webhookID int64, status string, limit int) ([]WebhookDelivery, error) {
+	if webhookID < 1 || webhookID > int64(len(m.webhooks)) {
+		return nil, ErrWebhookNotFound
+	}
+	var deliveries []WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		d := m.deliveries[i]
+		if d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *MockWebhookRepo) RetryDelivery(ctx context.Context, db *sql.DB, deliveryID int64, now time.Time) error {
+	if deliveryID < 1 || deliveryID > int64(len(m.deliveries)) {
+		return ErrWebhookDeliveryNotFound
+	}
+	d := m.deliveries[deliveryID-1]
+	if d.Status != DeliveryDead {
+		return ErrWebhookDeliveryNotDead
+	}
+	d.Status, d.Attempts, d.NextAttemptAt = DeliveryPending, 0, now
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt64(values []int64, value int64) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// 带一个订阅全部事件的 webhook 和一条 dead 的投递记录
+func newMockWebhookRepo() *MockWebhookRepo {
+	m := &MockWebhookRepo{}
+	_ = m.CreateWebhook(context.Background(), nil, &Webhook{URL: "https://example.com/hook", EventTypes: []string{"deposit", "withdraw", "transfer"}, Secret: "whsec_test"})
+	_ = m.Emit(context.Background(), nil, WalletEvent{ID: "evt_1", Type: "deposit", WalletID: 1, Amount: 10, CreatedAt: time.Now()})
+	m.deliveries[0].Status, m.deliveries[0].Attempts = DeliveryDead, webhookMaxAttempts
+	return m
+}
+
+func TestCreateWebhookHandler(t *testing.T) {
+	// Test cases
+	tests := []struct {
+		name           string
+		requestBody    map[string]interface{}
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Generated Secret",
+			requestBody:    map[string]interface{}{"url": "https://example.com/hook", "event_types": []string{"deposit", "transfer"}, "wallet_ids": []int64{1}},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Provided Secret",
+			requestBody:    map[string]interface{}{"url": "http://localhost:9000/hook", "event_types": []string{"withdraw"}, "secret": "shared"},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Invalid URL",
+			requestBody:    map[string]interface{}{"url": "ftp://example.com", "event_types": []string{"deposit"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid webhook url",
+		},
+		{
+			name:           "Missing Event Types",
+			requestBody:    map[string]interface{}{"url": "https://example.com/hook"},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "event_types are required",
+		},
+		{
+			name:           "Unknown Event Type",
+			requestBody:    map[string]interface{}{"url": "https://example.com/hook", "event_types": []string{"pocket_move"}},
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "invalid event type: pocket_move",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Create a new Gin router
+			router := gin.Default()
+
+			// Initialize the app and set up the route
+			wh := &MockWebhookRepo{}
+			a := App{Wh: wh}
+			router.POST("/api/admin/webhooks", a.createWebhookHandler)
+
+			// Create a new HTTP request with the test route and request body
+			jsonBody, _ := json.Marshal(tt.requestBody)
+			req, _ := http.NewRequest("POST", "/api/admin/webhooks", bytes.NewBuffer(jsonBody))
+			req.Header.Set("Content-Type", "application/json")
+
+			// Create a new HTTP response recorder
+			rec := httptest.NewRecorder()
+
+			// Perform the HTTP request
+			router.ServeHTTP(rec, req)
+
+			// Assert that the response status code is as expected
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			var responseBody struct {
+				Webhook map[string]interface{} `json:"webhook"`
+				Secret  string                 `json:"secret"`
+				Error   string                 `json:"error"`
+			}
+			_ = json.Unmarshal(rec.Body.Bytes(), &responseBody)
+			assert.Equal(t, tt.expectedError, responseBody.Error)
+			if tt.expectedStatus == http.StatusCreated {
+				// 密钥只在创建时返回，与保存的一致
+				assert.Len(t, wh.webhooks, 1)
+				assert.Equal(t, wh.webhooks[0].Secret, responseBody.Secret)
+				assert.NotContains(t, responseBody.Webhook, "secret")
+				if secret, ok := tt.requestBody["secret"]; ok {
+					assert.Equal(t, secret, responseBody.Secret)
+				} else {
+					assert.Contains(t, responseBody.Secret, "whsec_")
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveriesHandlers(t *testing.T) {
+	// Create a new Gin router
+	router := gin.Default()
+
+	// Initialize the app and set up the route
+	wh := newMockWebhookRepo()
+	a := App{Wh: wh}
+	router.DELETE("/api/admin/webhooks/:id", a.deleteWebhookHandler)
+	router.GET("/api/admin/webhooks/:id/deliveries", a.getWebhookDeliveriesHandler)
+	router.POST("/api/admin/webhook-deliveries/:id/retry", a.retryWebhookDeliveryHandler)
+
+	serve := func(method, path string) (int, string) {
+		req, _ := http.NewRequest(method, path, nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	// 按状态过滤投递记录
+	code, body := serve("GET", "/api/admin/webhooks/1/deliveries?status=dead")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"event_id":"evt_1"`)
+	code, body = serve("GET", "/api/admin/webhooks/1/deliveries?status=delivered")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"deliveries":[]}`, body)
+	code, _ = serve("GET", "/api/admin/webhooks/1/deliveries?status=failed")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = serve("GET", "/api/admin/webhooks/9/deliveries")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 只有 dead 的投递可以重试，重试后重新计算次数
+	code, _ = serve("POST", "/api/admin/webhook-deliveries/1/retry")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, DeliveryPending, wh.deliveries[0].Status)
+	assert.Equal(t, 0, wh.deliveries[0].Attempts)
+	code, _ = serve("POST", "/api/admin/webhook-deliveries/1/retry")
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = serve("POST", "/api/admin/webhook-deliveries/9/retry")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// 删除后未完成的投递不再重试
+	code, _ = serve("DELETE", "/api/admin/webhooks/1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, wh.webhooks[0].Active)
+	assert.Equal(t, DeliveryDead, wh.deliveries[0].Status)
+	code, _ = serve("DELETE", "/api/admin/webhooks/9")
+	assert.Equal(t, http.StatusNotFound, code)
+}
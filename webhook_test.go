@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookBackoff(1))
+	assert.Equal(t, time.Minute, webhookBackoff(2))
+	assert.Equal(t, 16*time.Minute, webhookBackoff(6))
+	assert.Equal(t, 32*time.Minute, webhookBackoff(7))
+	assert.Equal(t, time.Hour, webhookBackoff(8))
+	assert.Equal(t, time.Hour, webhookBackoff(20))
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	// 本地接收端校验签名，fail 为 true 时返回 500
+	fail := false
+	var received []WalletEvent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(HeaderWebhookTimestamp)
+		if r.Header.Get(HeaderWebhookSignature) != signWebhook("whsec_test", timestamp, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var event WalletEvent
+		_ = json.Unmarshal(body, &event)
+		assert.Equal(t, event.ID, r.Header.Get(HeaderWebhookEventID))
+		assert.Equal(t, event.Type, r.Header.Get(HeaderWebhookEventType))
+		received = append(received, event)
+	}))
+	defer receiver.Close()
+
+	wh := &MockWebhookRepo{}
+	a := App{Wh: wh}
+	require.NoError(t, wh.CreateWebhook(context.Background(), nil, &Webhook{URL: receiver.URL, EventTypes: []string{"deposit", "transfer"}, Secret: "whsec_test", WalletIDs: []int64{2}}))
+
+	// 只为订阅的事件类型和钱包创建投递，转入钱包也算订阅的钱包
+	publisher := &WebhookPublisher{Webhooks: wh}
+	now := time.Now()
+	require.NoError(t, publisher.Publish(WalletEvent{ID: "evt_1", Type: "deposit", WalletID: 1, Amount: 5, CreatedAt: now}))
+	require.NoError(t, publisher.Publish(WalletEvent{ID: "evt_2", Type: "withdraw", WalletID: 2, Amount: 5, CreatedAt: now}))
+	require.NoError(t, publisher.Publish(WalletEvent{ID: "evt_3", Type: "transfer", WalletID: 1, ToWalletID: 2, Amount: 10, CreatedAt: now}))
+	require.Len(t, wh.deliveries, 1)
+
+	// 投递成功
+	n, err := a.deliverWebhooks(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.Len(t, received, 1)
+	assert.Equal(t, "transfer", received[0].Type)
+	assert.Equal(t, int64(2), received[0].ToWalletID)
+	assert.Equal(t, DeliveryDelivered, wh.deliveries[0].Status)
+	assert.Equal(t, http.StatusOK, wh.deliveries[0].LastStatusCode)
+
+	// 已投递的事件不再投递
+	n, err = a.deliverWebhooks(context.Background(), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// 接收端失败时按退避时间重试，到期前不会再次投递
+	fail = true
+	require.NoError(t, publisher.Publish(WalletEvent{ID: "evt_4", Type: "deposit", WalletID: 2, Amount: 1, CreatedAt: now}))
+	d := wh.deliveries[1]
+	at := d.NextAttemptAt
+	_, err = a.deliverWebhooks(context.Background(), at)
+	require.NoError(t, err)
+	d = wh.deliveries[1]
+	assert.Equal(t, DeliveryPending, d.Status)
+	assert.Equal(t, 1, d.Attempts)
+	assert.Equal(t, "unexpected status 500", d.LastError)
+	assert.WithinDuration(t, at.Add(30*time.Second), d.NextAttemptAt, time.Second)
+	n, _ = a.deliverWebhooks(context.Background(), at.Add(29*time.Second))
+	assert.Equal(t, 0, n)
+
+	// 达到最大次数后标记为 dead
+	for i := 1; i < webhookMaxAttempts; i++ {
+		n, err = a.deliverWebhooks(context.Background(), wh.deliveries[1].NextAttemptAt)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	}
+	assert.Equal(t, DeliveryDead, wh.deliveries[1].Status)
+	assert.Equal(t, webhookMaxAttempts, wh.deliveries[1].Attempts)
+	n, _ = a.deliverWebhooks(context.Background(), at.Add(24*time.Hour))
+	assert.Equal(t, 0, n)
+
+	// 手动重试后接收端恢复，投递成功
+	fail = false
+	require.NoError(t, wh.RetryDelivery(context.Background(), nil, wh.deliveries[1].ID, at.Add(24*time.Hour)))
+	_, err = a.deliverWebhooks(context.Background(), at.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, DeliveryDelivered, wh.deliveries[1].Status)
+	assert.Len(t, received, 2)
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	timestamp := strconv.FormatInt(1700000000, 10)
+	signature := signWebhook("secret", timestamp, body)
+	assert.Len(t, signature, 64)
+	assert.Equal(t, signature, signWebhook("secret", timestamp, body))
+	assert.NotEqual(t, signature, signWebhook("other", timestamp, body))
+	assert.NotEqual(t, signature, signWebhook("secret", "1700000001", body))
+}